@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// -blocklist: the IPs the detectors flagged, as firewall / WAF rules ready to load
+
+// set via -blocklist <dir>; the block lists are exported alongside whatever -output produces
+var blockListDir = ""
+
+// set via -allowlist <file> (or the config file's allowlist): IPs / CIDRs never blocked, however they behaved
+var allowListSpec = ""
+
+// set via -blockthreshold <n>: findings an IP needs before it's blocked
+var blockThreshold = 1
+
+// blockList is what gets blocked: flagged IPs less the allowlist, aggregated into
+// the fewest prefixes that cover exactly those IPs (so never anything allowed)
+type blockList struct {
+	source   string
+	at       time.Time
+	flagged  int // IPs at or over the threshold
+	allowed  int // ...of which the allowlist saved
+	prefixes []netip.Prefix
+}
+
+// blockFile is one exported file and how to write it
+type blockFile struct {
+	fileName string
+	write    func(w io.Writer, list blockList)
+}
+
+var blockFiles = []blockFile{
+	{"blocklist.cidr", writeCidrList},
+	{"blocklist.nft", writeNftables},
+	{"blocklist.iptables.sh", writeIptables},
+	{"blocklist.ipset", writeIpset},
+	{"blocklist.nginx.conf", writeNginxDeny},
+}
+
+// writeBlockLists writes each block list format to its own file in dir, creating dir as needed
+func writeBlockLists(dir string, data reportData) error {
+	allow, err := loadAllowList(allowListSpec)
+	if err != nil {
+		return err
+	}
+	allow = append(allow, allowListPrefixes...)
+	list := buildBlockList(data, allow)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, blocks := range blockFiles {
+		file, err := os.Create(filepath.Join(dir, blocks.fileName))
+		if err != nil {
+			return err
+		}
+
+		writer := bufio.NewWriter(file)
+		blocks.write(writer, list)
+
+		err = writer.Flush()
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintln(statusOut, "Blocking", list.flagged-list.allowed, "IPs in", len(list.prefixes), "prefixes,",
+		list.allowed, "more spared by the allowlist")
+	return nil
+}
+
+// loadAllowList reads IPs and CIDRs, one per line; blank lines and # comments are skipped
+func loadAllowList(fileSpec string) ([]netip.Prefix, error) {
+	allow := make([]netip.Prefix, 0)
+	if len(fileSpec) == 0 {
+		return allow, nil
+	}
+
+	file, err := os.Open(fileSpec)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		prefix, err := parsePrefix(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s line# %d: %q s/b an IP or CIDR", fileSpec, lineNum, line)
+		}
+		allow = append(allow, prefix)
+	}
+	return allow, scanner.Err()
+}
+
+// parsePrefix takes a CIDR, or a bare IP as the prefix of just that address
+func parsePrefix(text string) (netip.Prefix, error) {
+	if strings.Contains(text, "/") {
+		prefix, err := netip.ParsePrefix(text)
+		if err != nil {
+			return prefix, err
+		}
+		// an IPv4-mapped prefix is the IPv4 one, as the addresses it's matched against are unmapped
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			return netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96).Masked(), nil
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(text)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// buildBlockList picks the IPs with enough medium or high severity findings against
+// them; a low one (an IP failing a path others don't) is worth a look, not a block
+func buildBlockList(data reportData, allow []netip.Prefix) blockList {
+	counts := make(map[netip.Addr]int)
+	for _, finding := range data.Findings {
+		if severityRanks[finding.Severity] < severityRanks["medium"] {
+			continue
+		}
+		if addr, err := netip.ParseAddr(finding.IP); err == nil {
+			counts[addr.Unmap()]++
+		}
+	}
+
+	list := blockList{source: data.Source, at: time.Now()}
+	blocked := make([]netip.Prefix, 0, len(counts))
+	for addr, count := range counts {
+		if count < blockThreshold {
+			continue
+		}
+		list.flagged++
+		if slices.ContainsFunc(allow, func(prefix netip.Prefix) bool { return prefix.Contains(addr) }) {
+			list.allowed++
+			continue
+		}
+		blocked = append(blocked, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+
+	list.prefixes = aggregatePrefixes(blocked)
+	return list
+}
+
+// aggregatePrefixes merges sibling prefixes into their parent until no more can be,
+// so the result covers exactly the addresses given - no more - in as few prefixes as possible
+func aggregatePrefixes(prefixes []netip.Prefix) []netip.Prefix {
+	comparePrefixes := func(a netip.Prefix, b netip.Prefix) int {
+		if c := a.Addr().Compare(b.Addr()); c != 0 {
+			return c
+		}
+		return a.Bits() - b.Bits()
+	}
+
+	merged := slices.Clone(prefixes)
+	for changed := true; changed; {
+		changed = false
+		slices.SortFunc(merged, comparePrefixes)
+
+		// anything inside the prefix before it adds nothing; sorting puts it straight after
+		kept := merged[:0]
+		for _, prefix := range merged {
+			if len(kept) > 0 && kept[len(kept)-1].Overlaps(prefix) {
+				continue
+			}
+			kept = append(kept, prefix)
+		}
+		merged = kept
+
+		// siblings sort next to each other; both halves present make their parent
+		next := make([]netip.Prefix, 0, len(merged))
+		for i := 0; i < len(merged); i++ {
+			prefix := merged[i]
+			if i+1 < len(merged) && prefix.Bits() > 0 && prefix.Bits() == merged[i+1].Bits() {
+				parent := netip.PrefixFrom(prefix.Addr(), prefix.Bits()-1).Masked()
+				if parent.Addr() == prefix.Addr() && parent.Contains(merged[i+1].Addr()) {
+					next = append(next, parent)
+					i++
+					changed = true
+					continue
+				}
+			}
+			next = append(next, prefix)
+		}
+		merged = next
+	}
+	return merged
+}
+
+func (list blockList) byFamily() ([]netip.Prefix, []netip.Prefix) {
+	ipv4 := make([]netip.Prefix, 0)
+	ipv6 := make([]netip.Prefix, 0)
+	for _, prefix := range list.prefixes {
+		if prefix.Addr().Is4() {
+			ipv4 = append(ipv4, prefix)
+		} else {
+			ipv6 = append(ipv6, prefix)
+		}
+	}
+	return ipv4, ipv6
+}
+
+func (list blockList) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# generated by the network detective from %s at %s\n", list.source, list.at.Format(time.RFC3339))
+	fmt.Fprintf(w, "# %d IPs flagged (%d+ findings each), %d allowlisted, %d prefixes blocked\n",
+		list.flagged, blockThreshold, list.allowed, len(list.prefixes))
+}
+
+// writeCidrList is the plain list, one prefix per line, for anything that reads one
+func writeCidrList(w io.Writer, list blockList) {
+	list.writeHeader(w)
+	for _, prefix := range list.prefixes {
+		fmt.Fprintln(w, prefix)
+	}
+}
+
+// writeNftables is an nft -f script; it replaces its own table, so it can be rerun
+func writeNftables(w io.Writer, list blockList) {
+	ipv4, ipv6 := list.byFamily()
+
+	fmt.Fprintln(w, "#!/usr/sbin/nft -f")
+	list.writeHeader(w)
+	fmt.Fprintln(w, "table inet detective {}")
+	fmt.Fprintln(w, "delete table inet detective")
+	fmt.Fprintln(w, "table inet detective {")
+	nftSet := func(name string, addrType string, prefixes []netip.Prefix) {
+		fmt.Fprintf(w, "\tset %s {\n\t\ttype %s\n\t\tflags interval\n", name, addrType)
+		if len(prefixes) > 0 {
+			fmt.Fprintln(w, "\t\telements = {")
+			for i, prefix := range prefixes {
+				separator := ","
+				if i == len(prefixes)-1 {
+					separator = ""
+				}
+				fmt.Fprintf(w, "\t\t\t%s%s\n", prefix, separator)
+			}
+			fmt.Fprintln(w, "\t\t}")
+		}
+		fmt.Fprintln(w, "\t}")
+	}
+	nftSet("blocked4", "ipv4_addr", ipv4)
+	nftSet("blocked6", "ipv6_addr", ipv6)
+	fmt.Fprintln(w, "\tchain input {")
+	fmt.Fprintln(w, "\t\ttype filter hook input priority filter - 10; policy accept;")
+	fmt.Fprintln(w, "\t\tip saddr @blocked4 drop")
+	fmt.Fprintln(w, "\t\tip6 saddr @blocked6 drop")
+	fmt.Fprintln(w, "\t}")
+	fmt.Fprintln(w, "}")
+}
+
+// writeIptables is a shell script keeping the rules in their own DETECTIVE chain,
+// flushed and refilled each run
+func writeIptables(w io.Writer, list blockList) {
+	ipv4, ipv6 := list.byFamily()
+
+	fmt.Fprintln(w, "#!/bin/sh")
+	list.writeHeader(w)
+	for _, family := range []struct {
+		command  string
+		prefixes []netip.Prefix
+	}{{"iptables", ipv4}, {"ip6tables", ipv6}} {
+		fmt.Fprintf(w, "%s -N DETECTIVE 2>/dev/null || %s -F DETECTIVE\n", family.command, family.command)
+		fmt.Fprintf(w, "%s -C INPUT -j DETECTIVE 2>/dev/null || %s -I INPUT -j DETECTIVE\n", family.command, family.command)
+		for _, prefix := range family.prefixes {
+			fmt.Fprintf(w, "%s -A DETECTIVE -s %s -j DROP\n", family.command, prefix)
+		}
+	}
+}
+
+// writeIpset is for ipset restore; match-set rules referencing the sets do the blocking
+func writeIpset(w io.Writer, list blockList) {
+	ipv4, ipv6 := list.byFamily()
+
+	list.writeHeader(w)
+	for _, set := range []struct {
+		name     string
+		family   string
+		prefixes []netip.Prefix
+	}{{"detective-blocked4", "inet", ipv4}, {"detective-blocked6", "inet6", ipv6}} {
+		fmt.Fprintf(w, "create %s hash:net family %s -exist\n", set.name, set.family)
+		fmt.Fprintf(w, "flush %s\n", set.name)
+		for _, prefix := range set.prefixes {
+			fmt.Fprintf(w, "add %s %s -exist\n", set.name, prefix)
+		}
+	}
+}
+
+// writeNginxDeny is an include for an http, server or location block
+func writeNginxDeny(w io.Writer, list blockList) {
+	list.writeHeader(w)
+	for _, prefix := range list.prefixes {
+		fmt.Fprintf(w, "deny %s;\n", prefix)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+)
+
+func prefixes(t *testing.T, texts ...string) []netip.Prefix {
+	t.Helper()
+	list := make([]netip.Prefix, 0, len(texts))
+	for _, text := range texts {
+		prefix, err := parsePrefix(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		list = append(list, prefix)
+	}
+	return list
+}
+
+func TestAggregatePrefixes(t *testing.T) {
+	cases := []struct {
+		name   string
+		given  []string
+		merged []string
+	}{
+		{"none", nil, []string{}},
+		{"one", []string{"10.0.0.1"}, []string{"10.0.0.1/32"}},
+		{"siblings", []string{"10.0.0.0", "10.0.0.1"}, []string{"10.0.0.0/31"}},
+		{"not siblings, though adjacent", []string{"10.0.0.1", "10.0.0.2"}, []string{"10.0.0.1/32", "10.0.0.2/32"}},
+		{"siblings all the way up", []string{"10.0.0.3", "10.0.0.0", "10.0.0.2", "10.0.0.1"}, []string{"10.0.0.0/30"}},
+		{"three of four", []string{"10.0.0.0", "10.0.0.1", "10.0.0.2"}, []string{"10.0.0.0/31", "10.0.0.2/32"}},
+		{"contained", []string{"10.0.0.0/24", "10.0.0.7", "10.0.0.128/25"}, []string{"10.0.0.0/24"}},
+		{"duplicates", []string{"10.0.0.7", "10.0.0.7"}, []string{"10.0.0.7/32"}},
+		{"merged into a sibling", []string{"10.0.0.0/25", "10.0.0.128", "10.0.0.129/32", "10.0.0.130/31", "10.0.0.132/30",
+			"10.0.0.136/29", "10.0.0.144/28", "10.0.0.160/27", "10.0.0.192/26"}, []string{"10.0.0.0/24"}},
+		{"the whole space", []string{"0.0.0.0/1", "128.0.0.0/1"}, []string{"0.0.0.0/0"}},
+		{"v6 siblings", []string{"2001:db8::7", "2001:db8::6"}, []string{"2001:db8::6/127"}},
+		{"mixed families", []string{"2001:db8::1", "10.0.0.1", "2001:db8::", "10.0.0.0", "192.168.1.1"},
+			[]string{"10.0.0.0/31", "192.168.1.1/32", "2001:db8::/127"}},
+		{"v4 and its v4-compatible v6 don't merge", []string{"0.0.0.1", "::"}, []string{"0.0.0.1/32", "::/128"}},
+		{"IPv4-mapped", []string{"::ffff:10.0.0.0", "10.0.0.1"}, []string{"10.0.0.0/31"}},
+		{"IPv4-mapped prefix", []string{"::ffff:10.0.0.0/120", "10.0.0.9"}, []string{"10.0.0.0/24"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			merged := aggregatePrefixes(prefixes(t, c.given...))
+			if fmt.Sprint(merged) != fmt.Sprint(c.merged) {
+				t.Errorf("aggregated %v, expected %v", merged, c.merged)
+			}
+		})
+	}
+}
+
+func TestBuildBlockList(t *testing.T) {
+	finding := func(severity string, ip string) reportFinding {
+		return reportFinding{Detector: "brute-force", Severity: severity, IP: ip}
+	}
+	cases := []struct {
+		name      string
+		threshold int
+		findings  []reportFinding
+		allow     []string
+		blocked   []string
+		flagged   int
+		allowed   int
+	}{
+		{"a pair merges", 1, []reportFinding{finding("high", "10.0.0.8"), finding("medium", "10.0.0.9")},
+			nil, []string{"10.0.0.8/31"}, 2, 0},
+		{"an allowlisted IP splits the pair", 1, []reportFinding{finding("high", "10.0.0.8"), finding("high", "10.0.0.9")},
+			[]string{"10.0.0.9"}, []string{"10.0.0.8/32"}, 2, 1},
+		{"an allowlisted CIDR", 1, []reportFinding{finding("high", "10.0.0.8"), finding("high", "10.0.0.9"), finding("high", "10.0.1.1")},
+			[]string{"10.0.0.0/24"}, []string{"10.0.1.1/32"}, 3, 2},
+		{"an allowlisted IPv4-mapped CIDR", 1, []reportFinding{finding("high", "10.0.0.8"), finding("high", "10.0.0.9")},
+			[]string{"::ffff:10.0.0.8/127"}, []string{}, 2, 2},
+		{"IPv4-mapped findings", 1, []reportFinding{finding("high", "::ffff:10.0.0.8"), finding("high", "10.0.0.9")},
+			nil, []string{"10.0.0.8/31"}, 2, 0},
+		{"a low finding isn't a block", 1, []reportFinding{finding("low", "10.0.0.8"), finding("high", "10.0.0.9")},
+			nil, []string{"10.0.0.9/32"}, 1, 0},
+		{"under the threshold", 2, []reportFinding{finding("high", "10.0.0.8"), finding("high", "10.0.0.9"), finding("medium", "10.0.0.9")},
+			nil, []string{"10.0.0.9/32"}, 1, 0},
+		{"findings without an IP", 1, []reportFinding{{Detector: "spike", Severity: "high"}, finding("high", "2001:db8::7")},
+			nil, []string{"2001:db8::7/128"}, 1, 0},
+	}
+	was := blockThreshold
+	t.Cleanup(func() { blockThreshold = was })
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			blockThreshold = c.threshold
+			list := buildBlockList(reportData{Findings: c.findings}, prefixes(t, c.allow...))
+			if fmt.Sprint(list.prefixes) != fmt.Sprint(c.blocked) {
+				t.Errorf("blocked %v, expected %v", list.prefixes, c.blocked)
+			}
+			if list.flagged != c.flagged || list.allowed != c.allowed {
+				t.Errorf("%d flagged, %d allowed, expected %d and %d", list.flagged, list.allowed, c.flagged, c.allowed)
+			}
+		})
+	}
+}
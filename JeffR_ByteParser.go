@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"time"
+)
+
+// stringInterner hands back one shared string per distinct ip / method / path,
+// so repeated values cost a map lookup rather than an allocation
+type stringInterner map[string]string
+
+func (in stringInterner) intern(b []byte) string {
+	if s, ok := in[string(b)]; ok { // no allocation for the lookup
+		return s
+	}
+	s := string(b)
+	in[s] = s
+	return s
+}
+
+// isAsciiSpace matches exactly the ASCII runes unicode.IsSpace accepts
+func isAsciiSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\v' || c == '\f' || c == '\r'
+}
+
+func trimAsciiSpace(b []byte) []byte {
+	for len(b) > 0 && isAsciiSpace(b[0]) {
+		b = b[1:]
+	}
+	for len(b) > 0 && isAsciiSpace(b[len(b)-1]) {
+		b = b[:len(b)-1]
+	}
+	return b
+}
+
+// parseDigits decodes a fixed-width run of ASCII digits
+func parseDigits(b []byte) (int, bool) {
+	v := 0
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		v = v*10 + int(c-'0')
+	}
+	return v, true
+}
+
+// parseTimestampBytes decodes the fixed `yyyy-mm-ddThh24:mm:ss` layout,
+// accepting only what time.Parse would
+func parseTimestampBytes(b []byte) (time.Time, bool) {
+	if len(b) != 19 || b[4] != '-' || b[7] != '-' || b[10] != 'T' || b[13] != ':' || b[16] != ':' {
+		return time.Time{}, false
+	}
+
+	year, ok1 := parseDigits(b[0:4])
+	month, ok2 := parseDigits(b[5:7])
+	day, ok3 := parseDigits(b[8:10])
+	hour, ok4 := parseDigits(b[11:13])
+	minute, ok5 := parseDigits(b[14:16])
+	second, ok6 := parseDigits(b[17:19])
+
+	if !(ok1 && ok2 && ok3 && ok4 && ok5 && ok6) {
+		return time.Time{}, false
+	}
+
+	if month < 1 || month > 12 || day < 1 || hour > 23 || minute > 59 || second > 59 {
+		return time.Time{}, false
+	}
+
+	// days in month, via day 0 of the following month
+	if day > time.Date(year, time.Month(month)+1, 0, 0, 0, 0, 0, time.UTC).Day() {
+		return time.Time{}, false
+	}
+
+	return time.Date(year, time.Month(month), day, hour, minute, second, 0, LOG_LOCATION), true
+}
+
+// parseLogLineBytes is the allocation-free equivalent of parseLogLine for an
+// untrimmed raw line; ok is false for blank lines. Anything off the beaten path
+// (non-ASCII, empty fields, odd timestamps, signed statuses...) is handed to
+// parseLogLine so results and errors are always identical
+func parseLogLineBytes(raw []byte, in stringInterner) (item networkDataItem, ok bool, lineErr *logLineError) {
+
+	line := trimAsciiSpace(raw)
+
+	for _, c := range line {
+		if c >= 0x80 {
+			return parseLogLineFallback(raw)
+		}
+	}
+
+	if len(line) == 0 {
+		return networkDataItem{}, false, nil
+	}
+
+	var fields [4][]byte
+	rest := line
+	for i := 0; i < 3; i++ {
+		comma := bytes.IndexByte(rest, ',')
+		if comma <= 0 {
+			return parseLogLineFallback(raw)
+		}
+		fields[i] = rest[:comma]
+		rest = rest[comma+1:]
+	}
+	if len(rest) == 0 || bytes.IndexByte(rest, ',') >= 0 {
+		return parseLogLineFallback(raw)
+	}
+	fields[3] = rest
+
+	timestamp, tsOk := parseTimestampBytes(fields[0])
+	if !tsOk || TIMESTAMP_LAYOUT != LOG_TIMESTAMP_LAYOUT {
+		return parseLogLineFallback(raw)
+	}
+
+	ipAddr := trimAsciiSpace(fields[1])
+
+	methodPath := trimAsciiSpace(fields[2])
+	split := 0
+	for split < len(methodPath) && !isAsciiSpace(methodPath[split]) {
+		split++
+	}
+	method := methodPath[:split]
+	path := trimAsciiSpace(methodPath[split:])
+	for _, c := range path {
+		if isAsciiSpace(c) {
+			return parseLogLineFallback(raw)
+		}
+	}
+	if len(method) == 0 || len(path) == 0 {
+		return parseLogLineFallback(raw)
+	}
+	for _, c := range method {
+		if c >= 'a' && c <= 'z' {
+			return parseLogLineFallback(raw)
+		}
+	}
+
+	if len(fields[3]) > 9 {
+		return parseLogLineFallback(raw)
+	}
+	statusCode, statusOk := parseDigits(fields[3])
+	if !statusOk {
+		return parseLogLineFallback(raw)
+	}
+
+	return networkDataItem{timestamp, in.intern(ipAddr), in.intern(method), in.intern(path), statusCode}, true, nil
+}
+
+func parseLogLineFallback(raw []byte) (networkDataItem, bool, *logLineError) {
+	line := string(bytes.TrimSpace(raw))
+	if len(line) == 0 {
+		return networkDataItem{}, false, nil
+	}
+	item, lineErr := parseLogLine(line)
+	return item, lineErr == nil, lineErr
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// FuzzParseLogLineBytes differentially checks parseLogLineBytes against parseLogLine, from
+// tricky lines and every line of the sample logs:
+//
+//	go test JeffR_*.go -run '^$' -fuzz FuzzParseLogLineBytes -fuzztime 1m
+func FuzzParseLogLineBytes(f *testing.F) {
+	seeds := []string{
+		"2023-03-15T08:00:00,192.168.1.1,GET /index.html,200",
+		"  2023-03-15T08:00:02 , 192.168.1.2 ,\tpost   /login , 403",
+		"2023-02-29T08:00:00,192.168.1.1,GET /index.html,200",
+		"2024-02-29T24:00:00,::1,GET /index.html,+200",
+		"2023-03-15T08:00:00,,192.168.1.1,GET /a b,200",
+		"2023-03-15T08:00:00,192.168.1.1,GET /index.html,200\r",
+		"2023-03-15T08:00:00,192.168.1.1,GET\xc2\xa0/index.html,200",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+	for _, fileSpec := range sampleLogs(f) {
+		file, err := os.Open(fileSpec)
+		if err != nil {
+			f.Fatal(err)
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			f.Add(scanner.Text())
+		}
+		file.Close()
+	}
+
+	f.Fuzz(func(t *testing.T, line string) {
+		expected, expectedErr := networkDataItem{}, (*logLineError)(nil)
+		if trimmed := string(bytes.TrimSpace([]byte(line))); len(trimmed) > 0 {
+			expected, expectedErr = parseLogLine(trimmed)
+		}
+
+		actual, _, actualErr := parseLogLineBytes([]byte(line), make(stringInterner))
+
+		if !reflect.DeepEqual(expected, actual) || !reflect.DeepEqual(expectedErr, actualErr) {
+			t.Errorf("%q\n  parseLogLine:      %v %v\n  parseLogLineBytes: %v %v", line, expected, expectedErr, actual, actualErr)
+		}
+	})
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+// bump whenever the checkpoint layout changes; older checkpoints are then rejected
+const CHECKPOINT_VERSION = 2
+
+// set via -checkpoint <file>; empty means every run starts from nothing
+var checkpointSpec = ""
+
+// gob only sees exported fields, so the checkpoint mirrors the analyzer state in exported form
+
+type checkpointItem struct {
+	Timestamp  time.Time
+	IPAddr     string
+	Method     string
+	Path       string
+	StatusCode int
+}
+
+type checkpointVolumeKey struct {
+	Weekday   time.Weekday
+	TimeOfDay time.Duration
+}
+
+// weight isn't kept, analyze() derives it
+type checkpointResults struct {
+	Succeeded int64
+	Failed    int64
+	MinTOD    time.Duration
+	MaxTOD    time.Duration
+}
+
+type checkpointTraffic struct {
+	ByPath    map[string]map[string]checkpointResults
+	ByWeekday map[time.Weekday]checkpointResults
+}
+
+// checkpointSource records how far into a log we've read, and which file that was
+type checkpointSource struct {
+	Inode   uint64 // 0 where the platform doesn't have one
+	Offset  int64  // just past the last complete line ingested
+	LineNum int
+}
+
+// checkpointState is everything storeData accumulates; byIP and trafficDays
+// are rebuilt from the items (the latter by analyze())
+type checkpointState struct {
+	Version           int
+	Sources           map[string]checkpointSource // by absolute log path
+	Items             []checkpointItem
+	TrafficVolume     map[checkpointVolumeKey]int
+	TrafficByIP       map[string]checkpointTraffic
+	RequestsByIP      map[string]int
+	FailedLoginsByIP  map[string]int
+	MinTime           time.Time
+	MaxTime           time.Time
+	DuplicatesDropped int
+	Settings          string // settingsFingerprint() when saved
+}
+
+// fileInode digs the inode out of the platform specific stat data, without
+// needing per-platform source files
+func fileInode(fileInfo fs.FileInfo) uint64 {
+	sys := reflect.ValueOf(fileInfo.Sys())
+	if sys.Kind() == reflect.Pointer && !sys.IsNil() && sys.Elem().Kind() == reflect.Struct {
+		ino := sys.Elem().FieldByName("Ino")
+		if ino.IsValid() && ino.CanUint() {
+			return ino.Uint()
+		}
+	}
+	return 0
+}
+
+func toCheckpointResults(r results) checkpointResults {
+	return checkpointResults{r.succeeded, r.failed, r.minTOD, r.maxTOD}
+}
+
+func fromCheckpointResults(r checkpointResults) results {
+	return results{r.Succeeded, r.Failed, r.MinTOD, r.MaxTOD, 0}
+}
+
+// saveCheckpoint writes the current data set (and read positions) atomically
+func saveCheckpoint(fileSpec string, sources map[string]checkpointSource) error {
+	state := checkpointState{
+		Version:           CHECKPOINT_VERSION,
+		Sources:           sources,
+		Items:             make([]checkpointItem, 0, len(networkData)),
+		TrafficVolume:     make(map[checkpointVolumeKey]int),
+		TrafficByIP:       make(map[string]checkpointTraffic),
+		RequestsByIP:      requestsByIP,
+		FailedLoginsByIP:  failedLoginsByIP,
+		MinTime:           minTime,
+		MaxTime:           maxTime,
+		DuplicatesDropped: duplicatesDropped,
+		Settings:          settingsFingerprint(),
+	}
+
+	for _, item := range networkData {
+		state.Items = append(state.Items, checkpointItem{item.timestamp, item.ipAddr, item.method, item.path, item.statusCode})
+	}
+
+	for key, count := range trafficVolume {
+		state.TrafficVolume[checkpointVolumeKey{key.weekday, key.timeOfDay}] = count
+	}
+
+	for ipAddr, details := range trafficByIP {
+		traffic := checkpointTraffic{make(map[string]map[string]checkpointResults), make(map[time.Weekday]checkpointResults)}
+		for path, methods := range details.byPath {
+			traffic.ByPath[path] = make(map[string]checkpointResults)
+			for method, resultsVal := range methods {
+				traffic.ByPath[path][method] = toCheckpointResults(resultsVal)
+			}
+		}
+		for weekday, resultsVal := range details.byWeekday {
+			traffic.ByWeekday[weekday] = toCheckpointResults(resultsVal)
+		}
+		state.TrafficByIP[ipAddr] = traffic
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(fileSpec), filepath.Base(fileSpec)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	writer := bufio.NewWriter(temp)
+	err = gob.NewEncoder(writer).Encode(state)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), fileSpec)
+}
+
+// loadCheckpoint restores the data set saved by saveCheckpoint, returning its read positions;
+// a missing checkpoint is a fresh start
+func loadCheckpoint(fileSpec string) (map[string]checkpointSource, error) {
+	sources := make(map[string]checkpointSource)
+
+	file, err := os.Open(fileSpec)
+	if errors.Is(err, fs.ErrNotExist) {
+		return sources, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var state checkpointState
+	if err := gob.NewDecoder(bufio.NewReader(file)).Decode(&state); err != nil {
+		return nil, fmt.Errorf("unreadable checkpoint %s: %w", fileSpec, err)
+	}
+
+	if state.Version != CHECKPOINT_VERSION {
+		return nil, fmt.Errorf("checkpoint %s is version %d, expected %d", fileSpec, state.Version, CHECKPOINT_VERSION)
+	}
+
+	if state.Settings != settingsFingerprint() {
+		return nil, fmt.Errorf("checkpoint %s was saved under other settings (%s), not %s", fileSpec, state.Settings, settingsFingerprint())
+	}
+
+	resetTrafficData()
+
+	for i, item := range state.Items {
+		networkData = append(networkData, networkDataItem{item.Timestamp, item.IPAddr, item.Method, item.Path, item.StatusCode})
+		byIP[item.IPAddr] = append(byIP[item.IPAddr], i)
+		requestsByStatusClass[item.StatusCode/100]++
+	}
+
+	for key, count := range state.TrafficVolume {
+		trafficVolume[trafficVolumeKey{key.Weekday, key.TimeOfDay}] = count
+	}
+
+	for ipAddr, traffic := range state.TrafficByIP {
+		details := trafficDetails{make(map[string]map[string]results), make(map[time.Weekday]results)}
+		for path, methods := range traffic.ByPath {
+			details.byPath[path] = make(map[string]results)
+			for method, resultsVal := range methods {
+				details.byPath[path][method] = fromCheckpointResults(resultsVal)
+			}
+		}
+		for weekday, resultsVal := range traffic.ByWeekday {
+			details.byWeekday[weekday] = fromCheckpointResults(resultsVal)
+		}
+		trafficByIP[ipAddr] = details
+	}
+
+	if state.RequestsByIP != nil {
+		requestsByIP = state.RequestsByIP
+	}
+	if state.FailedLoginsByIP != nil {
+		failedLoginsByIP = state.FailedLoginsByIP
+	}
+	minTime = state.MinTime
+	maxTime = state.MaxTime
+	duplicatesDropped = state.DuplicatesDropped
+
+	if state.Sources != nil {
+		sources = state.Sources
+	}
+
+	return sources, nil
+}
+
+// ingestFromCheckpoint resumes the data set from the checkpoint, reads only what was
+// appended to the log since, then saves the grown data set back.
+// A different inode or a shorter file means the log was rotated/truncated, so it's read from the start.
+// An unterminated last line is left for the next run, as it may still be being written.
+// Returns #lines read this run, #data lines stored and whether the log was valid
+func ingestFromCheckpoint(fileSpec string) (int, int, bool) {
+
+	sources, err := loadCheckpoint(checkpointSpec)
+	if err != nil {
+		log.Println("ERR:", err)
+		return 0, 0, false
+	}
+
+	absSpec, err := filepath.Abs(fileSpec)
+	if err != nil {
+		log.Println(err)
+		return 0, 0, false
+	}
+
+	file, err := os.Open(fileSpec)
+	if err != nil {
+		log.Println(err)
+		return 0, 0, false
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		log.Println(err)
+		return 0, 0, false
+	}
+
+	source, known := sources[absSpec]
+	inode := fileInode(fileInfo)
+
+	if known && (source.Inode != inode || fileInfo.Size() < source.Offset) {
+		fmt.Fprintln(statusOut, "Log was rotated or truncated since the checkpoint, reading it from the start")
+		source = checkpointSource{}
+	}
+	source.Inode = inode
+
+	fmt.Fprintln(statusOut, "Resuming with", len(networkData), "data points, from line#", source.LineNum+1)
+
+	if _, err := file.Seek(source.Offset, io.SeekStart); err != nil {
+		log.Println(err)
+		return 0, 0, false
+	}
+
+	reader := bufio.NewReader(file)
+	interner := make(stringInterner)
+
+	lineNum := 0
+	dataLines := 0
+
+	for {
+		raw, err := reader.ReadSlice('\n')
+
+		if err == bufio.ErrBufferFull {
+			// overlong line, fall back to collecting it whole
+			rest, restErr := reader.ReadBytes('\n')
+			raw, err = append(append([]byte(nil), raw...), rest...), restErr
+		}
+
+		if err == io.EOF {
+			// nothing, or a partial line still being written
+			break
+		} else if err != nil {
+			log.Println(err)
+			return lineNum, dataLines, false
+		}
+
+		item, ok, lineErr := parseLogLineBytes(raw, interner)
+
+		if lineErr != nil {
+			lineErr.log(source.LineNum + lineNum + 1)
+			return lineNum, dataLines, false
+		}
+
+		if ok {
+			storeData(item.timestamp, item.ipAddr, item.method, item.path, item.statusCode)
+			dataLines++
+		}
+
+		lineNum++
+		source.Offset += int64(len(raw))
+	}
+
+	source.LineNum += lineNum
+	sources[absSpec] = source
+
+	orderNetworkData()
+
+	if err := saveCheckpoint(checkpointSpec, sources); err != nil {
+		log.Println("ERR: unable to save checkpoint:", err)
+		return lineNum, dataLines, false
+	}
+
+	return lineNum, dataLines, true
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// withCheckpoint has loadLogFile resume from / save to a checkpoint in the test's directory
+func withCheckpoint(t *testing.T) {
+	was := checkpointSpec
+	t.Cleanup(func() { checkpointSpec = was })
+	checkpointSpec = filepath.Join(t.TempDir(), "state.gob")
+}
+
+// checkpointLog is a generated log, cut into whole lines at about the fractions given
+func checkpointLog(t *testing.T, fractions ...float64) [][]byte {
+	t.Helper()
+	var generated bytes.Buffer
+	if err := generateSampleLog(&generated, 128*1024); err != nil {
+		t.Fatal(err)
+	}
+	content := generated.Bytes()
+	parts, start := make([][]byte, 0, len(fractions)+1), 0
+	for _, fraction := range fractions {
+		cut := int(fraction * float64(len(content)))
+		end := cut + bytes.IndexByte(content[cut:], '\n') + 1
+		parts = append(parts, content[start:end])
+		start = end
+	}
+	return append(parts, content[start:])
+}
+
+// jsonReport is the analysis of what's loaded, as the JSON report
+func jsonReport(t *testing.T, data reportData) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	if err := writeJsonReport(&out, data); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+// fullRun is the report of a single run over the whole log, without a checkpoint
+func fullRun(t *testing.T, content []byte) []byte {
+	t.Helper()
+	was := checkpointSpec
+	checkpointSpec = ""
+	defer func() { checkpointSpec = was }()
+	fileSpec := filepath.Join(t.TempDir(), "traffic.log")
+	if err := os.WriteFile(fileSpec, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return jsonReport(t, analyzeSample(t, fileSpec))
+}
+
+func writeLog(t *testing.T, fileSpec string, content []byte, flag int) {
+	t.Helper()
+	file, err := os.OpenFile(fileSpec, flag|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func compareReports(t *testing.T, want []byte, got []byte) {
+	t.Helper()
+	if !bytes.Equal(want, got) {
+		t.Errorf("resumed report differs from a single run's, at %s", firstDifference(want, got))
+	}
+}
+
+// each run is a restart: it has only the checkpoint, and reads only what was appended,
+// including the rest of a line that was still being written last time
+func TestCheckpointResumesAfterRestart(t *testing.T) {
+	withCheckpoint(t)
+	parts := checkpointLog(t, 0.3, 0.7)
+	fileSpec := filepath.Join(t.TempDir(), "traffic.log")
+
+	half := len(parts[1]) / 2 // a line split between runs
+	writeLog(t, fileSpec, parts[0], os.O_CREATE|os.O_TRUNC)
+	analyzeSample(t, fileSpec)
+	writeLog(t, fileSpec, parts[1][:half], os.O_APPEND)
+	analyzeSample(t, fileSpec)
+	writeLog(t, fileSpec, parts[1][half:], os.O_APPEND)
+	writeLog(t, fileSpec, parts[2], os.O_APPEND)
+	resumed := jsonReport(t, analyzeSample(t, fileSpec))
+
+	compareReports(t, fullRun(t, bytes.Join(parts, nil)), resumed)
+
+	// and a run with nothing appended changes nothing
+	compareReports(t, resumed, jsonReport(t, analyzeSample(t, fileSpec)))
+}
+
+// a rotated log is a new file: read from its start, on top of what the old one held,
+// though it's grown past the checkpoint's offset
+func TestCheckpointAfterRotation(t *testing.T) {
+	withCheckpoint(t)
+	parts := checkpointLog(t, 0.4)
+	if len(parts[1]) <= len(parts[0]) {
+		t.Fatal("the new log s/b longer than the checkpointed one")
+	}
+	fileSpec := filepath.Join(t.TempDir(), "traffic.log")
+
+	writeLog(t, fileSpec, parts[0], os.O_CREATE|os.O_TRUNC)
+	analyzeSample(t, fileSpec)
+	if err := os.Rename(fileSpec, fileSpec+".1"); err != nil { // kept, so the new file can't reuse the inode
+		t.Fatal(err)
+	}
+	writeLog(t, fileSpec, parts[1], os.O_CREATE|os.O_TRUNC)
+	resumed := jsonReport(t, analyzeSample(t, fileSpec))
+
+	compareReports(t, fullRun(t, bytes.Join(parts, nil)), resumed)
+}
+
+// a log truncated in place, and written again shorter than the checkpoint's offset, is read from its start
+func TestCheckpointAfterTruncation(t *testing.T) {
+	withCheckpoint(t)
+	parts := checkpointLog(t, 0.6)
+	if len(parts[1]) >= len(parts[0]) {
+		t.Fatal("the rewritten log s/b shorter than the checkpointed one")
+	}
+	fileSpec := filepath.Join(t.TempDir(), "traffic.log")
+
+	writeLog(t, fileSpec, parts[0], os.O_CREATE|os.O_TRUNC)
+	analyzeSample(t, fileSpec)
+	writeLog(t, fileSpec, parts[1], os.O_TRUNC)
+	resumed := jsonReport(t, analyzeSample(t, fileSpec))
+
+	compareReports(t, fullRun(t, bytes.Join(parts, nil)), resumed)
+}
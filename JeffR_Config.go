@@ -0,0 +1,626 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // so input.timezone works wherever the zoneinfo database isn't installed
+)
+
+// -config: the tunables - log format, analysis, detectors, allowlist, outputs and gates -
+// from a YAML file, so a deployment needn't spell them out on every command line
+
+// set via -config <file>; else the first of configDiscovery() that exists, if any
+var configSpec = ""
+
+// set via the config file's allowlist.ips: IPs / CIDRs never blocked, on top of -allowlist's
+var allowListPrefixes []netip.Prefix
+
+// configDiscovery is where a config file is looked for when -config doesn't name one:
+// the current directory, then the user's config directory
+func configDiscovery() []string {
+	paths := []string{"detective.yaml", "detective.yml"}
+	if dir, err := os.UserConfigDir(); err == nil {
+		paths = append(paths, filepath.Join(dir, "detective", "config.yaml"), filepath.Join(dir, "detective", "config.yml"))
+	}
+	return paths
+}
+
+// configEntry is one setting from the file: a scalar, or a list (block or [inline])
+type configEntry struct {
+	key    string // dotted, e.g. detectors.spike.factor
+	value  string
+	list   []string
+	isList bool
+	line   int
+}
+
+type configApply func(entry configEntry) error
+
+// configSettings are the keys the file can set directly, by dotted name
+var configSettings = map[string]configApply{
+	"input.timestamp": scalarSetting(func(layout string) error {
+		reference := time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC)
+		parsed, err := time.Parse(layout, reference.Format(layout))
+		if strings.Contains(layout, ",") || err != nil || !parsed.Equal(reference) {
+			return fmt.Errorf("%q s/b a Go time layout down to the second, without commas, e.g. %s", layout, LOG_TIMESTAMP_LAYOUT)
+		}
+		TIMESTAMP_LAYOUT = layout
+		return nil
+	}),
+	"input.timezone": scalarSetting(func(name string) error {
+		location, err := time.LoadLocation(name)
+		if err != nil || len(name) == 0 {
+			return fmt.Errorf("%q s/b an IANA time zone, e.g. UTC or America/New_York", name)
+		}
+		LOG_LOCATION = location
+		return nil
+	}),
+
+	"analysis.verbose": boolSetting(&VERBOSE),
+	"analysis.bucket": scalarSetting(func(value string) error {
+		bucket, err := time.ParseDuration(value)
+		if err != nil || bucket < time.Minute || bucket > time.Hour || bucket%time.Minute != 0 || time.Hour%bucket != 0 {
+			return fmt.Errorf("%q s/b whole minutes dividing an hour, e.g. 1m, 5m, 15m or 1h", value)
+		}
+		VOLUME_BUCKET = bucket
+		return nil
+	}),
+	"analysis.max-spikes": intSetting(&MAX_SPIKES, 1),
+	"analysis.max-gaps":   intSetting(&MAX_GAPS, 1),
+	"analysis.login": listSetting(func(paths []string) error {
+		if len(paths) == 0 {
+			return errors.New("s/b at least one path")
+		}
+		for _, path := range paths {
+			if !strings.HasPrefix(path, "/") || strings.ContainsAny(path, " \t,") {
+				return fmt.Errorf("%q s/b a path, e.g. /login", path)
+			}
+		}
+		loginPaths = paths
+		return nil
+	}),
+	"analysis.failures": listSetting(func(values []string) error {
+		if len(values) == 0 {
+			failureStatuses = nil
+			return nil
+		}
+		statuses := make(map[int]bool)
+		for _, value := range values {
+			class, isClass := strings.CutSuffix(strings.ToLower(value), "xx")
+			code, err := strconv.Atoi(class)
+			if isClass && err == nil && code >= 1 && code <= 5 {
+				for status := code * 100; status < (code+1)*100; status++ {
+					statuses[status] = true
+				}
+			} else if !isClass && err == nil && code >= 100 && code <= 599 {
+				statuses[code] = true
+			} else {
+				return fmt.Errorf("%q s/b a status (e.g. 401) or class (e.g. 5xx)", value)
+			}
+		}
+		failureStatuses = statuses
+		return nil
+	}),
+
+	"detectors.brute-force.enabled":       enabledSetting("brute-force"),
+	"detectors.brute-force.failures":      intSetting(&BRUTE_FORCE_FAILURES, 1),
+	"detectors.brute-force.window":        durationSetting(&BRUTE_FORCE_WINDOW),
+	"detectors.scanning.enabled":          enabledSetting("scanning"),
+	"detectors.scanning.paths":            intSetting(&SCAN_DISTINCT_PATHS, 1),
+	"detectors.scanning.window":           durationSetting(&SCAN_WINDOW),
+	"detectors.spike.enabled":             enabledSetting("spike"),
+	"detectors.spike.history":             intSetting(&SPIKE_HISTORY, 1),
+	"detectors.spike.factor":              floatSetting(&SPIKE_FACTOR, 1),
+	"detectors.spike.min-requests":        intSetting(&SPIKE_MIN_REQUESTS, 1),
+	"detectors.busy-period.enabled":       enabledSetting("busy-period"),
+	"detectors.failing-path.enabled":      enabledSetting("failing-path"),
+	"detectors.failing-path.min-failures": intSetting(&FAILING_PATH_MIN_FAILURES, 1),
+
+	"allowlist.ips": listSetting(func(values []string) error {
+		prefixes := make([]netip.Prefix, 0, len(values))
+		for _, value := range values {
+			prefix, err := parsePrefix(value)
+			if err != nil {
+				return fmt.Errorf("%q s/b an IP or CIDR", value)
+			}
+			prefixes = append(prefixes, prefix)
+		}
+		allowListPrefixes = prefixes
+		return nil
+	}),
+}
+
+// configFlags are the keys standing in for command line flags, by the flag they set;
+// a flag given on the command line wins over the file
+var configFlags = map[string]string{
+	"allowlist.file":          "allowlist",
+	"output.format":           "output",
+	"output.template":         "template",
+	"output.previous":         "previous",
+	"output.csv":              "csv",
+	"output.blocklist":        "blocklist",
+	"output.blockthreshold":   "blockthreshold",
+	"output.siem":             "siem",
+	"output.siemto":           "siemto",
+	"output.siemevents":       "siemevents",
+	"output.siemca":           "siemca",
+	"output.stix":             "stix",
+	"output.webhook":          "webhook",
+	"output.webhookseverity":  "webhookseverity",
+	"output.email":            "email",
+	"output.emailfrom":        "emailfrom",
+	"output.smtp":             "smtp",
+	"output.smtptls":          "smtptls",
+	"output.smtpuser":         "smtpuser",
+	"gates.fail-on":           "fail-on",
+	"gates.max-failed-logins": "max-failed-logins",
+	"gates.max-findings":      "max-findings",
+	"gates.max-error-rate":    "max-error-rate",
+}
+
+func scalarSetting(apply func(value string) error) configApply {
+	return func(entry configEntry) error {
+		if entry.isList {
+			return errors.New("s/b a single value, not a list")
+		}
+		return apply(entry.value)
+	}
+}
+
+// listSetting takes a list, or a single value as a list of one
+func listSetting(apply func(values []string) error) configApply {
+	return func(entry configEntry) error {
+		if entry.isList {
+			return apply(entry.list)
+		} else if len(entry.value) == 0 {
+			return apply([]string{})
+		}
+		return apply([]string{entry.value})
+	}
+}
+
+func boolSetting(setting *bool) configApply {
+	return scalarSetting(func(value string) error {
+		switch strings.ToLower(value) {
+		case "true", "yes", "on":
+			*setting = true
+		case "false", "no", "off":
+			*setting = false
+		default:
+			return fmt.Errorf("%q s/b true or false", value)
+		}
+		return nil
+	})
+}
+
+func enabledSetting(detector string) configApply {
+	return func(entry configEntry) error {
+		enabled := detectorsEnabled[detector]
+		if err := boolSetting(&enabled)(entry); err != nil {
+			return err
+		}
+		detectorsEnabled[detector] = enabled
+		return nil
+	}
+}
+
+func intSetting(setting *int, least int) configApply {
+	return scalarSetting(func(value string) error {
+		number, err := strconv.Atoi(value)
+		if err != nil || number < least {
+			return fmt.Errorf("%q s/b a whole number, at least %d", value, least)
+		}
+		*setting = number
+		return nil
+	})
+}
+
+func floatSetting(setting *float64, least float64) configApply {
+	return scalarSetting(func(value string) error {
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil || number < least {
+			return fmt.Errorf("%q s/b a number, at least %g", value, least)
+		}
+		*setting = number
+		return nil
+	})
+}
+
+func durationSetting(setting *time.Duration) configApply {
+	return scalarSetting(func(value string) error {
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration <= 0 {
+			return fmt.Errorf("%q s/b a duration, e.g. 90s or 5m", value)
+		}
+		*setting = duration
+		return nil
+	})
+}
+
+// loadConfig applies the config file, or the discovered one when fileSpec is empty (none
+// is fine then); flag-backed keys go through flags, unless given there - and are problems
+// when flags is nil, as nothing would read them. Reports every problem, not just the first, and returns false if any
+func loadConfig(fileSpec string, flags *flag.FlagSet) bool {
+	if len(fileSpec) == 0 {
+		for _, candidate := range configDiscovery() {
+			if _, err := os.Stat(candidate); err == nil {
+				fileSpec = candidate
+				break
+			}
+		}
+		if len(fileSpec) == 0 {
+			return true
+		}
+	}
+
+	if strings.EqualFold(filepath.Ext(fileSpec), ".toml") {
+		log.Println("ERR:", fileSpec, "- TOML isn't supported, the config file s/b YAML")
+		return false
+	}
+	text, err := os.ReadFile(fileSpec)
+	if err != nil {
+		log.Println("ERR:", err)
+		return false
+	}
+
+	entries, problems := parseConfig(string(text))
+
+	given := make(map[string]bool)
+	if flags != nil {
+		flags.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	}
+
+	for _, entry := range entries {
+		var err error
+		if apply, ok := configSettings[entry.key]; ok {
+			err = apply(entry)
+		} else if name, ok := configFlags[entry.key]; ok {
+			if flags == nil {
+				err = fmt.Errorf("stands in for -%s, which serve and the console don't take", name)
+			} else if !given[name] {
+				err = setConfigFlag(flags, name, entry)
+			}
+		} else {
+			err = unknownConfigKey(entry.key)
+		}
+		if err != nil {
+			problems = append(problems, configProblem{entry.line, entry.key + ": " + err.Error()})
+		}
+	}
+
+	slices.SortStableFunc(problems, func(a configProblem, b configProblem) int { return a.line - b.line })
+	for _, problem := range problems {
+		log.Printf("ERR: %s:%d: %s", fileSpec, problem.line, problem.message)
+	}
+	if len(problems) > 0 {
+		return false
+	}
+
+	configSpec = fileSpec
+	return true
+}
+
+// setConfigFlag sets the flag as if it were given; -webhook is repeatable, -email takes a comma list
+func setConfigFlag(flags *flag.FlagSet, name string, entry configEntry) error {
+	if !entry.isList {
+		return flags.Set(name, entry.value)
+	}
+	switch name {
+	case "webhook":
+		for _, value := range entry.list {
+			if err := flags.Set(name, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "email":
+		return flags.Set(name, strings.Join(entry.list, ","))
+	}
+	return errors.New("s/b a single value, not a list")
+}
+
+// unknownConfigKey explains a key that isn't a setting: a section given a value, or
+// (likely) a typo for the nearest known key
+func unknownConfigKey(key string) error {
+	known := make([]string, 0, len(configSettings)+len(configFlags))
+	for name := range configSettings {
+		known = append(known, name)
+	}
+	for name := range configFlags {
+		known = append(known, name)
+	}
+	slices.Sort(known)
+
+	for _, name := range known {
+		if strings.HasPrefix(name, key+".") {
+			return fmt.Errorf("is a section, s/b keys under it, e.g. %s", name)
+		}
+	}
+
+	nearest, distance := "", len(key)
+	for _, name := range known {
+		if d := editDistance(key, name); d < distance {
+			nearest, distance = name, d
+		}
+	}
+	if len(nearest) > 0 && distance <= 3 {
+		return fmt.Errorf("unknown key, did you mean %s?", nearest)
+	}
+	return errors.New("unknown key, see JeffR_Config.md for the settings")
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a string, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// configProblem is something wrong with the file, at the line
+type configProblem struct {
+	line    int
+	message string
+}
+
+// configFrame is a key whose value is on the lines below: a section of keys, or a block list
+type configFrame struct {
+	indent      int
+	key         string
+	line        int
+	childIndent int // -1 until the first line under it
+	section     bool
+	entry       int // index of its list entry, -1 until the first item
+}
+
+// parseConfig reads the YAML subset config files use: nested `key: value` sections,
+// lists as `- item` lines or `[a, b]`, quoted or plain scalars, and # comments;
+// returns the settings as dotted keys, and the problems
+func parseConfig(text string) ([]configEntry, []configProblem) {
+	entries := make([]configEntry, 0)
+	problems := make([]configProblem, 0)
+	seen := make(map[string]int)
+	problem := func(line int, format string, args ...any) {
+		problems = append(problems, configProblem{line, fmt.Sprintf(format, args...)})
+	}
+
+	root := &configFrame{indent: -1, childIndent: -1, section: true, entry: -1}
+	stack := []*configFrame{root}
+
+	// a key with nothing under it is an empty value
+	closeFrame := func(frame *configFrame) {
+		if !frame.section && frame.entry < 0 {
+			entries = append(entries, configEntry{key: frame.key, line: frame.line})
+		}
+	}
+
+	for i, raw := range strings.Split(strings.TrimPrefix(text, "\uFEFF"), "\n") {
+		lineNum := i + 1
+		line := strings.TrimRight(stripConfigComment(raw), " \t\r")
+		body := strings.TrimLeft(line, " ")
+		if len(body) == 0 {
+			continue
+		}
+		if strings.HasPrefix(body, "\t") {
+			problem(lineNum, "indent with spaces, not tabs")
+			continue
+		}
+		indent := len(line) - len(body)
+		isItem := body == "-" || strings.HasPrefix(body, "- ")
+
+		for len(stack) > 1 {
+			top := stack[len(stack)-1]
+			if indent > top.indent || (indent == top.indent && isItem && !top.section) {
+				break
+			}
+			closeFrame(top)
+			stack = stack[:len(stack)-1]
+		}
+		top := stack[len(stack)-1]
+
+		if top.childIndent < 0 {
+			top.childIndent = indent
+		} else if indent != top.childIndent {
+			problem(lineNum, "indentation doesn't line up with the lines above")
+			continue
+		}
+
+		if isItem {
+			if top.section {
+				problem(lineNum, "list item outside a list, s/b under a `key:`")
+				continue
+			}
+			item := strings.TrimSpace(strings.TrimPrefix(body, "-"))
+			if strings.Contains(item, ": ") && !strings.HasPrefix(item, `"`) && !strings.HasPrefix(item, "'") {
+				problem(lineNum, "lists of mappings aren't supported")
+				continue
+			}
+			value, err := unquoteConfig(item)
+			if err != nil {
+				problem(lineNum, "%s: %s", top.key, err)
+				continue
+			}
+			if top.entry < 0 {
+				top.entry = len(entries)
+				entries = append(entries, configEntry{key: top.key, list: []string{}, isList: true, line: top.line})
+			}
+			entries[top.entry].list = append(entries[top.entry].list, value)
+			continue
+		}
+
+		if top.entry >= 0 {
+			problem(lineNum, "%s mixes list items and keys", top.key)
+			continue
+		}
+		top.section = true
+
+		name, rest, ok := cutConfigKey(body)
+		if !ok {
+			problem(lineNum, "%q s/b `key: value`", body)
+			continue
+		}
+		key := name
+		if top != root {
+			key = top.key + "." + name
+		}
+		first, twice := seen[key]
+		if twice {
+			problem(lineNum, "%s: set twice, first at line %d", key, first)
+		} else {
+			seen[key] = lineNum
+		}
+
+		switch {
+		case twice && len(rest) > 0:
+			// nothing more to say about it; a section's keys still get checked
+		case len(rest) == 0:
+			stack = append(stack, &configFrame{indent: indent, key: key, line: lineNum, childIndent: -1, entry: -1})
+		case strings.HasPrefix(rest, "["):
+			list, err := splitConfigList(rest)
+			if err != nil {
+				problem(lineNum, "%s: %s", key, err)
+				continue
+			}
+			entries = append(entries, configEntry{key: key, list: list, isList: true, line: lineNum})
+		case strings.HasPrefix(rest, "{"):
+			problem(lineNum, "%s: inline mappings aren't supported, nest the keys below it instead", key)
+		default:
+			value, err := unquoteConfig(rest)
+			if err != nil {
+				problem(lineNum, "%s: %s", key, err)
+				continue
+			}
+			entries = append(entries, configEntry{key: key, value: value, line: lineNum})
+		}
+	}
+
+	for len(stack) > 1 {
+		closeFrame(stack[len(stack)-1])
+		stack = stack[:len(stack)-1]
+	}
+
+	return entries, problems
+}
+
+// stripConfigComment drops a # comment: one starting the line or after a space, outside quotes
+func stripConfigComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch {
+		case quote != 0:
+			if line[i] == '\\' && quote == '"' {
+				i++
+			} else if line[i] == quote {
+				quote = 0
+			}
+		case line[i] == '"' || line[i] == '\'':
+			quote = line[i]
+		case line[i] == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// cutConfigKey splits `key: value` (or `key:`) at the colon ending the key
+func cutConfigKey(body string) (string, string, bool) {
+	for i := 0; i < len(body); i++ {
+		if body[i] == ':' && (i+1 == len(body) || body[i+1] == ' ') {
+			key := strings.TrimSpace(body[:i])
+			if len(key) == 0 || strings.ContainsAny(key, " \"'[]{}") {
+				return "", "", false
+			}
+			return key, strings.TrimSpace(body[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// splitConfigList reads an inline [a, b, "c, d"] list
+func splitConfigList(text string) ([]string, error) {
+	if !strings.HasSuffix(text, "]") {
+		return nil, errors.New("inline list s/b closed with ]")
+	}
+	inner := strings.TrimSpace(text[1 : len(text)-1])
+	list := make([]string, 0)
+	if len(inner) == 0 {
+		return list, nil
+	}
+
+	var quote byte
+	start := 0
+	for i := 0; i <= len(inner); i++ {
+		if i < len(inner) && quote != 0 {
+			if inner[i] == '\\' && quote == '"' {
+				i++
+			} else if inner[i] == quote {
+				quote = 0
+			}
+		} else if i < len(inner) && (inner[i] == '"' || inner[i] == '\'') {
+			quote = inner[i]
+		} else if i == len(inner) || inner[i] == ',' {
+			value, err := unquoteConfig(strings.TrimSpace(inner[start:i]))
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+			start = i + 1
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quoted string")
+	}
+	return list, nil
+}
+
+// unquoteConfig reads a plain, 'single' (a doubled ' escapes one) or "double" (Go escapes) quoted scalar
+func unquoteConfig(text string) (string, error) {
+	switch {
+	case strings.HasPrefix(text, `"`):
+		value, err := strconv.Unquote(text)
+		if err != nil {
+			return "", fmt.Errorf("%s s/b a \"quoted string\"", text)
+		}
+		return value, nil
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") || strings.Contains(strings.ReplaceAll(text[1:len(text)-1], "''", ""), "'") {
+			return "", fmt.Errorf("%s s/b a 'quoted string'", text)
+		}
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	}
+	return text, nil
+}
+
+// settingsFingerprint is what a checkpoint's tallies depend on, so one made under other
+// settings isn't resumed
+func settingsFingerprint() string {
+	failures := make([]int, 0, len(failureStatuses))
+	for status := range failureStatuses {
+		failures = append(failures, status)
+	}
+	slices.Sort(failures)
+	return fmt.Sprintf("layout=%s zone=%s bucket=%s login=%v failures=%v",
+		TIMESTAMP_LAYOUT, LOG_LOCATION, VOLUME_BUCKET, loginPaths, failures)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// withConfig keeps what the test's config files set from outliving it, and collects
+// what loadConfig logs
+func withConfig(t *testing.T) *bytes.Buffer {
+	wasSpec, wasSpikes, wasGaps := configSpec, MAX_SPIKES, MAX_GAPS
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() {
+		configSpec, MAX_SPIKES, MAX_GAPS = wasSpec, wasSpikes, wasGaps
+		log.SetOutput(os.Stderr)
+	})
+	return &logged
+}
+
+// String is an entry as the tests expect it
+func (e configEntry) String() string {
+	if e.isList {
+		return fmt.Sprintf("%d %s %q", e.line, e.key, e.list)
+	}
+	return fmt.Sprintf("%d %s %q", e.line, e.key, e.value)
+}
+
+func TestParseConfigScalarsAndLists(t *testing.T) {
+	cases := []struct {
+		name    string
+		text    string
+		entries []string
+	}{
+		{"plain", "a: b", []string{`1 a "b"`}},
+		{"spaces kept inside", "a:   two words  ", []string{`1 a "two words"`}},
+		{"comment", "a: b # why", []string{`1 a "b"`}},
+		{"hash inside a word", "a: b#c", []string{`1 a "b#c"`}},
+		{"double quoted", `a: "x\ty # no comment"`, []string{`1 a "x\ty # no comment"`}},
+		{"single quoted", `a: 'it''s # no comment'`, []string{`1 a "it's # no comment"`}},
+		{"empty", "a:", []string{`1 a ""`}},
+		{"empty quoted", `a: ""`, []string{`1 a ""`}},
+		{"nested", "a:\n  b:\n    c: 1\n  d: 2\ne: 3", []string{`3 a.b.c "1"`, `4 a.d "2"`, `5 e "3"`}},
+		{"blank lines and comments", "# top\n\na:\n\n  # inside\n  b: 1\n", []string{`6 a.b "1"`}},
+		{"byte order mark", "\uFEFFa: b", []string{`1 a "b"`}},
+		{"CRLF", "a:\r\n  b: c\r\n", []string{`2 a.b "c"`}},
+		{"inline list", `a: [x, "y, z", 'w''s', v]`, []string{`1 a ["x" "y, z" "w's" "v"]`}},
+		{"empty inline list", "a: []", []string{`1 a []`}},
+		{"block list", "a:\n  - x\n  - \"y, z\"\n  - 'w'\nb: 1", []string{`1 a ["x" "y, z" "w"]`, `5 b "1"`}},
+		{"block list at the key's indent", "a:\n- x\n- y", []string{`1 a ["x" "y"]`}},
+		{"quoted item with a colon", "a:\n  - \"b: c\"", []string{`1 a ["b: c"]`}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			entries, problems := parseConfig(c.text)
+			if len(problems) > 0 {
+				t.Fatalf("problems %v", problems)
+			}
+			if got := fmt.Sprint(entries); got != fmt.Sprint(c.entries) {
+				t.Errorf("entries\n  %s\nexpected\n  %s", got, fmt.Sprint(c.entries))
+			}
+		})
+	}
+}
+
+func TestParseConfigProblems(t *testing.T) {
+	cases := []struct {
+		name    string
+		text    string
+		line    int
+		message string
+	}{
+		{"inline mapping", "a:\n  b: {c: 1}", 2, "a.b: inline mappings aren't supported"},
+		{"empty inline mapping", "a: {}", 1, "a: inline mappings aren't supported"},
+		{"list of mappings", "a:\n  - b: 1", 2, "lists of mappings aren't supported"},
+		{"tab indent", "a:\n\tb: 1", 2, "indent with spaces, not tabs"},
+		{"misaligned", "a:\n    b: 1\n  c: 2", 3, "doesn't line up"},
+		{"not a key", "a:\n  just words", 2, "s/b `key: value`"},
+		{"set twice", "a: 1\nb: 2\na: 3", 3, "a: set twice, first at line 1"},
+		{"item outside a list", "- a", 1, "list item outside a list"},
+		{"items and keys", "a:\n  - x\n  b: 1", 3, "a mixes list items and keys"},
+		{"unterminated double", `a: "b`, 1, "s/b a \"quoted string\""},
+		{"unterminated single", "a: 'b", 1, "s/b a 'quoted string'"},
+		{"unescaped single", "a: 'it's'", 1, "s/b a 'quoted string'"},
+		{"unclosed inline list", "a: [b, c", 1, "a: inline list s/b closed with ]"},
+		{"unterminated in a list", `a: [b, 'c, d]`, 1, "a: 'c, d s/b a 'quoted string'"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, problems := parseConfig(c.text)
+			if len(problems) != 1 || problems[0].line != c.line || !strings.Contains(problems[0].message, c.message) {
+				t.Errorf("problems %v, expected one at line %d: %s", problems, c.line, c.message)
+			}
+		})
+	}
+}
+
+// every problem is reported, at its line, and none of them stop the others being found
+func TestLoadConfigReportsUnknownKeys(t *testing.T) {
+	logged := withConfig(t)
+	fileSpec := filepath.Join(t.TempDir(), "detective.yaml")
+	text := strings.Join([]string{
+		"analysis:",
+		"  max-spikes: 3",
+		"detectors:",
+		"  spike:",
+		"    facter: 2",
+		"input: UTC",
+		"colour: blue",
+		"analysis:",
+		"  max-gaps: 0",
+	}, "\n")
+	if err := os.WriteFile(fileSpec, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if loadConfig(fileSpec, nil) {
+		t.Fatal("loaded a config with unknown keys")
+	}
+	want := []string{
+		fileSpec + ":5: detectors.spike.facter: unknown key, did you mean detectors.spike.factor?",
+		fileSpec + ":6: input: is a section, s/b keys under it, e.g. input.timestamp",
+		fileSpec + ":7: colour: unknown key, see JeffR_Config.md for the settings",
+		fileSpec + ":8: analysis: set twice, first at line 1",
+		fileSpec + ":9: analysis.max-gaps: \"0\" s/b a whole number, at least 1",
+	}
+	lines := strings.Split(strings.TrimSpace(logged.String()), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("logged\n%s\nexpected %d problems", logged.String(), len(want))
+	}
+	for i, line := range lines {
+		if !strings.HasSuffix(line, "ERR: "+want[i]) {
+			t.Errorf("logged %q, expected %q", line, want[i])
+		}
+	}
+	if configSpec == fileSpec {
+		t.Error("the config is taken as read")
+	}
+}
+
+func TestLoadConfigRefusesToml(t *testing.T) {
+	logged := withConfig(t)
+	fileSpec := filepath.Join(t.TempDir(), "detective.toml")
+	if err := os.WriteFile(fileSpec, []byte("[analysis]\nmax-spikes = 3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if loadConfig(fileSpec, nil) || !strings.Contains(logged.String(), "TOML isn't supported") {
+		t.Errorf("loaded TOML, logging %q", logged.String())
+	}
+}
+
+// without -config, the current directory's file wins over the user's, .yaml over .yml
+func TestConfigDiscoveryOrder(t *testing.T) {
+	withConfig(t)
+	dir, userDir := t.TempDir(), t.TempDir()
+	t.Chdir(dir)
+	t.Setenv("XDG_CONFIG_HOME", userDir)
+	if err := os.Mkdir(filepath.Join(userDir, "detective"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	candidates := []string{"detective.yaml", "detective.yml",
+		filepath.Join(userDir, "detective", "config.yaml"), filepath.Join(userDir, "detective", "config.yml")}
+	if discovered := configDiscovery(); fmt.Sprint(discovered) != fmt.Sprint(candidates) {
+		t.Errorf("discovery %v, expected %v", discovered, candidates)
+	}
+	for i, fileSpec := range candidates {
+		if err := os.WriteFile(fileSpec, []byte(fmt.Sprintf("analysis:\n  max-spikes: %d\n", i+1)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// take each away in turn, and the next is read
+	for i, fileSpec := range candidates {
+		MAX_SPIKES, configSpec = 99, ""
+		if !loadConfig("", nil) {
+			t.Fatalf("%s wasn't loaded", fileSpec)
+		}
+		if configSpec != fileSpec || MAX_SPIKES != i+1 {
+			t.Errorf("read %s (max-spikes %d), expected %s", configSpec, MAX_SPIKES, fileSpec)
+		}
+		os.Remove(fileSpec)
+	}
+
+	MAX_SPIKES, configSpec = 99, ""
+	if !loadConfig("", nil) || configSpec != "" || MAX_SPIKES != 99 {
+		t.Errorf("without a config file, read %q (max-spikes %d)", configSpec, MAX_SPIKES)
+	}
+}
+
+// flag-backed keys fill in the flags not given; where there are no flags (serve, the
+// console) they're refused rather than dropped
+func TestLoadConfigFlagKeys(t *testing.T) {
+	logged := withConfig(t)
+	fileSpec := filepath.Join(t.TempDir(), "detective.yaml")
+	text := "analysis:\n  max-spikes: 3\noutput:\n  csv: out\n  stix: bundle.json\n  webhook: [a, b]\n"
+	if err := os.WriteFile(fileSpec, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	flags := flag.NewFlagSet("detective", flag.ContinueOnError)
+	csv, stix := flags.String("csv", "", ""), flags.String("stix", "", "")
+	webhooks := make([]string, 0)
+	flags.Func("webhook", "", func(value string) error {
+		webhooks = append(webhooks, value)
+		return nil
+	})
+	if err := flags.Parse([]string{"-stix", "given.json"}); err != nil {
+		t.Fatal(err)
+	}
+	if !loadConfig(fileSpec, flags) {
+		t.Fatalf("not loaded:\n%s", logged.String())
+	}
+	if *csv != "out" || *stix != "given.json" || fmt.Sprint(webhooks) != "[a b]" {
+		t.Errorf("-csv %q, -stix %q, -webhook %v, expected out, given.json and [a b]", *csv, *stix, webhooks)
+	}
+
+	logged.Reset()
+	if loadConfig(fileSpec, nil) {
+		t.Fatal("loaded flag-backed keys without flags")
+	}
+	want := []string{
+		fileSpec + ":4: output.csv: stands in for -csv, which serve and the console don't take",
+		fileSpec + ":5: output.stix: stands in for -stix, which serve and the console don't take",
+		fileSpec + ":6: output.webhook: stands in for -webhook, which serve and the console don't take",
+	}
+	lines := strings.Split(strings.TrimSpace(logged.String()), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("logged\n%s\nexpected %d problems", logged.String(), len(want))
+	}
+	for i, line := range lines {
+		if !strings.HasSuffix(line, "ERR: "+want[i]) {
+			t.Errorf("logged %q, expected %q", line, want[i])
+		}
+	}
+}
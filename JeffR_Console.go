@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the query console: load a log once, then query the in-memory analysis as often as wanted
+
+const CONSOLE_EVENTS_LIMIT = 50
+
+// the log the console has loaded, for report titles
+var consoleSource = ""
+
+type consoleCommand struct {
+	name  string
+	usage string
+	help  string
+	run   func(args []string) error
+}
+
+var consoleCommands []consoleCommand
+
+func init() {
+	// assigned here as help refers back to the list
+	consoleCommands = []consoleCommand{
+		{"load", "load <file>", "read and analyze a log, replacing whatever was loaded", consoleLoad},
+		{"top", "top ips [<n>] [by requests|failures|weight]", "the n (default 10) busiest IPs", consoleTop},
+		{"events", "events [<field><op><value> ...] [limit=<n>]",
+			"the events matching every condition; fields ip, method, path, status, time; ops = != ~ (contains) < <= > >=", consoleEvents},
+		{"spikes", "spikes [bucket=<duration>] [top=<n>]",
+			"the analysis' weekly spikes, or with bucket= the busiest buckets of that size, e.g. bucket=15m", consoleSpikes},
+		{"gaps", "gaps [cyclical|absolute]", "the analysis' activity gaps", consoleGaps},
+		{"summary", "summary", "the executive summary: overall risk, what matters most, what to do", consoleSummary},
+		{"findings", "findings", "the threat report: every finding, most severe first", consoleFindings},
+		{"report", "report", "the full text report", consoleReport},
+		{"export", "export json|html|markdown|stix <file> | export csv|blocklist <dir> | export cef|leef|ecs <file|collector>", "write the report in another format", consoleExport},
+		{"dashboard", "dashboard", "browse the analysis in the terminal dashboard", consoleDashboard},
+		{"help", "help", "this list", consoleHelp},
+		{"quit", "quit", "leave the console (also exit, or end of input)", nil},
+	}
+}
+
+// runConsole reads commands until quit or the end of input; with a log to start from if given
+func runConsole(fileSpec string) bool {
+	tty := isTerminal(os.Stdin)
+	if tty {
+		fmt.Println("Network Detective console - help lists the commands")
+		advertiseHelpFlag()
+	}
+
+	if len(fileSpec) > 0 {
+		if err := consoleLoad([]string{fileSpec}); err != nil {
+			fmt.Println("ERR:", err)
+		}
+	}
+
+	input := bufio.NewScanner(os.Stdin)
+	for {
+		if tty {
+			fmt.Print("detective> ")
+		}
+		if !input.Scan() {
+			break
+		}
+
+		fields := strings.Fields(input.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		name := strings.ToLower(fields[0])
+		if name == "quit" || name == "exit" {
+			break
+		}
+
+		i := slices.IndexFunc(consoleCommands, func(command consoleCommand) bool { return command.name == name })
+		if i < 0 {
+			fmt.Println("ERR: unknown command", name, "- help lists the commands")
+			continue
+		}
+		if err := consoleCommands[i].run(fields[1:]); err != nil {
+			fmt.Println("ERR:", err)
+		}
+	}
+
+	if tty {
+		fmt.Println()
+	}
+	return true
+}
+
+func consoleHelp(args []string) error {
+	width := 0
+	for _, command := range consoleCommands {
+		width = max(width, len(command.usage))
+	}
+	for _, command := range consoleCommands {
+		fmt.Printf("  %s  %s\n", padRight(command.usage, width), command.help)
+	}
+	return nil
+}
+
+func consoleLoad(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: load <file>")
+	}
+
+	resetTrafficData()
+	consoleSource = ""
+	if loadLogFile(args[0]) != EXIT_OK {
+		return fmt.Errorf("nothing loaded from %s", args[0])
+	}
+	consoleSource = filepath.Base(args[0])
+	return nil
+}
+
+// consoleLoaded is the guard for commands that need a log
+func consoleLoaded() error {
+	if len(consoleSource) == 0 {
+		return fmt.Errorf("no log loaded - load <file> first")
+	}
+	return nil
+}
+
+func consoleTop(args []string) error {
+	if err := consoleLoaded(); err != nil {
+		return err
+	}
+	if len(args) == 0 || args[0] != "ips" {
+		return fmt.Errorf("usage: top ips [<n>] [by requests|failures|weight]")
+	}
+	args = args[1:]
+
+	count := 10
+	if len(args) > 0 && args[0] != "by" {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return fmt.Errorf("%q isn't a count", args[0])
+		}
+		count = n
+		args = args[1:]
+	}
+
+	sortBy := 0
+	if len(args) == 2 && args[0] == "by" {
+		sortBy = slices.Index(dashboardSorts, args[1])
+	}
+	if sortBy < 0 || (len(args) != 0 && len(args) != 2) {
+		return fmt.Errorf("usage: top ips [<n>] [by %s]", strings.Join(dashboardSorts, "|"))
+	}
+
+	// the dashboard's IP list, unfiltered
+	d := &dashboard{filter: dashboardFilter{status: "all"}, sortBy: sortBy}
+	d.refresh()
+
+	out := detectTerminal(os.Stdout)
+	addresses := make([]string, 0, len(d.ips))
+	for _, row := range d.ips {
+		addresses = append(addresses, row.address)
+	}
+	ipWidth := columnWidth(15, addresses...)
+
+	fmt.Printf("%s  %10s  %10s  %8s\n", padRight("IP", ipWidth), "#Requests", "#Failures", "Weight")
+	for _, row := range d.ips[:min(count, len(d.ips))] {
+		fmt.Printf("%s  %10d  %s  %8d\n", padRight(row.address, ipWidth), row.requests,
+			out.failures(int64(row.failures), fmt.Sprintf("%10d", row.failures)), row.weight)
+	}
+	return nil
+}
+
+var eventConditionPattern = regexp.MustCompile(`^([a-z]+)(>=|<=|!=|=|>|<|~)(.+)$`)
+
+// eventCondition is one events filter, e.g. status>=400
+type eventCondition struct {
+	field  string
+	op     string
+	value  string
+	number int
+	at     time.Time
+}
+
+func parseEventCondition(text string) (eventCondition, error) {
+	parts := eventConditionPattern.FindStringSubmatch(text)
+	if parts == nil {
+		return eventCondition{}, fmt.Errorf("%q isn't <field><op><value>", text)
+	}
+	condition := eventCondition{field: parts[1], op: parts[2], value: parts[3]}
+
+	switch condition.field {
+	case "ip", "method", "path":
+		if !slices.Contains([]string{"=", "!=", "~"}, condition.op) {
+			return condition, fmt.Errorf("%s only takes = != or ~", condition.field)
+		}
+	case "status":
+		number, err := strconv.Atoi(condition.value)
+		if err != nil || condition.op == "~" {
+			return condition, fmt.Errorf("%q isn't a status comparison", text)
+		}
+		condition.number = number
+	case "time":
+		at, _, err := parseFilterTime(condition.value)
+		if err != nil || condition.op == "~" {
+			return condition, fmt.Errorf("%q isn't a time comparison", text)
+		}
+		condition.at = at
+	default:
+		return condition, fmt.Errorf("unknown field %s - s/b ip, method, path, status or time", condition.field)
+	}
+	return condition, nil
+}
+
+func (c eventCondition) matches(item networkDataItem) bool {
+	var order int
+	switch c.field {
+	case "ip":
+		return c.matchesText(item.ipAddr)
+	case "method":
+		return c.matchesText(strings.ToUpper(item.method))
+	case "path":
+		return c.matchesText(item.path)
+	case "status":
+		order = item.statusCode - c.number
+	case "time":
+		order = item.timestamp.Compare(c.at)
+	}
+
+	switch c.op {
+	case "=":
+		return order == 0
+	case "!=":
+		return order != 0
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	}
+	return order >= 0
+}
+
+func (c eventCondition) matchesText(text string) bool {
+	value := c.value
+	if c.field == "method" {
+		value = strings.ToUpper(value)
+	}
+	switch c.op {
+	case "~":
+		return strings.Contains(text, value)
+	case "!=":
+		return text != value
+	}
+	return text == value
+}
+
+func consoleEvents(args []string) error {
+	if err := consoleLoaded(); err != nil {
+		return err
+	}
+
+	limit := CONSOLE_EVENTS_LIMIT
+	conditions := make([]eventCondition, 0)
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "limit=") {
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "limit="))
+			if err != nil || n < 1 {
+				return fmt.Errorf("%q isn't a limit", arg)
+			}
+			limit = n
+			continue
+		}
+		condition, err := parseEventCondition(arg)
+		if err != nil {
+			return err
+		}
+		conditions = append(conditions, condition)
+	}
+
+	out := detectTerminal(os.Stdout)
+	matched := 0
+	for _, item := range networkData {
+		if !slices.ContainsFunc(conditions, func(c eventCondition) bool { return !c.matches(item) }) {
+			matched++
+			if matched <= limit {
+				text := fmt.Sprintf("%s  %-15s  %-7s  %-30s  %d", item.timestamp.Format("2006-01-02 15:04:05"),
+					item.ipAddr, item.method, item.path, item.statusCode)
+				if isHttpError(item.statusCode) {
+					text = out.paint(text, ansiRed)
+				}
+				fmt.Println(text)
+			}
+		}
+	}
+
+	if matched > limit {
+		fmt.Printf("... %d more (limit=<n> to see them)\n", matched-limit)
+	}
+	fmt.Println(matched, "of", len(networkData), "events")
+	return nil
+}
+
+func consoleSpikes(args []string) error {
+	if err := consoleLoaded(); err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		reportSpikes()
+		return nil
+	}
+
+	bucket := SPIKE_BUCKET
+	top := 10
+	for _, arg := range args {
+		var err error
+		if value, ok := strings.CutPrefix(arg, "bucket="); ok {
+			bucket, err = time.ParseDuration(value)
+			if err == nil && bucket < time.Second {
+				err = fmt.Errorf("too small")
+			}
+		} else if value, ok := strings.CutPrefix(arg, "top="); ok {
+			top, err = strconv.Atoi(value)
+			if err == nil && top < 1 {
+				err = fmt.Errorf("too few")
+			}
+		} else {
+			err = fmt.Errorf("usage: spikes [bucket=<duration>] [top=<n>]")
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", arg, err)
+		}
+	}
+
+	spikes, average := busiestBuckets(networkData, bucket, top)
+	fmt.Printf("Busiest %s buckets (%.1f requests per bucket on average)\n", bucket, average)
+	for _, spike := range spikes {
+		fmt.Printf("%s  %-9s  %6d requests  x%.1f\n", spike.start.Format("2006-01-02 15:04:05"),
+			spike.start.Weekday(), spike.requests, float64(spike.requests)/average)
+	}
+	return nil
+}
+
+func consoleGaps(args []string) error {
+	if err := consoleLoaded(); err != nil {
+		return err
+	}
+
+	which := ""
+	if len(args) == 1 {
+		which = args[0]
+	}
+	switch {
+	case len(args) == 0:
+		reportCyclicalGaps()
+		reportAbsoluteGaps()
+	case which == "cyclical":
+		reportCyclicalGaps()
+	case which == "absolute":
+		reportAbsoluteGaps()
+	default:
+		return fmt.Errorf("usage: gaps [cyclical|absolute]")
+	}
+	return nil
+}
+
+func consoleSummary(args []string) error {
+	if err := consoleLoaded(); err != nil {
+		return err
+	}
+	reportExecutiveSummary(detectTerminal(os.Stdout))
+	return nil
+}
+
+func consoleFindings(args []string) error {
+	if err := consoleLoaded(); err != nil {
+		return err
+	}
+	reportFindings(detectTerminal(os.Stdout))
+	return nil
+}
+
+func consoleReport(args []string) error {
+	if err := consoleLoaded(); err != nil {
+		return err
+	}
+	report()
+	return nil
+}
+
+func consoleExport(args []string) error {
+	if err := consoleLoaded(); err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("usage: export json|html|markdown|stix <file> | export csv|blocklist <dir> | export cef|leef|ecs <file|collector>")
+	}
+
+	data := buildReportData(consoleSource)
+	format, fileSpec := strings.ToLower(args[0]), args[1]
+
+	var write func(w io.Writer, data reportData) error
+	switch format {
+	case "csv":
+		if err := writeCsvExport(fileSpec, data); err != nil {
+			return err
+		}
+		fmt.Println("See CSV tables exported to", fileSpec)
+		return nil
+	case "blocklist":
+		if err := writeBlockLists(fileSpec, data); err != nil {
+			return err
+		}
+		fmt.Println("See block lists exported to", fileSpec)
+		return nil
+	case "cef", "leef", "ecs":
+		return writeSiemExport(format, fileSpec, data)
+	case "stix":
+		return writeStixBundle(fileSpec, data)
+	case "json":
+		write = writeJsonReport
+	case "html":
+		write = writeHtmlReport
+	case "markdown":
+		write = writeMarkdownReport
+	default:
+		return fmt.Errorf("can't export %s - s/b json, html, markdown, stix, csv, blocklist, cef, leef or ecs", format)
+	}
+
+	file, err := os.Create(fileSpec)
+	if err != nil {
+		return err
+	}
+	err = write(file, data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("See", format, "report written to", fileSpec)
+	return nil
+}
+
+func consoleDashboard(args []string) error {
+	if err := consoleLoaded(); err != nil {
+		return err
+	}
+	runDashboard()
+	return nil
+}
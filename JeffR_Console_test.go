@@ -0,0 +1,116 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseEventCondition(t *testing.T) {
+	cases := []struct {
+		text    string
+		want    eventCondition // field, op and value, and number or at as the field needs
+		problem string
+	}{
+		{"ip=10.0.0.1", eventCondition{field: "ip", op: "=", value: "10.0.0.1"}, ""},
+		{"ip!=2001:db8::7", eventCondition{field: "ip", op: "!=", value: "2001:db8::7"}, ""},
+		{"method=post", eventCondition{field: "method", op: "=", value: "post"}, ""},
+		{"path~admin", eventCondition{field: "path", op: "~", value: "admin"}, ""},
+		{"path=/a=b", eventCondition{field: "path", op: "=", value: "/a=b"}, ""},
+		{"path~>=", eventCondition{field: "path", op: "~", value: ">="}, ""},
+		{"status>=400", eventCondition{field: "status", op: ">=", value: "400", number: 400}, ""},
+		{"status<=299", eventCondition{field: "status", op: "<=", value: "299", number: 299}, ""},
+		{"status!=200", eventCondition{field: "status", op: "!=", value: "200", number: 200}, ""},
+		{"status>499", eventCondition{field: "status", op: ">", value: "499", number: 499}, ""},
+		{"time>=2024-03-01T10:00", eventCondition{field: "time", op: ">=", value: "2024-03-01T10:00",
+			at: time.Date(2024, time.March, 1, 10, 0, 0, 0, LOG_LOCATION)}, ""},
+		{"time<2024-03-02", eventCondition{field: "time", op: "<", value: "2024-03-02",
+			at: time.Date(2024, time.March, 2, 0, 0, 0, 0, LOG_LOCATION)}, ""},
+
+		{"status", eventCondition{}, `"status" isn't <field><op><value>`},
+		{"status>=", eventCondition{}, `"status>=" isn't a status comparison`}, // > with the value =
+		{"=400", eventCondition{}, `"=400" isn't <field><op><value>`},
+		{"Status=400", eventCondition{}, `"Status=400" isn't <field><op><value>`},
+		{"ip>10.0.0.1", eventCondition{}, "ip only takes = != or ~"},
+		{"path<=/b", eventCondition{}, "path only takes = != or ~"},
+		{"status~40", eventCondition{}, `"status~40" isn't a status comparison`},
+		{"status=4xx", eventCondition{}, `"status=4xx" isn't a status comparison`},
+		{"time~2024", eventCondition{}, `"time~2024" isn't a time comparison`},
+		{"time>today", eventCondition{}, `"time>today" isn't a time comparison`},
+		{"host=example.com", eventCondition{}, "unknown field host - s/b ip, method, path, status or time"},
+	}
+	for _, c := range cases {
+		t.Run(c.text, func(t *testing.T) {
+			condition, err := parseEventCondition(c.text)
+			if len(c.problem) > 0 {
+				if err == nil || err.Error() != c.problem {
+					t.Errorf("parsed as %+v, error %v; expected %s", condition, err, c.problem)
+				}
+				return
+			}
+			if err != nil || condition.field != c.want.field || condition.op != c.want.op || condition.value != c.want.value ||
+				condition.number != c.want.number || !condition.at.Equal(c.want.at) {
+				t.Errorf("parsed as %+v, error %v; expected %+v", condition, err, c.want)
+			}
+		})
+	}
+}
+
+func TestEventConditionMatches(t *testing.T) {
+	item := networkDataItem{time.Date(2024, time.March, 1, 10, 0, 0, 0, LOG_LOCATION), "10.0.0.12", "POST", "/admin/login", 404}
+	cases := []struct {
+		condition string
+		matches   bool
+	}{
+		{"ip=10.0.0.12", true},
+		{"ip=10.0.0.1", false}, // whole, not a prefix
+		{"ip~10.0.0.1", true},
+		{"ip!=10.0.0.12", false},
+		{"ip!=10.0.0.1", true},
+		{"method=POST", true},
+		{"method=post", true},
+		{"method~os", true},
+		{"method!=get", true},
+		{"method=GET", false},
+		{"path=/admin/login", true},
+		{"path=/ADMIN/login", false}, // paths are case sensitive
+		{"path~admin", true},
+		{"path~Admin", false},
+		{"path!=/admin", true},
+		{"status=404", true},
+		{"status!=404", false},
+		{"status>=404", true},
+		{"status>=400", true},
+		{"status>404", false},
+		{"status<500", true},
+		{"status<=404", true},
+		{"status<404", false},
+		{"time=2024-03-01T10:00:00", true},
+		{"time=2024-03-01", false}, // midnight, not the day
+		{"time>=2024-03-01T10:00", true},
+		{"time>2024-03-01T10:00", false},
+		{"time>2024-03-01", true},
+		{"time<2024-03-01T10:00:01", true},
+		{"time<=2024-03-01T09:59:59", false},
+		{"time!=2024-03-01T09:59", true},
+	}
+	for _, c := range cases {
+		t.Run(c.condition, func(t *testing.T) {
+			condition, err := parseEventCondition(c.condition)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if condition.matches(item) != c.matches {
+				t.Errorf("%s %s %s %s %d: matched %t", item.timestamp.Format(time.DateTime), item.ipAddr,
+					item.method, item.path, item.statusCode, !c.matches)
+			}
+		})
+	}
+
+	// a lower case method in the log still matches
+	item.method = "post"
+	for _, text := range []string{"method=POST", "method~OS"} {
+		if condition, _ := parseEventCondition(text); !condition.matches(item) {
+			t.Errorf("%s didn't match %s", text, item.method)
+		}
+	}
+}
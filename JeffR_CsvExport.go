@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// set via -csv <dir>; the tables are exported alongside whatever -output produces
+var csvDir = ""
+
+// csvTable is one exported file: a fixed header and its rows, in report order
+type csvTable struct {
+	fileName string
+	header   []string
+	rows     [][]string
+}
+
+// csvTables lays out the report model as pivot-friendly (one fact per row) tables
+func csvTables(data reportData) []csvTable {
+	ips := csvTable{"ips.csv",
+		[]string{"ip", "requests", "failed_logins", "succeeded", "failed", "up_weight", "down_weight", "first_seen", "last_seen"}, nil}
+	paths := csvTable{"paths_by_ip.csv",
+		[]string{"ip", "path", "method", "succeeded", "failed", "requests", "weight", "min_time_of_day", "max_time_of_day"}, nil}
+	weekdays := csvTable{"weekday_by_ip.csv",
+		[]string{"ip", "weekday", "succeeded", "failed", "requests"}, nil}
+
+	for _, ip := range data.IPs {
+		ips.rows = append(ips.rows, []string{ip.Address, itoa(ip.Requests), itoa(ip.FailedLogins),
+			i64toa(ip.Succeeded), i64toa(ip.Failed), i64toa(ip.UpWeight), i64toa(ip.DownWeight),
+			csvTime(ip.FirstSeen), csvTime(ip.LastSeen)})
+
+		for _, path := range ip.ByPath {
+			for _, method := range path.Methods {
+				paths.rows = append(paths.rows, []string{ip.Address, csvText(path.Path), csvText(method.Method),
+					i64toa(method.Succeeded), i64toa(method.Failed), i64toa(method.Succeeded + method.Failed),
+					i64toa(method.Weight), method.MinTimeOfDay, method.MaxTimeOfDay})
+			}
+		}
+
+		for _, weekday := range ip.ByWeekday {
+			weekdays.rows = append(weekdays.rows, []string{ip.Address, weekday.Weekday,
+				i64toa(weekday.Succeeded), i64toa(weekday.Requests - weekday.Succeeded), i64toa(weekday.Requests)})
+		}
+	}
+
+	spikes := csvTable{"spikes.csv",
+		[]string{"rank", "start_weekday", "start_time_of_day", "end_weekday", "end_time_of_day", "spans_seconds",
+			"requests", "days", "requests_per_second_per_day", "singleton"}, nil}
+	for i, spike := range data.Spikes {
+		spikes.rows = append(spikes.rows, []string{itoa(i + 1), spike.StartWeekday, spike.StartTimeOfDay,
+			spike.EndWeekday, spike.EndTimeOfDay, ftoa(spike.SpansSeconds), i64toa(spike.Requests), itoa(spike.Days),
+			ftoa(spike.RequestsPerSecondPerDay), strconv.FormatBool(spike.Singleton)})
+	}
+
+	cyclicalGaps := csvTable{"cyclical_gaps.csv",
+		[]string{"rank", "start_weekday", "start_time_of_day", "end_weekday", "end_time_of_day", "spans_seconds"}, nil}
+	for i, gap := range data.CyclicalGaps {
+		cyclicalGaps.rows = append(cyclicalGaps.rows, []string{itoa(i + 1), gap.StartWeekday, gap.StartTimeOfDay,
+			gap.EndWeekday, gap.EndTimeOfDay, ftoa(gap.SpansSeconds)})
+	}
+
+	absoluteGaps := csvTable{"absolute_gaps.csv",
+		[]string{"rank", "start", "end", "elapsed_seconds"}, nil}
+	for i, gap := range data.AbsoluteGaps {
+		absoluteGaps.rows = append(absoluteGaps.rows, []string{itoa(i + 1), csvTime(gap.Start), csvTime(gap.End),
+			ftoa(gap.ElapsedSeconds)})
+	}
+
+	return []csvTable{ips, paths, weekdays, spikes, cyclicalGaps, absoluteGaps}
+}
+
+// writeCsvExport writes each table to its own file in dir, creating dir as needed;
+// encoding/csv takes care of quoting paths with commas, quotes or newlines
+func writeCsvExport(dir string, data reportData) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	for _, table := range csvTables(data) {
+		file, err := os.Create(filepath.Join(dir, table.fileName))
+		if err != nil {
+			return err
+		}
+
+		writer := csv.NewWriter(file)
+		writer.Write(table.header)
+		writer.WriteAll(table.rows) // flushes
+
+		err = writer.Error()
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// csvText guards text from the log: a client picks its path and method, and a spreadsheet
+// would run a cell starting =, +, - or @ (or a tab or CR ahead of one) as a formula
+func csvText(s string) string {
+	if len(s) > 0 && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func itoa(v int) string {
+	return strconv.Itoa(v)
+}
+
+func i64toa(v int64) string {
+	return strconv.FormatInt(v, 10)
+}
+
+func ftoa(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// spreadsheets recognize this layout as a date/time
+func csvTime(t time.Time) string {
+	return t.Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// readCsvExport is each exported file's records, header first, by file name
+func readCsvExport(t *testing.T, dir string) map[string][][]string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := make(map[string][][]string)
+	for _, fileSpec := range files {
+		file, err := os.Open(fileSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records, err := csv.NewReader(file).ReadAll()
+		file.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", fileSpec, err)
+		}
+		tables[filepath.Base(fileSpec)] = records
+	}
+	return tables
+}
+
+func TestCsvExportLayout(t *testing.T) {
+	dir := t.TempDir()
+	if err := writeCsvExport(dir, analyzeSample(t, writeAttackLog(t))); err != nil {
+		t.Fatal(err)
+	}
+	tables := readCsvExport(t, dir)
+
+	headers := map[string][]string{
+		"ips.csv":           {"ip", "requests", "failed_logins", "succeeded", "failed", "up_weight", "down_weight", "first_seen", "last_seen"},
+		"paths_by_ip.csv":   {"ip", "path", "method", "succeeded", "failed", "requests", "weight", "min_time_of_day", "max_time_of_day"},
+		"weekday_by_ip.csv": {"ip", "weekday", "succeeded", "failed", "requests"},
+		"spikes.csv": {"rank", "start_weekday", "start_time_of_day", "end_weekday", "end_time_of_day", "spans_seconds",
+			"requests", "days", "requests_per_second_per_day", "singleton"},
+		"cyclical_gaps.csv": {"rank", "start_weekday", "start_time_of_day", "end_weekday", "end_time_of_day", "spans_seconds"},
+		"absolute_gaps.csv": {"rank", "start", "end", "elapsed_seconds"},
+	}
+	if len(tables) != len(headers) {
+		t.Errorf("exported %d files, expected %d", len(tables), len(headers))
+	}
+	for name, header := range headers {
+		records, ok := tables[name]
+		if !ok || len(records) == 0 {
+			t.Errorf("no %s", name)
+			continue
+		}
+		if !slices.Equal(records[0], header) {
+			t.Errorf("%s header %v s/b %v", name, records[0], header)
+		}
+		for i, record := range records[1:] {
+			if len(record) != len(header) {
+				t.Errorf("%s row %d has %d columns, expected %d", name, i+1, len(record), len(header))
+			}
+		}
+	}
+
+	// one fact per row: 10.0.0.9's 8 failed logins, all on POST /login, on a Friday; the
+	// numbers aren't text, so a negative weight isn't guarded
+	want := map[string][]string{
+		"ips.csv":           {"10.0.0.9", "8", "8", "0", "8", "0", "-8", "2024-03-01 10:00:00", "2024-03-01 10:00:35"},
+		"paths_by_ip.csv":   {"10.0.0.9", "/login", "POST", "0", "8", "8"},
+		"weekday_by_ip.csv": {"10.0.0.9", "Friday", "0", "8", "8"},
+	}
+	for name, prefix := range want {
+		found := false
+		for _, record := range tables[name][1:] {
+			if record[0] == prefix[0] {
+				found = true
+				if !slices.Equal(record[:len(prefix)], prefix) {
+					t.Errorf("%s row %v, expected it to start %v", name, record, prefix)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("%s has no row for %s", name, prefix[0])
+		}
+	}
+}
+
+// a path or method from the log can't be run as a formula, and quotes, commas and
+// newlines come back out of the file as they went in
+func TestCsvExportQuotesAndGuardsText(t *testing.T) {
+	paths := []string{"/plain", `/say"hi"`, "/a,b", "/line\nbreak", "=1+2", "+1", "-1", "@SUM(A1)", "\t=1", "/=not-first"}
+	guarded := []string{"/plain", `/say"hi"`, "/a,b", "/line\nbreak", "'=1+2", "'+1", "'-1", "'@SUM(A1)", "'\t=1", "/=not-first"}
+	ip := reportIP{Address: "10.0.0.1", Requests: len(paths)}
+	for _, path := range paths {
+		ip.ByPath = append(ip.ByPath, reportPath{Path: path, Requests: 1, Methods: []reportMethod{{Method: "GET", Succeeded: 1}}})
+	}
+	ip.ByPath = append(ip.ByPath, reportPath{Path: "/", Requests: 1, Methods: []reportMethod{{Method: "=CMD", Succeeded: 1}}})
+
+	dir := t.TempDir()
+	if err := writeCsvExport(dir, reportData{IPs: []reportIP{ip}}); err != nil {
+		t.Fatal(err)
+	}
+	records := readCsvExport(t, dir)["paths_by_ip.csv"][1:]
+	if len(records) != len(paths)+1 {
+		t.Fatalf("%d rows, expected %d", len(records), len(paths)+1)
+	}
+	for i, want := range guarded {
+		if records[i][1] != want {
+			t.Errorf("path %q exported as %q, expected %q", paths[i], records[i][1], want)
+		}
+	}
+	if method := records[len(paths)][2]; method != "'=CMD" {
+		t.Errorf("method =CMD exported as %q", method)
+	}
+
+	raw, err := os.ReadFile(filepath.Join(dir, "paths_by_ip.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, quoted := range []string{`"/say""hi"""`, `"/a,b"`, "\"/line\nbreak\""} {
+		if !strings.Contains(string(raw), quoted) {
+			t.Errorf("no %s in:\n%s", quoted, raw)
+		}
+	}
+}
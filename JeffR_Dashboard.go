@@ -0,0 +1,616 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+// the -interactive terminal dashboard: a sortable IP list with drill-down into an IP's paths
+// and events, a spikes panel that follows the filters, and filters by time range, status
+// class and path; everything works off the in-memory analysis, nothing is re-read
+
+const DASHBOARD_SPIKES = 5   // busiest 5 minute buckets in the spikes panel
+const DASHBOARD_IP_PATHS = 8 // paths listed above an IP's events
+const DASHBOARD_REDRAW = 500 * time.Millisecond
+
+const ansiReverse = "7"
+
+var dashboardSorts = []string{"requests", "failures", "weight"}
+var dashboardStatusClasses = []string{"all", "2xx", "3xx", "4xx", "5xx", "errors"}
+
+type dashboardFilter struct {
+	from   time.Time // inclusive, zero for the start of the log
+	to     time.Time // exclusive, zero for the end of the log
+	status string    // one of dashboardStatusClasses
+	path   string    // substring, empty for any
+}
+
+func (f dashboardFilter) matches(item networkDataItem) bool {
+	if !f.from.IsZero() && item.timestamp.Before(f.from) {
+		return false
+	}
+	if !f.to.IsZero() && !item.timestamp.Before(f.to) {
+		return false
+	}
+	switch f.status {
+	case "2xx", "3xx", "4xx", "5xx":
+		if item.statusCode/100 != int(f.status[0]-'0') {
+			return false
+		}
+	case "errors":
+		if !isHttpError(item.statusCode) {
+			return false
+		}
+	}
+	return len(f.path) == 0 || strings.Contains(item.path, f.path)
+}
+
+func (f dashboardFilter) String() string {
+	span := "all"
+	if !f.from.IsZero() || !f.to.IsZero() {
+		span = dashboardTime(f.from) + " to " + dashboardTime(f.to)
+	}
+	path := "*"
+	if len(f.path) > 0 {
+		path = "*" + f.path + "*"
+	}
+	return fmt.Sprintf("time: %s | status: %s | path: %s", span, f.status, path)
+}
+
+func dashboardTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format("2006-01-02T15:04:05")
+}
+
+// parseTimeRange reads "<from> [<to>]", either end "-" for open; a date-only <to> includes that day
+func parseTimeRange(text string) (time.Time, time.Time, error) {
+	fields := strings.Fields(text)
+	if len(fields) > 2 {
+		return time.Time{}, time.Time{}, fmt.Errorf("expected <from> [<to>], got %q", text)
+	}
+
+	ends := []time.Time{{}, {}}
+	for i, field := range fields {
+		if field == "-" {
+			continue
+		}
+		t, dateOnly, err := parseFilterTime(field)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		if i == 1 && dateOnly {
+			t = t.AddDate(0, 0, 1)
+		}
+		ends[i] = t
+	}
+	return ends[0], ends[1], nil
+}
+
+// parseFilterTime reads a log-style time, as precise as wanted; also says if it's just a date
+func parseFilterTime(text string) (time.Time, bool, error) {
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, text, LOG_LOCATION); err == nil {
+			return t, layout == "2006-01-02", nil
+		}
+	}
+	return time.Time{}, false, fmt.Errorf("can't read %q as YYYY-MM-DD[Thh:mm[:ss]]", text)
+}
+
+type dashboardIP struct {
+	address  string
+	requests int
+	failures int
+	weight   int64
+}
+
+type dashboardSpike struct {
+	start    time.Time
+	requests int
+}
+
+type dashboard struct {
+	out    terminal
+	width  int
+	height int
+
+	filter dashboardFilter
+	sortBy int
+
+	events  []networkDataItem // matching the filter
+	ips     []dashboardIP
+	spikes  []dashboardSpike
+	average float64 // requests per 5 minutes over the filtered events' span
+
+	cursor int
+	top    int
+
+	drill    string // the IP drilled into, if any
+	drillTop int
+
+	prompt  string // what's being asked for, while reading a filter
+	input   string
+	message string
+}
+
+// runDashboard browses the loaded analysis until q
+func runDashboard() bool {
+	if !isTerminal(os.Stdout) || !isTerminal(os.Stdin) {
+		log.Println("ERR: the dashboard needs a terminal")
+		return false
+	}
+
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		log.Println("ERR:", err)
+		return false
+	}
+	defer tty.Close()
+
+	saved, err := stty(tty, "-g")
+	if err != nil {
+		log.Println("ERR: can't read terminal settings:", err)
+		return false
+	}
+	if _, err := stty(tty, "raw", "-echo"); err != nil {
+		log.Println("ERR: can't set up terminal:", err)
+		return false
+	}
+
+	// alternate screen, cursor hidden; put everything back however we leave
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer func() {
+		fmt.Print("\x1b[?25h\x1b[?1049l")
+		stty(tty, saved)
+	}()
+
+	_, noColor := os.LookupEnv("NO_COLOR")
+	d := &dashboard{out: terminal{color: !noColor && os.Getenv("TERM") != "dumb"}}
+	d.filter.status = "all"
+	d.height, d.width = ttySize()
+	d.refresh()
+	d.render()
+
+	keys := make(chan string)
+	go readKeys(tty, keys)
+
+	terminated := make(chan os.Signal, 1)
+	signal.Notify(terminated, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(terminated)
+
+	redraw := time.NewTicker(DASHBOARD_REDRAW)
+	defer redraw.Stop()
+
+	for {
+		select {
+		case key, ok := <-keys:
+			if !ok || !d.handleKey(key) {
+				return true
+			}
+			d.render()
+		case <-redraw.C:
+			// pick up terminal resizes
+			if height, width := ttySize(); height != d.height || width != d.width {
+				d.height, d.width = height, width
+				d.render()
+			}
+		case <-terminated:
+			return true
+		}
+	}
+}
+
+// readKeys turns terminal input into key names ("up", "enter", ...) or the characters typed
+func readKeys(tty *os.File, keys chan<- string) {
+	defer close(keys)
+
+	sequences := map[string]string{
+		"\x1b[A": "up", "\x1b[B": "down", "\x1b[C": "right", "\x1b[D": "left",
+		"\x1bOA": "up", "\x1bOB": "down", "\x1bOC": "right", "\x1bOD": "left",
+		"\x1b[5~": "pgup", "\x1b[6~": "pgdn",
+		"\x1b[H": "home", "\x1b[1~": "home", "\x1bOH": "home",
+		"\x1b[F": "end", "\x1b[4~": "end", "\x1bOF": "end",
+		"\x1b": "esc", "\r": "enter", "\n": "enter", "\x7f": "backspace", "\x08": "backspace", "\x03": "quit",
+	}
+
+	buf := make([]byte, 256)
+	for {
+		n, err := tty.Read(buf)
+		if err != nil {
+			return
+		}
+		chunk := string(buf[:n])
+		if name, ok := sequences[chunk]; ok {
+			keys <- name
+			continue
+		}
+		if strings.HasPrefix(chunk, "\x1b") {
+			continue // an escape sequence we don't use
+		}
+		for _, r := range chunk {
+			if name, ok := sequences[string(r)]; ok {
+				keys <- name
+			} else if r >= ' ' {
+				keys <- string(r)
+			}
+		}
+	}
+}
+
+// refresh re-applies the filter and sort to the analysis
+func (d *dashboard) refresh() {
+	d.events = d.events[:0]
+	rows := make(map[string]*dashboardIP)
+	for _, item := range networkData {
+		if !d.filter.matches(item) {
+			continue
+		}
+		d.events = append(d.events, item)
+
+		row, ok := rows[item.ipAddr]
+		if !ok {
+			row = &dashboardIP{address: item.ipAddr}
+			rows[item.ipAddr] = row
+		}
+		row.requests++
+		if isHttpError(item.statusCode) {
+			row.failures++
+		}
+	}
+
+	d.ips = d.ips[:0]
+	for address, row := range rows {
+		for path, methods := range trafficByIP[address].byPath {
+			if len(d.filter.path) == 0 || strings.Contains(path, d.filter.path) {
+				for _, results := range methods {
+					row.weight += results.weight
+				}
+			}
+		}
+		d.ips = append(d.ips, *row)
+	}
+
+	slices.SortFunc(d.ips, func(a dashboardIP, b dashboardIP) int {
+		var order int
+		switch dashboardSorts[d.sortBy] {
+		case "requests":
+			order = b.requests - a.requests
+		case "failures":
+			order = b.failures - a.failures
+		case "weight":
+			// the most discredited first, as in the path report
+			order = int(a.weight - b.weight)
+		}
+		if order == 0 {
+			order = strings.Compare(a.address, b.address)
+		}
+		return order
+	})
+
+	d.cursor = min(d.cursor, max(len(d.ips)-1, 0))
+	d.refreshSpikes()
+}
+
+// refreshSpikes finds the busiest 5 minute buckets among the filtered events
+func (d *dashboard) refreshSpikes() {
+	d.spikes, d.average = busiestBuckets(d.events, SPIKE_BUCKET, DASHBOARD_SPIKES)
+}
+
+// busiestBuckets ranks time-ordered events' buckets of the given size, busiest first;
+// also returns the average per bucket over their span, quiet buckets included
+func busiestBuckets(events []networkDataItem, bucket time.Duration, top int) ([]dashboardSpike, float64) {
+	spikes := make([]dashboardSpike, 0)
+	if len(events) == 0 {
+		return spikes, 0
+	}
+
+	buckets := make(map[time.Time]int)
+	for _, item := range events {
+		buckets[item.timestamp.Truncate(bucket)]++
+	}
+
+	first := events[0].timestamp.Truncate(bucket)
+	last := events[len(events)-1].timestamp.Truncate(bucket)
+	average := float64(len(events)) / float64(last.Sub(first)/bucket+1)
+
+	for start, requests := range buckets {
+		spikes = append(spikes, dashboardSpike{start, requests})
+	}
+	slices.SortFunc(spikes, func(a dashboardSpike, b dashboardSpike) int {
+		if a.requests != b.requests {
+			return b.requests - a.requests
+		}
+		return a.start.Compare(b.start)
+	})
+	return spikes[:min(len(spikes), top)], average
+}
+
+// handleKey acts on a key; false to quit
+func (d *dashboard) handleKey(key string) bool {
+	d.message = ""
+
+	if len(d.prompt) > 0 {
+		switch key {
+		case "enter":
+			d.applyPrompt()
+		case "esc":
+			d.prompt = ""
+		case "backspace":
+			if len(d.input) > 0 {
+				_, size := utf8.DecodeLastRuneInString(d.input)
+				d.input = d.input[:len(d.input)-size]
+			}
+		case "quit":
+			return false
+		default:
+			if utf8.RuneCountInString(key) == 1 {
+				d.input += key
+			}
+		}
+		return true
+	}
+
+	page := max(d.listHeight()-1, 1)
+	switch key {
+	case "q", "quit":
+		return false
+	case "up", "k":
+		d.scroll(-1)
+	case "down", "j":
+		d.scroll(1)
+	case "pgup":
+		d.scroll(-page)
+	case "pgdn", " ":
+		d.scroll(page)
+	case "home", "g":
+		d.scroll(-len(networkData))
+	case "end", "G":
+		d.scroll(len(networkData))
+	case "enter", "right", "l":
+		if len(d.drill) == 0 && len(d.ips) > 0 {
+			d.drill = d.ips[d.cursor].address
+			d.drillTop = 0
+		}
+	case "esc", "left", "backspace", "h":
+		d.drill = ""
+	case "s":
+		d.sortBy = (d.sortBy + 1) % len(dashboardSorts)
+		d.refresh()
+	case "c":
+		next := (slices.Index(dashboardStatusClasses, d.filter.status) + 1) % len(dashboardStatusClasses)
+		d.filter.status = dashboardStatusClasses[next]
+		d.refresh()
+	case "t":
+		d.prompt = "time from [to] (YYYY-MM-DD[Thh:mm[:ss]], - open): "
+		d.input = ""
+		if !d.filter.from.IsZero() || !d.filter.to.IsZero() {
+			d.input = dashboardTime(d.filter.from) + " " + dashboardTime(d.filter.to)
+		}
+	case "p":
+		d.prompt = "path contains: "
+		d.input = d.filter.path
+	case "r":
+		d.filter = dashboardFilter{status: "all"}
+		d.refresh()
+	}
+	return true
+}
+
+func (d *dashboard) applyPrompt() {
+	if strings.HasPrefix(d.prompt, "time") {
+		from, to, err := parseTimeRange(d.input)
+		if err != nil {
+			d.message = err.Error()
+			return
+		}
+		d.filter.from, d.filter.to = from, to
+	} else {
+		d.filter.path = strings.TrimSpace(d.input)
+	}
+	d.prompt = ""
+	d.refresh()
+}
+
+// scroll moves the cursor through the IP list, or pages an IP's events
+func (d *dashboard) scroll(by int) {
+	if len(d.drill) > 0 {
+		d.drillTop = max(min(d.drillTop+by, len(d.drillEvents())-1), 0)
+		return
+	}
+
+	d.cursor = max(min(d.cursor+by, len(d.ips)-1), 0)
+	height := d.listHeight()
+	if d.cursor < d.top {
+		d.top = d.cursor
+	} else if d.cursor >= d.top+height {
+		d.top = d.cursor - height + 1
+	}
+}
+
+func (d *dashboard) drillEvents() []networkDataItem {
+	events := make([]networkDataItem, 0)
+	for _, item := range d.events {
+		if item.ipAddr == d.drill {
+			events = append(events, item)
+		}
+	}
+	return events
+}
+
+// listHeight is the rows left for the IP list (or an IP's detail) between the title,
+// the spikes panel and the footer
+func (d *dashboard) listHeight() int {
+	return max(d.screenHeight()-4-(DASHBOARD_SPIKES+2)-1, 1)
+}
+
+func (d *dashboard) screenHeight() int {
+	if d.height > 0 {
+		return d.height
+	}
+	return 24
+}
+
+func (d *dashboard) screenWidth() int {
+	if d.width > 0 {
+		return d.width
+	}
+	return DEFAULT_TERMINAL_WIDTH
+}
+
+// render redraws the whole screen in one write
+func (d *dashboard) render() {
+	width := d.screenWidth()
+	lines := make([]string, 0, d.screenHeight())
+	line := func(text string, attributes ...string) {
+		if utf8.RuneCountInString(text) > width {
+			text = string([]rune(text)[:width])
+		}
+		lines = append(lines, d.out.paint(padRight(text, width), attributes...))
+	}
+
+	line(fmt.Sprintf("Network Detective - %d of %d events, %d IPs - sorted by %s",
+		len(d.events), len(networkData), len(d.ips), dashboardSorts[d.sortBy]), ansiReverse)
+	line(d.filter.String())
+	line("")
+
+	if len(d.drill) > 0 {
+		d.renderDrill(line)
+	} else {
+		d.renderIPs(line)
+	}
+
+	// the spikes panel sits above the footer
+	for len(lines) < d.screenHeight()-1-(len(d.spikes)+1) {
+		line("")
+	}
+	line(fmt.Sprintf("Busiest 5 minutes (%.1f requests per 5m on average)", d.average), ansiBold)
+	for _, spike := range d.spikes {
+		factor := 0.0
+		if d.average > 0 {
+			factor = float64(spike.requests) / d.average
+		}
+		text := fmt.Sprintf("  %s %-9s %6d requests  x%.1f", spike.start.Format("2006-01-02 15:04"),
+			spike.start.Weekday(), spike.requests, factor)
+		if factor >= SPIKE_FACTOR && spike.requests >= SPIKE_MIN_REQUESTS {
+			line(text, ansiRed)
+		} else {
+			line(text)
+		}
+	}
+
+	switch {
+	case len(d.prompt) > 0 && len(d.message) > 0:
+		line(d.prompt+d.input+"_  "+d.message, ansiRed)
+	case len(d.prompt) > 0:
+		line(d.prompt + d.input + "_")
+	case len(d.message) > 0:
+		line(d.message, ansiRed)
+	case len(d.drill) > 0:
+		line("up/down scroll  esc back  t time  c status  p path  r reset  q quit", ansiReverse)
+	default:
+		line("up/down move  enter drill in  s sort  t time  c status  p path  r reset  q quit", ansiReverse)
+	}
+
+	fmt.Print("\x1b[H" + strings.Join(lines[:min(len(lines), d.screenHeight())], "\r\n"))
+}
+
+func (d *dashboard) renderIPs(line func(string, ...string)) {
+	addresses := make([]string, 0, len(d.ips))
+	for _, row := range d.ips {
+		addresses = append(addresses, row.address)
+	}
+	ipWidth := columnWidth(15, addresses...)
+
+	line(fmt.Sprintf("%s  %10s  %10s  %8s", padRight("IP", ipWidth), "#Requests", "#Failures", "Weight"), ansiBold)
+	height := d.listHeight()
+	for i := d.top; i < d.top+height; i++ {
+		if i >= len(d.ips) {
+			line("")
+			continue
+		}
+		row := d.ips[i]
+		text := fmt.Sprintf("%s  %10d  %10d  %8d", padRight(row.address, ipWidth), row.requests, row.failures, row.weight)
+		switch {
+		case i == d.cursor:
+			line(text, ansiReverse)
+		case row.failures > 0:
+			line(text, ansiRed)
+		default:
+			line(text)
+		}
+	}
+}
+
+func (d *dashboard) renderDrill(line func(string, ...string)) {
+	events := d.drillEvents()
+
+	// the IP's paths among the filtered events, with their analysis weight
+	type pathSummary struct {
+		path      string
+		methods   string
+		succeeded int
+		requests  int
+	}
+	summaries := make([]pathSummary, 0)
+	for _, item := range events {
+		i := slices.IndexFunc(summaries, func(summary pathSummary) bool { return summary.path == item.path })
+		if i < 0 {
+			summaries = append(summaries, pathSummary{path: item.path})
+			i = len(summaries) - 1
+		}
+		if !strings.Contains(summaries[i].methods, methodAbbrev(item.method)) {
+			summaries[i].methods += methodAbbrev(item.method)
+		}
+		summaries[i].requests++
+		if isHttpSuccess(item.statusCode) {
+			summaries[i].succeeded++
+		}
+	}
+	slices.SortFunc(summaries, func(a pathSummary, b pathSummary) int { return b.requests - a.requests })
+
+	line(fmt.Sprintf("%s - %d events on %d paths", d.drill, len(events), len(summaries)), ansiBold)
+	used := 1
+	for i, summary := range summaries {
+		if i == DASHBOARD_IP_PATHS {
+			line(fmt.Sprintf("  ... %d more paths", len(summaries)-i))
+			used++
+			break
+		}
+		var weight int64
+		for _, results := range trafficByIP[d.drill].byPath[summary.path] {
+			weight += results.weight
+		}
+		text := fmt.Sprintf("  %-40s %-4s %5d/%-5d weight %d", summary.path, summary.methods, summary.succeeded, summary.requests, weight)
+		if summary.succeeded < summary.requests {
+			line(text, ansiYellow)
+		} else {
+			line(text)
+		}
+		used++
+	}
+
+	line("")
+	used++
+	for i := d.drillTop; used < d.listHeight()+1; i++ {
+		used++
+		if i >= len(events) {
+			line("")
+			continue
+		}
+		item := events[i]
+		text := fmt.Sprintf("  %s  %-7s %-40s %d", item.timestamp.Format("2006-01-02 15:04:05"), item.method, item.path, item.statusCode)
+		if isHttpError(item.statusCode) {
+			line(text, ansiRed)
+		} else {
+			line(text)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFilterTime(t *testing.T) {
+	cases := []struct {
+		text     string
+		at       string
+		dateOnly bool
+		problem  bool
+	}{
+		{"2024-03-01T10:20:30", "2024-03-01T10:20:30", false, false},
+		{"2024-03-01T10:20", "2024-03-01T10:20:00", false, false},
+		{"2024-03-01", "2024-03-01T00:00:00", true, false},
+		{"2024-02-29", "2024-02-29T00:00:00", true, false},
+		{"2023-02-29", "", false, true},
+		{"2024-03-01T10", "", false, true},
+		{"2024-03-01 10:20", "", false, true},
+		{"2024-03-01T10:20:30Z", "", false, true},
+		{"10:20", "", false, true},
+		{"", "", false, true},
+	}
+	for _, c := range cases {
+		t.Run(c.text, func(t *testing.T) {
+			at, dateOnly, err := parseFilterTime(c.text)
+			if c.problem {
+				if err == nil || !strings.Contains(err.Error(), "YYYY-MM-DD[Thh:mm[:ss]]") {
+					t.Errorf("read as %v, error %v", at, err)
+				}
+				return
+			}
+			if err != nil || dashboardTime(at) != c.at || dateOnly != c.dateOnly || at.Location() != LOG_LOCATION {
+				t.Errorf("read as %s (date only %t, %v), error %v; expected %s (%t)", dashboardTime(at), dateOnly, at.Location(), err, c.at, c.dateOnly)
+			}
+		})
+	}
+}
+
+func TestParseTimeRange(t *testing.T) {
+	cases := []struct {
+		name    string
+		text    string
+		from    string
+		to      string
+		problem string
+	}{
+		{"nothing", "", "-", "-", ""},
+		{"from only", "2024-03-01T10:00", "2024-03-01T10:00:00", "-", ""},
+		{"both", "2024-03-01T10:00 2024-03-01T11:30:15", "2024-03-01T10:00:00", "2024-03-01T11:30:15", ""},
+		{"open start", "- 2024-03-01T11:00", "-", "2024-03-01T11:00:00", ""},
+		{"open end", "2024-03-01T10:00 -", "2024-03-01T10:00:00", "-", ""},
+		{"both open", "- -", "-", "-", ""},
+		{"date-only from is that day's start", "2024-03-01", "2024-03-01T00:00:00", "-", ""},
+		{"date-only to includes that day", "2024-03-01 2024-03-02", "2024-03-01T00:00:00", "2024-03-03T00:00:00", ""},
+		{"date-only to over a month end", "2024-02-01 2024-02-29", "2024-02-01T00:00:00", "2024-03-01T00:00:00", ""},
+		{"extra spaces", "  2024-03-01T10:00   2024-03-01T11:00  ", "2024-03-01T10:00:00", "2024-03-01T11:00:00", ""},
+		{"too many", "2024-03-01 2024-03-02 2024-03-03", "", "", "expected <from> [<to>]"},
+		{"bad from", "yesterday", "", "", `can't read "yesterday"`},
+		{"bad to", "2024-03-01 soon", "", "", `can't read "soon"`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			from, to, err := parseTimeRange(c.text)
+			if len(c.problem) > 0 {
+				if err == nil || !strings.Contains(err.Error(), c.problem) {
+					t.Errorf("read as %s %s, error %v; expected %s", dashboardTime(from), dashboardTime(to), err, c.problem)
+				}
+				return
+			}
+			if err != nil || dashboardTime(from) != c.from || dashboardTime(to) != c.to {
+				t.Errorf("read as %s %s, error %v; expected %s %s", dashboardTime(from), dashboardTime(to), err, c.from, c.to)
+			}
+		})
+	}
+}
+
+func TestBusiestBuckets(t *testing.T) {
+	base := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	// events at the given minutes after 10:00, in order
+	events := func(minutes ...float64) []networkDataItem {
+		items := make([]networkDataItem, 0, len(minutes))
+		for _, minute := range minutes {
+			items = append(items, networkDataItem{base.Add(time.Duration(minute * float64(time.Minute))), "10.0.0.1", "GET", "/", 200})
+		}
+		return items
+	}
+	cases := []struct {
+		name    string
+		events  []networkDataItem
+		top     int
+		spikes  string // "<minutes after 10:00>:<requests>", busiest first
+		average float64
+	}{
+		{"none", nil, 3, "", 0},
+		{"one bucket", events(0, 1, 4.9), 3, "0:3", 3},
+		{"ranked", events(0, 5, 6, 7, 10, 11), 3, "5:3 10:2 0:1", 2},
+		{"ties earliest first", events(0, 1, 5, 6, 10), 3, "0:2 5:2 10:1", 5.0 / 3},
+		{"quiet buckets count towards the average", events(0, 1, 20), 3, "0:2 20:1", 3.0 / 5},
+		{"cut to the top", events(0, 5, 5, 10, 10, 10, 15, 15, 15, 15), 2, "15:4 10:3", 10.0 / 4},
+		{"bucket start, not the event", events(4.99, 5), 3, "0:1 5:1", 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			spikes, average := busiestBuckets(c.events, 5*time.Minute, c.top)
+			ranked := make([]string, 0, len(spikes))
+			for _, spike := range spikes {
+				ranked = append(ranked, fmt.Sprintf("%d:%d", int(spike.start.Sub(base).Minutes()), spike.requests))
+			}
+			if strings.Join(ranked, " ") != c.spikes || fmt.Sprintf("%.6f", average) != fmt.Sprintf("%.6f", c.average) {
+				t.Errorf("%v, average %g; expected %s, average %g", ranked, average, c.spikes, c.average)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+)
+
+// -email: the report mailed as a digest - a summary inline, the HTML and JSON reports
+// attached - so a cron job can deliver it with nothing else
+
+// set via -email <to,...>
+var emailTo = ""
+
+// set via -emailfrom; defaults to detective@<this host>
+var emailFrom = ""
+
+// set via -smtp <host:port>
+var smtpAddr = "localhost:25"
+
+// set via -smtptls: auto (STARTTLS when offered), starttls (required), tls (from the start, e.g. port 465) or none
+var smtpTls = "auto"
+
+// set via -smtpuser; the password comes from the environment, out of sight of ps
+var smtpUser = ""
+
+const SMTP_PASSWORD_ENV = "DETECTIVE_SMTP_PASSWORD"
+const SMTP_TIMEOUT = 30 * time.Second
+const EMAIL_TOP = 5 // IPs listed in the summary
+
+var smtpTlsModes = []string{"auto", "starttls", "tls", "none"}
+
+// sendEmailDigest mails the report to the -email recipients
+func sendEmailDigest(data reportData) error {
+	from, recipients, err := emailAddresses()
+	if err != nil {
+		return err
+	}
+
+	message, err := buildEmailDigest(data, from, recipients, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := sendMail(from, recipients, message); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(statusOut, "Mailed the report to", strings.Join(recipients, ", "))
+	return nil
+}
+
+func emailAddresses() (string, []string, error) {
+	from := emailFrom
+	if len(from) == 0 {
+		hostname, _ := os.Hostname()
+		from = "detective@" + hostname
+	}
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return "", nil, fmt.Errorf("-emailfrom %s: %v", from, err)
+	}
+
+	list, err := mail.ParseAddressList(emailTo)
+	if err != nil {
+		return "", nil, fmt.Errorf("-email %s: %v", emailTo, err)
+	}
+	recipients := make([]string, 0, len(list))
+	for _, address := range list {
+		recipients = append(recipients, address.Address)
+	}
+	return sender.Address, recipients, nil
+}
+
+// buildEmailDigest is the MIME message: the summary as text, then the reports as attachments
+func buildEmailDigest(data reportData, from string, recipients []string, now time.Time) ([]byte, error) {
+	var htmlReport, jsonReport bytes.Buffer
+	if err := writeHtmlReport(&htmlReport, data); err != nil {
+		return nil, err
+	}
+	if err := writeJsonReport(&jsonReport, data); err != nil {
+		return nil, err
+	}
+
+	var message bytes.Buffer
+	body := multipart.NewWriter(&message)
+
+	header := func(name string, value string) {
+		fmt.Fprintf(&message, "%s: %s\r\n", name, value)
+	}
+	header("From", from)
+	header("To", strings.Join(recipients, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", emailSubject(data)))
+	header("Date", now.Format(time.RFC1123Z))
+	header("Message-ID", "<"+randomUuid()+"@"+strings.SplitN(from, "@", 2)[1]+">")
+	header("MIME-Version", "1.0")
+	header("Content-Type", "multipart/mixed; boundary="+body.Boundary())
+	message.WriteString("\r\n")
+
+	part, err := body.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	text := quotedprintable.NewWriter(part)
+	writeEmailSummary(text, data)
+	text.Close()
+
+	base := strings.TrimSuffix(data.Source, ".log")
+	for _, attachment := range []struct {
+		fileName    string
+		contentType string
+		content     []byte
+	}{
+		{base + "-report.html", "text/html; charset=utf-8", htmlReport.Bytes()},
+		{base + "-report.json", "application/json", jsonReport.Bytes()},
+	} {
+		part, err := body.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {attachment.contentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.fileName})},
+		})
+		if err != nil {
+			return nil, err
+		}
+		writeBase64Lines(part, attachment.content)
+	}
+
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	return message.Bytes(), nil
+}
+
+func emailSubject(data reportData) string {
+	return fmt.Sprintf("Network Detective: %s - %s risk, %d findings, %d requests from %d IPs",
+		data.Source, strings.ToUpper(data.Summary.Risk), len(data.Findings), data.Totals.Requests, data.Totals.IPs)
+}
+
+// writeEmailSummary is the digest's inline text: the totals, the executive summary and the busiest failers
+func writeEmailSummary(w io.Writer, data reportData) {
+	totals := data.Totals
+	fmt.Fprintf(w, "Network Detective report on %s\n", data.Source)
+	fmt.Fprintf(w, "%s to %s\n\n", totals.Start.Format("2006-01-02 15:04:05"), totals.End.Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(w, "%d requests from %d IPs, %d failed logins, %d duplicate events dropped\n\n",
+		totals.Requests, totals.IPs, totals.FailedLogins, totals.DuplicatesDropped)
+
+	writeSummary(w, terminal{}, data.Summary)
+
+	failers := 0
+	for _, ip := range data.IPs[:min(len(data.IPs), EMAIL_TOP)] {
+		if ip.FailedLogins == 0 {
+			break
+		}
+		if failers == 0 {
+			fmt.Fprintln(w, "\nMost failed logins:")
+		}
+		failers++
+		fmt.Fprintf(w, "  %-15s %d of %d requests\n", ip.Address, ip.FailedLogins, ip.Requests)
+	}
+
+	fmt.Fprintln(w, "\nThe full report is attached, as HTML and as JSON.")
+}
+
+// writeBase64Lines base64 encodes in the 76 character lines MIME wants
+func writeBase64Lines(w io.Writer, content []byte) {
+	encoded := base64.StdEncoding.EncodeToString(content)
+	for len(encoded) > 76 {
+		io.WriteString(w, encoded[:76]+"\r\n")
+		encoded = encoded[76:]
+	}
+	io.WriteString(w, encoded+"\r\n")
+}
+
+// sendMail is smtp.SendMail with the TLS mode and timeout configurable
+func sendMail(from string, recipients []string, message []byte) error {
+	host, _, err := net.SplitHostPort(smtpAddr)
+	if err != nil {
+		return fmt.Errorf("-smtp %s s/b <host:port>", smtpAddr)
+	}
+	tlsConfig := &tls.Config{ServerName: host}
+
+	var conn net.Conn
+	dialer := &net.Dialer{Timeout: SMTP_TIMEOUT}
+	if smtpTls == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", smtpAddr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", smtpAddr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(SMTP_TIMEOUT))
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	hostname, err := os.Hostname()
+	if err != nil || len(hostname) == 0 {
+		hostname = "localhost"
+	}
+	if err := client.Hello(hostname); err != nil {
+		return err
+	}
+
+	if offered, _ := client.Extension("STARTTLS"); smtpTls == "starttls" || (smtpTls == "auto" && offered) {
+		if !offered {
+			return fmt.Errorf("%s doesn't offer STARTTLS", smtpAddr)
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+
+	if len(smtpUser) > 0 {
+		// PlainAuth itself refuses to send the password unencrypted, other than to localhost
+		if err := client.Auth(smtp.PlainAuth("", smtpUser, os.Getenv(SMTP_PASSWORD_ENV), host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, recipient := range recipients {
+		if err := client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(message); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
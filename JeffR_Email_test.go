@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// smtpServer is just enough of an SMTP server to take a message: it can offer STARTTLS,
+// but has no certificate, so refuses it when asked
+type smtpServer struct {
+	listener  net.Listener
+	starttls  bool
+	lock      sync.Mutex
+	commands  []string
+	message   []byte
+	connected sync.WaitGroup
+}
+
+func newSmtpServer(t *testing.T, offerStarttls bool) *smtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &smtpServer{listener: listener, starttls: offerStarttls}
+	server.connected.Add(1)
+	go server.serve()
+	t.Cleanup(func() { listener.Close() })
+	return server
+}
+
+func (s *smtpServer) serve() {
+	defer s.connected.Done()
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+
+	text.PrintfLine("220 test ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		s.lock.Lock()
+		s.commands = append(s.commands, line)
+		s.lock.Unlock()
+
+		switch verb {
+		case "EHLO":
+			if s.starttls {
+				text.PrintfLine("250-test")
+				text.PrintfLine("250-STARTTLS")
+			}
+			text.PrintfLine("250 8BITMIME")
+		case "STARTTLS":
+			text.PrintfLine("454 4.7.0 TLS not available")
+		case "MAIL", "RCPT", "RSET", "NOOP":
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 go ahead")
+			message, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.lock.Lock()
+			s.message = message
+			s.lock.Unlock()
+			text.PrintfLine("250 queued")
+		case "QUIT":
+			text.PrintfLine("221 bye")
+			return
+		default:
+			text.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+// session waits for the client to hang up, then is what it sent
+func (s *smtpServer) session() ([]string, []byte) {
+	s.connected.Wait()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.commands, s.message
+}
+
+func (s *smtpServer) sent(commands []string, command string) bool {
+	for _, sent := range commands {
+		if strings.HasPrefix(strings.ToUpper(sent), strings.ToUpper(command)) {
+			return true
+		}
+	}
+	return false
+}
+
+// useSmtp points the -email settings at the server, for the test
+func useSmtp(t *testing.T, server *smtpServer, tlsMode string) {
+	was := []string{smtpAddr, smtpTls, emailTo, emailFrom, smtpUser}
+	t.Cleanup(func() { smtpAddr, smtpTls, emailTo, emailFrom, smtpUser = was[0], was[1], was[2], was[3], was[4] })
+	smtpAddr, smtpTls = server.listener.Addr().String(), tlsMode
+	emailTo, emailFrom, smtpUser = "soc@example.com, Oncall <oncall@example.com>", "detective@example.com", ""
+}
+
+func TestEmailWithoutTls(t *testing.T) {
+	for _, mode := range []string{"none", "auto"} {
+		t.Run(mode, func(t *testing.T) {
+			// none doesn't use STARTTLS even when it's offered; auto doesn't when it isn't
+			server := newSmtpServer(t, mode == "none")
+			useSmtp(t, server, mode)
+			if err := sendEmailDigest(analyzeSample(t, writeAttackLog(t))); err != nil {
+				t.Fatal(err)
+			}
+
+			commands, message := server.session()
+			if server.sent(commands, "STARTTLS") {
+				t.Error("STARTTLS was sent")
+			}
+			for _, want := range []string{"MAIL FROM:<detective@example.com>", "RCPT TO:<soc@example.com>", "RCPT TO:<oncall@example.com>", "DATA", "QUIT"} {
+				if !server.sent(commands, want) {
+					t.Errorf("no %s in %v", want, commands)
+				}
+			}
+			if len(message) == 0 {
+				t.Error("no message was delivered")
+			}
+		})
+	}
+}
+
+// auto upgrades when STARTTLS is offered, so a server that then can't is an error, not a plaintext fallback
+func TestEmailAutoUsesOfferedStarttls(t *testing.T) {
+	server := newSmtpServer(t, true)
+	useSmtp(t, server, "auto")
+	if err := sendEmailDigest(analyzeSample(t, writeAttackLog(t))); err == nil {
+		t.Error("mailed without the STARTTLS the server offered")
+	}
+	if commands, _ := server.session(); !server.sent(commands, "STARTTLS") || server.sent(commands, "MAIL") {
+		t.Errorf("commands %v s/b STARTTLS, and no MAIL", commands)
+	}
+}
+
+func TestEmailStarttlsRequired(t *testing.T) {
+	server := newSmtpServer(t, false)
+	useSmtp(t, server, "starttls")
+	err := sendEmailDigest(analyzeSample(t, writeAttackLog(t)))
+	if err == nil || !strings.Contains(err.Error(), "doesn't offer STARTTLS") {
+		t.Errorf("error %v s/b that STARTTLS isn't offered", err)
+	}
+	if commands, _ := server.session(); server.sent(commands, "MAIL") {
+		t.Errorf("sent the message in the clear: %v", commands)
+	}
+}
+
+func TestEmailDigestMime(t *testing.T) {
+	server := newSmtpServer(t, false)
+	useSmtp(t, server, "auto")
+	data := analyzeSample(t, writeAttackLog(t))
+	if err := sendEmailDigest(data); err != nil {
+		t.Fatal(err)
+	}
+	_, delivered := server.session()
+
+	message, err := mail.ReadMessage(bytes.NewReader(delivered))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject, err := new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject")); err != nil || subject != emailSubject(data) {
+		t.Errorf("Subject %q s/b %q", subject, emailSubject(data))
+	}
+	if to := message.Header.Get("To"); to != "soc@example.com, oncall@example.com" {
+		t.Errorf("To %s", to)
+	}
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Content-Type %s s/b multipart/mixed", message.Header.Get("Content-Type"))
+	}
+
+	var html, report bytes.Buffer
+	writeHtmlReport(&html, data)
+	writeJsonReport(&report, data)
+	base := strings.TrimSuffix(data.Source, ".log")
+	want := []struct {
+		contentType string
+		encoding    string
+		fileName    string
+		content     []byte
+	}{
+		{"text/plain; charset=utf-8", "quoted-printable", "", nil},
+		{"text/html; charset=utf-8", "base64", base + "-report.html", html.Bytes()},
+		{"application/json", "base64", base + "-report.json", report.Bytes()},
+	}
+
+	parts := multipart.NewReader(message.Body, params["boundary"])
+	for i := 0; ; i++ {
+		part, err := parts.NextRawPart() // as sent, not decoded
+		if err == io.EOF {
+			if i != len(want) {
+				t.Errorf("%d parts, expected %d", i, len(want))
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i >= len(want) {
+			t.Fatalf("unexpected part %v", part.Header)
+		}
+		raw, _ := io.ReadAll(part)
+
+		if kind := part.Header.Get("Content-Type"); kind != want[i].contentType {
+			t.Errorf("part %d Content-Type %s s/b %s", i, kind, want[i].contentType)
+		}
+		if encoding := part.Header.Get("Content-Transfer-Encoding"); encoding != want[i].encoding {
+			t.Errorf("part %d Content-Transfer-Encoding %s s/b %s", i, encoding, want[i].encoding)
+		}
+		for _, line := range strings.Split(string(raw), "\n") { // the server's dot reader took the CRs
+			if len(line) > 76 {
+				t.Errorf("part %d has a %d character line", i, len(line))
+				break
+			}
+		}
+
+		if want[i].encoding == "quoted-printable" {
+			summary, err := io.ReadAll(quotedprintable.NewReader(bytes.NewReader(raw)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range []string{"Network Detective report on " + data.Source, "Most failed logins:", "2001:db8::7"} {
+				if !strings.Contains(string(summary), line) {
+					t.Errorf("summary lacks %q:\n%s", line, summary)
+				}
+			}
+			continue
+		}
+
+		disposition, dispositionParams, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+		if err != nil || disposition != "attachment" || dispositionParams["filename"] != want[i].fileName {
+			t.Errorf("part %d Content-Disposition %s s/b an attachment named %s", i, part.Header.Get("Content-Disposition"), want[i].fileName)
+		}
+		content, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(raw), "\n", ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(content, want[i].content) {
+			t.Errorf("part %d doesn't decode to the report", i)
+		}
+		if want[i].contentType == "application/json" && !json.Valid(content) {
+			t.Error("the JSON report attachment isn't JSON")
+		}
+	}
+}
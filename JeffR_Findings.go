@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+// findings: everything the analyses turn up that's worth a look, in one list ranked
+// most severe, then most certain, first - what every report's threat section renders
+
+// every finding from the last analyze()
+var findings []Finding
+
+const FINDING_EVIDENCE = 10       // events kept as a finding's evidence, the latest
+var FAILING_PATH_MIN_FAILURES = 3 // failures before a path an IP keeps failing is a finding; set via the config file
+
+// Finding is one thing worth a look, whichever analysis raised it
+type Finding struct {
+	Detector   string  // brute-force, scanning, spike, busy-period or failing-path
+	Severity   string  // high, medium or low
+	Confidence float64 // 0-1, how sure the analysis is that it's what it says
+	Subject    findingSubject
+	At         time.Time // when it was raised
+	Detail     string
+	Evidence   []int  // indexes into networkData
+	Action     string // what to do about it
+}
+
+// findingSubject is what a finding is about: an IP, a path, a time range, a recurring
+// time of the week, or some of those
+type findingSubject struct {
+	IP     string
+	Path   string
+	From   time.Time
+	To     time.Time
+	Period string // e.g. Friday 08:00:00 - Friday 08:04:59
+}
+
+func (s findingSubject) String() string {
+	switch {
+	case len(s.IP) > 0 && len(s.Path) > 0:
+		return s.IP + " " + s.Path
+	case len(s.IP) > 0:
+		return s.IP
+	case len(s.Period) > 0:
+		return s.Period
+	}
+	return s.From.Format("2006-01-02 15:04") + " - " + s.To.Format("15:04")
+}
+
+// what each detector is worth, and what to do when it trips
+var findingSeverities = map[string]string{
+	"brute-force":  "high",
+	"scanning":     "medium",
+	"spike":        "low",
+	"busy-period":  "low",
+	"failing-path": "low",
+}
+
+var findingActions = map[string]string{
+	"brute-force":  "Block or rate limit the IP, and check the accounts it targeted for compromise",
+	"scanning":     "Block the IP at the edge, and review what the probed paths expose",
+	"spike":        "Check whether the surge was expected (a release, a campaign) or a flood; rate limit if not",
+	"busy-period":  "Confirm the recurring peak is expected load, and size capacity and rate limits for it",
+	"failing-path": "Find out why the IP keeps failing where others succeed: a broken client, or probing",
+}
+
+var severityRanks = map[string]int{"low": 1, "medium": 2, "high": 3}
+
+// detectorSeverity ranks what each detector trips on
+func detectorSeverity(detector string) string {
+	if severity, ok := findingSeverities[detector]; ok {
+		return severity
+	}
+	return "low"
+}
+
+// rankFindings gathers the findings from every analysis - the detectors, the activity
+// spikes and the traffic weights - and ranks them; gaps are quiet spells, not threats
+func rankFindings() []Finding {
+	ranked := make([]Finding, 0, len(detectorAlerts))
+	flagged := make(map[string]bool)
+	for _, alert := range detectorAlerts {
+		finding := alertFinding(alert)
+		ranked = append(ranked, finding)
+		if len(finding.Subject.IP) > 0 {
+			flagged[finding.Subject.IP] = true
+		}
+	}
+
+	if finding, ok := busyPeriodFinding(); ok && detectorsEnabled["busy-period"] {
+		ranked = append(ranked, finding)
+	}
+
+	// a detector's finding already explains an IP's failures better
+	if detectorsEnabled["failing-path"] {
+		ranked = append(ranked, failingPathFindings(flagged)...)
+	}
+
+	slices.SortStableFunc(ranked, func(a Finding, b Finding) int {
+		if c := severityRanks[b.Severity] - severityRanks[a.Severity]; c != 0 {
+			return c
+		}
+		if a.Confidence != b.Confidence {
+			if a.Confidence > b.Confidence {
+				return -1
+			}
+			return 1
+		}
+		return a.At.Compare(b.At)
+	})
+	return ranked
+}
+
+// alertFinding is a stream detector's alert with its evidence weighed
+func alertFinding(alert followAlert) Finding {
+	finding := Finding{
+		Detector: alert.detector,
+		Severity: detectorSeverity(alert.detector),
+		At:       alert.at,
+		Detail:   alert.detail,
+		Action:   findingActions[alert.detector],
+	}
+
+	switch alert.detector {
+	case "brute-force":
+		finding.Subject = findingSubject{IP: alert.subject, From: alert.at.Add(-BRUTE_FORCE_WINDOW), To: alert.at}
+		finding.Evidence = ipEvidence(alert.subject, finding.Subject.From, alert.at, isFailedLogin)
+		// a user who mistypes, then gets in, is less likely an attacker than one who never does
+		finding.Confidence = ipShare(alert.subject, func(item networkDataItem) bool { return isLoginPath(item.path) },
+			func(item networkDataItem) bool { return isHttpError(item.statusCode) })
+	case "scanning":
+		finding.Subject = findingSubject{IP: alert.subject, From: alert.at.Add(-SCAN_WINDOW), To: alert.at}
+		finding.Evidence = ipEvidence(alert.subject, finding.Subject.From, alert.at, func(item networkDataItem) bool {
+			return item.statusCode/100 == 4
+		})
+		finding.Confidence = ipShare(alert.subject, func(item networkDataItem) bool { return true },
+			func(item networkDataItem) bool { return item.statusCode/100 == 4 })
+	case "spike":
+		bucket := alert.at.Truncate(SPIKE_BUCKET)
+		finding.Subject = findingSubject{From: bucket, To: bucket.Add(SPIKE_BUCKET)}
+		from, to := eventRange(bucket, bucket.Add(SPIKE_BUCKET))
+		_, tripped := eventRange(bucket, alert.at.Add(time.Nanosecond))
+		for i := max(from, tripped-FINDING_EVIDENCE); i < tripped; i++ {
+			finding.Evidence = append(finding.Evidence, i)
+		}
+		historyFrom, _ := eventRange(bucket.Add(-time.Duration(SPIKE_HISTORY)*SPIKE_BUCKET), bucket)
+		average := float64(from-historyFrom) / float64(SPIKE_HISTORY)
+		finding.Confidence = confidence(float64(to-from) / max(average, 1) / (2 * SPIKE_FACTOR))
+	}
+	return finding
+}
+
+// busyPeriodFinding is the busiest recurring time of day, when it's well above the log's average rate
+func busyPeriodFinding() (Finding, bool) {
+	span := maxTime.Sub(minTime).Seconds()
+	if len(activitySpikes) == 0 || span <= 0 {
+		return Finding{}, false
+	}
+	busiest := activitySpikes[0]
+	average := float64(totalRequests) / span
+	if busiest.requests < int64(SPIKE_MIN_REQUESTS) || busiest.avgRqs < SPIKE_FACTOR*average {
+		return Finding{}, false
+	}
+
+	return Finding{
+		Detector:   "busy-period",
+		Severity:   detectorSeverity("busy-period"),
+		Confidence: confidence(busiest.avgRqs / average / (2 * SPIKE_FACTOR)),
+		Subject:    findingSubject{Period: fmt.Sprintf("%s %s - %s %s", busiest.start.weekday, toClock(busiest.start.timeOfDay), busiest.end.weekday, toClock(busiest.end.timeOfDay))},
+		At:         maxTime,
+		Detail: fmt.Sprintf("%d requests, %.3f/s a day vs %.3f/s on average over the log",
+			busiest.requests, busiest.avgRqs, average),
+		Action: findingActions["busy-period"],
+	}, true
+}
+
+// failingPathFindings are the paths an IP keeps failing where other IPs succeed - a negative traffic weight
+func failingPathFindings(flagged map[string]bool) []Finding {
+	failing := make([]Finding, 0)
+	for _, ipAddr := range sortedKeys(trafficByIP) {
+		if flagged[ipAddr] {
+			continue
+		}
+		for _, path := range sortedKeys(trafficByIP[ipAddr].byPath) {
+			for _, method := range sortedKeys(trafficByIP[ipAddr].byPath[path]) {
+				results := trafficByIP[ipAddr].byPath[path][method]
+				if results.weight >= 0 || results.failed < int64(FAILING_PATH_MIN_FAILURES) {
+					continue
+				}
+
+				evidence := ipEvidence(ipAddr, minTime, maxTime, func(item networkDataItem) bool {
+					return item.path == path && item.method == method && isHttpError(item.statusCode)
+				})
+				at := maxTime
+				if len(evidence) > 0 {
+					at = networkData[evidence[len(evidence)-1]].timestamp
+				}
+				failing = append(failing, Finding{
+					Detector:   "failing-path",
+					Severity:   detectorSeverity("failing-path"),
+					Confidence: confidence(float64(results.failed) / float64(results.failed+results.succeeded)),
+					Subject:    findingSubject{IP: ipAddr, Path: path},
+					At:         at,
+					Detail: fmt.Sprintf("%s %s failed %d of %d times (weight %d)", method, path,
+						results.failed, results.failed+results.succeeded, results.weight),
+					Evidence: evidence,
+					Action:   findingActions["failing-path"],
+				})
+			}
+		}
+	}
+	return failing
+}
+
+// ipEvidence is the latest FINDING_EVIDENCE of the IP's events in [from, to] that match
+func ipEvidence(ipAddr string, from time.Time, to time.Time, matches func(item networkDataItem) bool) []int {
+	evidence := make([]int, 0)
+	for _, i := range byIP[ipAddr] {
+		item := networkData[i]
+		if !item.timestamp.Before(from) && !item.timestamp.After(to) && matches(item) {
+			evidence = append(evidence, i)
+		}
+	}
+	return evidence[max(0, len(evidence)-FINDING_EVIDENCE):]
+}
+
+// ipShare is the share of the IP's events that are of interest which are also bad
+func ipShare(ipAddr string, ofInterest func(item networkDataItem) bool, bad func(item networkDataItem) bool) float64 {
+	interesting, badOnes := 0, 0
+	for _, i := range byIP[ipAddr] {
+		if ofInterest(networkData[i]) {
+			interesting++
+			if bad(networkData[i]) {
+				badOnes++
+			}
+		}
+	}
+	if interesting == 0 {
+		return 0.5
+	}
+	return confidence(float64(badOnes) / float64(interesting))
+}
+
+// eventRange is the indexes of the (time ordered) events in [from, to)
+func eventRange(from time.Time, to time.Time) (int, int) {
+	start := sort.Search(len(networkData), func(i int) bool { return !networkData[i].timestamp.Before(from) })
+	end := sort.Search(len(networkData), func(i int) bool { return !networkData[i].timestamp.Before(to) })
+	return start, end
+}
+
+// confidencePercent is how reports show a confidence, e.g. 85%
+func confidencePercent(confidence float64) string {
+	return fmt.Sprintf("%.0f%%", confidence*100)
+}
+
+// confidence keeps a score in 0.5-1: a finding that made the threshold is at least a coin toss
+func confidence(score float64) float64 {
+	return min(max(score, 0.5), 1)
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// how often a followed log is checked for new lines / rotation / truncation
+const FOLLOW_POLL = 500 * time.Millisecond
+
+// alerts raised by the detectors over the whole data set, see analyze()
+var detectorAlerts []followAlert
+
+// detector thresholds; the config file's detectors section can change all but the bucket
+var (
+	BRUTE_FORCE_FAILURES = 5
+	BRUTE_FORCE_WINDOW   = 2 * time.Minute
+	SCAN_DISTINCT_PATHS  = 10
+	SCAN_WINDOW          = 5 * time.Minute
+	SPIKE_HISTORY        = 12 // buckets averaged to judge the current one
+	SPIKE_FACTOR         = 3.0
+	SPIKE_MIN_REQUESTS   = 20
+)
+
+const SPIKE_BUCKET = 5 * time.Minute
+
+// which detectors run, by name; set via the config file
+var detectorsEnabled = map[string]bool{
+	"brute-force":  true,
+	"scanning":     true,
+	"spike":        true,
+	"busy-period":  true,
+	"failing-path": true,
+}
+
+type followAlert struct {
+	at       time.Time
+	detector string // brute-force, scanning, spike
+	subject  string // ip or time bucket
+	detail   string
+}
+
+// followDetectors watch the incoming stream; each detector trips once per
+// subject and re-arms when its condition clears
+type followDetectors struct {
+	failedLogins map[string][]time.Time          // recent failed logins by IP
+	errorPaths   map[string]map[string]time.Time // most recent error by path, by IP
+	bucket       time.Time                       // current spike bucket
+	bucketCount  int
+	history      []int // completed bucket counts, oldest first
+	tripped      map[string]bool
+	alerts       []followAlert
+	parseErrors  int
+	quiet        bool                    // collect alerts without announcing them
+	notify       func(alert followAlert) // also told of each alert as it trips
+}
+
+func newFollowDetectors() *followDetectors {
+	return &followDetectors{
+		failedLogins: make(map[string][]time.Time),
+		errorPaths:   make(map[string]map[string]time.Time),
+		history:      make([]int, 0, SPIKE_HISTORY),
+		tripped:      make(map[string]bool),
+		alerts:       make([]followAlert, 0),
+	}
+}
+
+// trip records (and announces) an alert unless the detector already fired for the subject
+func (d *followDetectors) trip(at time.Time, detector string, subject string, detail string) {
+	key := detector + "|" + subject
+	if d.tripped[key] {
+		return
+	}
+	d.tripped[key] = true
+
+	alert := followAlert{at, detector, subject, detail}
+	d.alerts = append(d.alerts, alert)
+	if d.notify != nil {
+		d.notify(alert)
+	}
+
+	if d.quiet {
+		return
+	}
+
+	fmt.Fprintf(statusOut, "ALERT %s %-12s %-15s %s\n", at.Format("2006-01-02T15:04:05"), detector, subject, detail)
+}
+
+func (d *followDetectors) rearm(detector string, subject string) {
+	delete(d.tripped, detector+"|"+subject)
+}
+
+// observe runs every detector over one newly stored item
+func (d *followDetectors) observe(item networkDataItem) {
+
+	// brute-force: repeated failed logins from one IP within the window
+	if detectorsEnabled["brute-force"] && isFailedLogin(item) {
+		recent := append(d.failedLogins[item.ipAddr], item.timestamp)
+		recent = withinWindow(recent, item.timestamp, BRUTE_FORCE_WINDOW)
+		d.failedLogins[item.ipAddr] = recent
+
+		if len(recent) >= BRUTE_FORCE_FAILURES {
+			d.trip(item.timestamp, "brute-force", item.ipAddr,
+				fmt.Sprintf("%d failed logins within %s", len(recent), BRUTE_FORCE_WINDOW))
+		} else {
+			d.rearm("brute-force", item.ipAddr)
+		}
+	} else if isLoginPath(item.path) {
+		d.failedLogins[item.ipAddr] = nil
+		d.rearm("brute-force", item.ipAddr)
+	}
+
+	// scanning: one IP drawing errors from many distinct paths within the window
+	if detectorsEnabled["scanning"] && item.statusCode/100 == 4 {
+		paths, ok := d.errorPaths[item.ipAddr]
+		if !ok {
+			paths = make(map[string]time.Time)
+			d.errorPaths[item.ipAddr] = paths
+		}
+		paths[item.path] = item.timestamp
+		for path, seen := range paths {
+			if item.timestamp.Sub(seen) > SCAN_WINDOW {
+				delete(paths, path)
+			}
+		}
+
+		if len(paths) >= SCAN_DISTINCT_PATHS {
+			d.trip(item.timestamp, "scanning", item.ipAddr,
+				fmt.Sprintf("errors from %d distinct paths within %s", len(paths), SCAN_WINDOW))
+		} else {
+			d.rearm("scanning", item.ipAddr)
+		}
+	}
+
+	// spike: the current bucket well above the average of the ones before it
+	bucket := item.timestamp.Truncate(SPIKE_BUCKET)
+	if d.bucket.IsZero() {
+		d.bucket = bucket
+	}
+	if bucket.After(d.bucket) {
+		d.history = append(d.history, d.bucketCount)
+		// quiet buckets in between count as empty
+		for skipped := d.bucket.Add(SPIKE_BUCKET); skipped.Before(bucket) && len(d.history) <= SPIKE_HISTORY; skipped = skipped.Add(SPIKE_BUCKET) {
+			d.history = append(d.history, 0)
+		}
+		if len(d.history) > SPIKE_HISTORY {
+			d.history = d.history[len(d.history)-SPIKE_HISTORY:]
+		}
+		d.bucket = bucket
+		d.bucketCount = 0
+	}
+	if !bucket.Before(d.bucket) {
+		d.bucketCount++
+
+		average := 0.0
+		for _, count := range d.history {
+			average += float64(count)
+		}
+		if len(d.history) > 0 {
+			average /= float64(len(d.history))
+		}
+
+		if detectorsEnabled["spike"] && d.bucketCount >= SPIKE_MIN_REQUESTS && float64(d.bucketCount) >= SPIKE_FACTOR*average {
+			d.trip(item.timestamp, "spike", d.bucket.Format("2006-01-02T15:04"),
+				fmt.Sprintf("%d requests in %s vs %.1f average", d.bucketCount, SPIKE_BUCKET, average))
+		}
+	}
+}
+
+// detectAlerts replays the data set through the stream detectors, quietly,
+// so a one-shot analysis gets the same alerts follow mode would have raised
+func detectAlerts() []followAlert {
+	detectors := newFollowDetectors()
+	detectors.quiet = true
+	for _, item := range networkData {
+		detectors.observe(item)
+	}
+	return detectors.alerts
+}
+
+// withinWindow drops the timestamps older than window before latest
+func withinWindow(timestamps []time.Time, latest time.Time, window time.Duration) []time.Time {
+	keep := 0
+	for keep < len(timestamps) && latest.Sub(timestamps[keep]) > window {
+		keep++
+	}
+	return timestamps[keep:]
+}
+
+// logFollower reads complete lines appended to a log, riding out rotation and truncation like `tail -F`
+type logFollower struct {
+	fileSpec string
+	file     *os.File
+	offset   int64
+	pending  []byte // an incomplete trailing line
+	lineNum  int
+	interner stringInterner
+}
+
+// open (re)opens the log from the start; false while it doesn't exist
+func (f *logFollower) open() bool {
+	file, err := os.Open(f.fileSpec)
+	if err != nil {
+		return false
+	}
+	if f.file != nil {
+		f.file.Close()
+	}
+	f.file = file
+	f.offset = 0
+	f.pending = nil
+	f.lineNum = 0
+	return true
+}
+
+// poll reads whatever is new and hands each complete line to consume
+func (f *logFollower) poll(consume func(raw []byte, lineNum int)) {
+
+	if f.file == nil {
+		if !f.open() {
+			return
+		}
+		fmt.Fprintln(statusOut, "Following "+f.fileSpec)
+	}
+
+	f.drain(consume)
+
+	current, err := f.file.Stat()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	latest, err := os.Stat(f.fileSpec)
+
+	if err != nil || !os.SameFile(current, latest) {
+		// rotated (or moved away): what was written to the old file is already drained
+		if f.open() {
+			fmt.Fprintln(statusOut, "Log rotated, following new "+f.fileSpec)
+			f.drain(consume)
+		}
+	} else if latest.Size() < f.offset {
+		fmt.Fprintln(statusOut, "Log truncated, following "+f.fileSpec+" from the start")
+		f.file.Seek(0, io.SeekStart)
+		f.offset = 0
+		f.pending = nil
+		f.lineNum = 0
+		f.drain(consume)
+	}
+}
+
+func (f *logFollower) drain(consume func(raw []byte, lineNum int)) {
+	buffer := make([]byte, 64*1024)
+	for {
+		n, err := f.file.Read(buffer)
+		if n > 0 {
+			f.offset += int64(n)
+			data := append(f.pending, buffer[:n]...)
+			for {
+				eol := bytes.IndexByte(data, '\n')
+				if eol < 0 {
+					break
+				}
+				f.lineNum++
+				consume(data[:eol], f.lineNum)
+				data = data[eol+1:]
+			}
+			f.pending = append([]byte(nil), data...)
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Println(err)
+			}
+			return
+		}
+	}
+}
+
+// followLogFile keeps ingesting the log as it grows, raising alerts as
+// detectors trip; interrupting it produces the full report over everything seen
+func followLogFile(fileSpec string) bool {
+
+	detectors := newFollowDetectors()
+	if webhooks != nil {
+		detectors.notify = func(alert followAlert) { webhooks.notify(fileSpec, alertFinding(alert)) }
+	}
+	follower := &logFollower{fileSpec: fileSpec, interner: make(stringInterner)}
+
+	ordering := newReorderBuffer(func(item networkDataItem) {
+		storeData(item.timestamp, item.ipAddr, item.method, item.path, item.statusCode)
+		detectors.observe(item)
+	})
+
+	metrics := &followMetrics{detectors: detectors, ordering: ordering}
+	if len(metricsAddr) > 0 && !metrics.serveMetrics(metricsAddr) {
+		return false
+	}
+
+	consumed := 0
+	consume := func(raw []byte, lineNum int) {
+		consumed++
+		item, ok, lineErr := parseLogLineBytes(raw, follower.interner)
+		if lineErr != nil {
+			// a live log shouldn't stop us; note it and move on
+			detectors.parseErrors++
+			lineErr.log(lineNum)
+			return
+		}
+		if ok {
+			ordering.push(item)
+		}
+	}
+
+	// once the log goes quiet there's nothing left to wait for
+	pollAndRelease := func() {
+		metrics.lock.Lock()
+		defer metrics.lock.Unlock()
+
+		consumed = 0
+		follower.poll(consume)
+		if consumed == 0 {
+			ordering.flush()
+		}
+	}
+
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupted)
+
+	ticker := time.NewTicker(FOLLOW_POLL)
+	defer ticker.Stop()
+
+	pollAndRelease()
+
+	for {
+		select {
+		case <-interrupted:
+			// the report's analysis rewrites the aggregates; /metrics waits it out
+			metrics.lock.Lock()
+			defer metrics.lock.Unlock()
+
+			ordering.flush()
+			// late events went straight to the data set, after the events they precede; the
+			// analyses (and first / last seen) need it in time order, as a batch run has it
+			orderNetworkData()
+			fmt.Fprintln(statusOut)
+			fmt.Fprintln(statusOut, "Followed", len(networkData), "data points,", len(detectors.alerts), "alerts,", detectors.parseErrors, "parse errors,",
+				ordering.late, "late events")
+			if len(networkData) == 0 {
+				log.Println("ERR: no traffic found to analyze")
+				fail(EXIT_NO_DATA)
+				return false
+			}
+			analyze()
+			return emitReport(fileSpec)
+		case <-ticker.C:
+			pollAndRelease()
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// followUntilInterrupted follows the log, appending each batch of lines once the
+// follower has gone quiet over the last, then interrupts it as ^C would; the
+// report goes to the returned JSON file, its STIX bundle to the other
+func followUntilInterrupted(t *testing.T, batches ...[]string) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	fileSpec := filepath.Join(dir, "follow.log")
+	reportSpec, bundleSpec := filepath.Join(dir, "report.json"), filepath.Join(dir, "bundle.json")
+
+	report, err := os.Create(reportSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer report.Close()
+	wasStdout, wasFormat, wasStix := os.Stdout, outputFormat, stixSpec
+	t.Cleanup(func() { os.Stdout, outputFormat, stixSpec = wasStdout, wasFormat, wasStix })
+	os.Stdout, outputFormat, stixSpec = report, "json", bundleSpec
+
+	var status bytes.Buffer
+	statusOut = &status
+	resetTrafficData()
+	if err := os.WriteFile(fileSpec, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	followed := make(chan bool)
+	go func() { followed <- followLogFile(fileSpec) }()
+
+	for _, batch := range batches {
+		file, err := os.OpenFile(fileSpec, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.WriteString(strings.Join(batch, "\n") + "\n")
+		file.Close()
+		time.Sleep(3 * FOLLOW_POLL) // read, then a quiet poll releases what's held
+	}
+
+	syscall.Kill(os.Getpid(), syscall.SIGINT)
+	select {
+	case ok := <-followed:
+		if !ok {
+			t.Fatal("follow failed:", status.String())
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("follow didn't stop on SIGINT")
+	}
+	if !strings.Contains(status.String(), " late events") || strings.Contains(status.String(), " 0 late events") {
+		t.Fatalf("expected late events:\n%s", status.String())
+	}
+	return reportSpec, bundleSpec
+}
+
+// a lull releases everything held, so an event stamped before it arrives late, after
+// events it precedes; the report must still see the log in time order
+func TestFollowReportsLateEventsInOrder(t *testing.T) {
+	at := func(clock string) string { return "2024-03-01T" + clock }
+	first := make([]string, 0)
+	for i := range 6 {
+		first = append(first, fmt.Sprintf("%s,10.0.0.1,GET /index.html,200", at(fmt.Sprintf("10:0%d:00", i))))
+		first = append(first, fmt.Sprintf("%s,10.0.0.5,POST /login,401", at(fmt.Sprintf("10:05:%02d", i*5))))
+	}
+	late := []string{
+		at("09:58:00") + ",10.0.0.5,GET /index.html,200",
+		at("09:59:00") + ",10.0.0.7,GET /about,200",
+	}
+	reportSpec, bundleSpec := followUntilInterrupted(t, first, late)
+
+	if !slices.IsSortedFunc(networkData, func(a networkDataItem, b networkDataItem) int { return a.timestamp.Compare(b.timestamp) }) {
+		t.Error("the data set isn't in time order")
+	}
+
+	report := decodeJson(t, reportSpec).(map[string]any)
+	if start := report["totals"].(map[string]any)["start"]; start != "2024-03-01T09:58:00Z" {
+		t.Errorf("totals start %v s/b the late event's", start)
+	}
+	found := false
+	for _, ip := range report["ips"].([]any) {
+		ip := ip.(map[string]any)
+		if ip["firstSeen"].(string) > ip["lastSeen"].(string) {
+			t.Errorf("%v first seen %v after last seen %v", ip["address"], ip["firstSeen"], ip["lastSeen"])
+		}
+		if ip["address"] == "10.0.0.5" {
+			found = true
+			if ip["firstSeen"] != "2024-03-01T09:58:00Z" || ip["lastSeen"] != "2024-03-01T10:05:25Z" {
+				t.Errorf("10.0.0.5 seen %v - %v, expected 09:58:00 - 10:05:25", ip["firstSeen"], ip["lastSeen"])
+			}
+		}
+	}
+	if !found {
+		t.Error("no 10.0.0.5 in the report")
+	}
+
+	// the flagged IP's objects were created when it was first seen, modified when last
+	text, err := os.ReadFile(bundleSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bundle struct{ Objects []map[string]any }
+	if err := json.Unmarshal(text, &bundle); err != nil {
+		t.Fatal(err)
+	}
+	indicators := 0
+	for _, object := range bundle.Objects {
+		created, modified := fmt.Sprint(object["created"]), fmt.Sprint(object["modified"])
+		if created > modified {
+			t.Errorf("%v modified %s before created %s", object["id"], modified, created)
+		}
+		if object["type"] == "indicator" {
+			indicators++
+		}
+	}
+	if indicators == 0 {
+		t.Error("10.0.0.5's brute-force wasn't flagged")
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// exit codes, so scripts and CI pipelines can act on the outcome
+const (
+	EXIT_OK           = 0 // analyzed, nothing at or over the gating thresholds
+	EXIT_GATE_FAILED  = 1 // findings at or over --fail-on, or a --max-* threshold exceeded
+	EXIT_USAGE        = 2 // bad arguments (also what the flag package exits with)
+	EXIT_INVALID_LOG  = 3 // the log couldn't be read, or isn't a traffic log
+	EXIT_NO_DATA      = 4 // the log held no traffic
+	EXIT_EXPORT_ERROR = 5 // the analysis ran but a report, export or delivery failed
+)
+
+// the process's exit code; the first failure recorded is the one reported
+var exitCode = EXIT_OK
+
+// fail records why the run failed, unless a more specific reason already was
+func fail(code int) {
+	if exitCode == EXIT_OK {
+		exitCode = code
+	}
+}
+
+// gates set via --fail-on and --max-*; negative thresholds are off
+var failOn = ""
+var maxFailedLogins = -1
+var maxFindings = -1
+var maxErrorRate = -1.0 // percent of all requests drawing 4xx / 5xx responses
+
+// checkGates reports each gating threshold the analysis breaches, and
+// records EXIT_GATE_FAILED if any was
+func checkGates(data reportData) bool {
+	breaches := make([]string, 0)
+
+	if len(failOn) > 0 {
+		at := 0
+		for _, finding := range data.Findings {
+			if severityRanks[finding.Severity] >= severityRanks[failOn] {
+				at++
+			}
+		}
+		if at > 0 {
+			breaches = append(breaches, fmt.Sprintf("%d findings at or above %s severity", at, failOn))
+		}
+	}
+
+	if maxFindings >= 0 && len(data.Findings) > maxFindings {
+		breaches = append(breaches, fmt.Sprintf("%d findings, over the maximum of %d", len(data.Findings), maxFindings))
+	}
+
+	if maxFailedLogins >= 0 {
+		for _, ip := range data.IPs {
+			if ip.FailedLogins > maxFailedLogins {
+				breaches = append(breaches, fmt.Sprintf("%s made %d failed logins, over the maximum of %d",
+					ip.Address, ip.FailedLogins, maxFailedLogins))
+			}
+		}
+	}
+
+	if maxErrorRate >= 0 && data.Totals.Requests > 0 {
+		errors := 0
+		for _, status := range data.StatusCodes {
+			if status.Status >= 400 {
+				errors += status.Count
+			}
+		}
+		rate := float64(errors) * 100 / float64(data.Totals.Requests)
+		if rate > maxErrorRate {
+			breaches = append(breaches, fmt.Sprintf("%s%% of requests drew errors, over the maximum of %s%%",
+				strconv.FormatFloat(rate, 'f', 1, 64), strconv.FormatFloat(maxErrorRate, 'f', -1, 64)))
+		}
+	}
+
+	for _, breach := range breaches {
+		fmt.Fprintln(os.Stderr, "GATE FAILED:", breach)
+	}
+	if len(breaches) > 0 {
+		fail(EXIT_GATE_FAILED)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// withGates sets the gates for the test, and starts it from a clean exit code
+func withGates(t *testing.T, severity string, failedLogins int, findingCount int, errorRate float64) {
+	was := []any{failOn, maxFailedLogins, maxFindings, maxErrorRate, exitCode}
+	t.Cleanup(func() {
+		failOn, maxFailedLogins, maxFindings = was[0].(string), was[1].(int), was[2].(int)
+		maxErrorRate, exitCode = was[3].(float64), was[4].(int)
+	})
+	failOn, maxFailedLogins, maxFindings, maxErrorRate, exitCode = severity, failedLogins, findingCount, errorRate, EXIT_OK
+}
+
+func TestFailKeepsTheFirstCode(t *testing.T) {
+	withGates(t, "", -1, -1, -1)
+	fail(EXIT_INVALID_LOG)
+	fail(EXIT_EXPORT_ERROR)
+	fail(EXIT_GATE_FAILED)
+	if exitCode != EXIT_INVALID_LOG {
+		t.Errorf("exit code %d s/b the first failure's, %d", exitCode, EXIT_INVALID_LOG)
+	}
+}
+
+func TestCheckGates(t *testing.T) {
+	// 2 findings, medium and low; 10.0.0.9 made 6 failed logins; 3 of 20 requests drew errors
+	data := reportData{
+		Totals:   reportTotals{Requests: 20},
+		Findings: []reportFinding{{Detector: "failing-path", Severity: "medium"}, {Detector: "spike", Severity: "low"}},
+		IPs:      []reportIP{{Address: "10.0.0.9", FailedLogins: 6}, {Address: "10.0.0.1", FailedLogins: 1}},
+		StatusCodes: []reportStatusCount{{Status: 200, Count: 17}, {Status: 302, Count: 0}, {Status: 401, Count: 2},
+			{Status: 503, Count: 1}},
+	}
+
+	cases := []struct {
+		name         string
+		failOn       string
+		failedLogins int
+		findings     int
+		errorRate    float64
+		pass         bool
+	}{
+		{"no gates", "", -1, -1, -1, true},
+		{"fail-on high, none that severe", "high", -1, -1, -1, true},
+		{"fail-on medium, one at it", "medium", -1, -1, -1, false},
+		{"fail-on low, both over it", "low", -1, -1, -1, false},
+		{"findings at the maximum", "", -1, 2, -1, true},
+		{"findings over the maximum", "", -1, 1, -1, false},
+		{"no findings allowed", "", -1, 0, -1, false},
+		{"failed logins at the maximum", "", 6, -1, -1, true},
+		{"failed logins over the maximum", "", 5, -1, -1, false},
+		{"error rate at the maximum", "", -1, -1, 15, true},
+		{"error rate over the maximum", "", -1, -1, 14.9, false},
+		{"one gate of several breached", "high", 10, 5, 14, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			withGates(t, c.failOn, c.failedLogins, c.findings, c.errorRate)
+			if pass := checkGates(data); pass != c.pass {
+				t.Errorf("checkGates %t, expected %t", pass, c.pass)
+			}
+			if want := map[bool]int{true: EXIT_OK, false: EXIT_GATE_FAILED}[c.pass]; exitCode != want {
+				t.Errorf("exit code %d, expected %d", exitCode, want)
+			}
+		})
+	}
+}
+
+// a gate breach doesn't mask an earlier, more specific failure
+func TestCheckGatesKeepsEarlierFailure(t *testing.T) {
+	withGates(t, "low", -1, -1, -1)
+	fail(EXIT_EXPORT_ERROR)
+	checkGates(reportData{Findings: []reportFinding{{Severity: "high"}}})
+	if exitCode != EXIT_EXPORT_ERROR {
+		t.Errorf("exit code %d s/b %d", exitCode, EXIT_EXPORT_ERROR)
+	}
+}
+
+func TestLoadLogFileExitCodes(t *testing.T) {
+	statusOut = io.Discard
+	dir := t.TempDir()
+	write := func(name string, content string) string {
+		fileSpec := filepath.Join(dir, name)
+		if err := os.WriteFile(fileSpec, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return fileSpec
+	}
+
+	cases := map[string]struct {
+		fileSpec string
+		code     int
+	}{
+		"traffic":     {write("ok.log", "2024-03-01T10:00:00,10.0.0.1,GET /,200\n"), EXIT_OK},
+		"missing":     {filepath.Join(dir, "missing.log"), EXIT_INVALID_LOG},
+		"not a log":   {write("junk.log", "hello, world\n"), EXIT_INVALID_LOG},
+		"bad status":  {write("status.log", "2024-03-01T10:00:00,10.0.0.1,GET /,OK\n"), EXIT_INVALID_LOG},
+		"empty":       {write("empty.log", ""), EXIT_NO_DATA},
+		"blank lines": {write("blank.log", "\n\n  \n"), EXIT_NO_DATA},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			resetTrafficData()
+			if code := loadLogFile(c.fileSpec); code != c.code {
+				t.Errorf("exit code %d, expected %d", code, c.code)
+			}
+		})
+	}
+}
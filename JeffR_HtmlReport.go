@@ -0,0 +1,100 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+)
+
+//go:embed JeffR_Report.html.tmpl
+var htmlReportTemplate string
+
+//go:embed JeffR_Report.css
+var htmlReportCss string
+
+//go:embed JeffR_Report.js
+var htmlReportJs string
+
+// reportFuncs are the helpers the report templates lean on
+var reportFuncs = template.FuncMap{
+	"css": func() template.CSS { return template.CSS(htmlReportCss) },
+	"chart": func(name string, data reportData) (template.HTML, error) {
+		switch name {
+		case "timeline":
+			return timelineChart(data), nil
+		case "heatmap":
+			return heatmapChart(data), nil
+		case "status":
+			return statusChart(data), nil
+		case "topips":
+			return topIPsChart(data), nil
+		}
+		return "", fmt.Errorf("unknown chart %q", name)
+	},
+	"js":  func() template.JS { return template.JS(htmlReportJs) },
+	"inc": func(i int) int { return i + 1 },
+	"stamp": func(t time.Time) string {
+		return t.Format("2006-01-02 15:04:05")
+	},
+	"seconds":      secondsString,
+	"bucket":       bucketLabel,
+	"minutes":      bucketMinutes,
+	"slop":         func() template.HTML { return template.HTML(bucketSlop()) },
+	"confidence":   confidencePercent,
+	"upper":        strings.ToUpper,
+	"severityRank": func(severity string) int { return severityRanks[severity] },
+	"weekdays": func() []string {
+		names := make([]string, 0, len(reportWeekdays))
+		for _, weekday := range reportWeekdays {
+			names = append(names, weekday.String())
+		}
+		return names
+	},
+	"weekdayCell": func(ip reportIP, weekday string) *reportWeekday {
+		for i := range ip.ByWeekday {
+			if ip.ByWeekday[i].Weekday == weekday {
+				return &ip.ByWeekday[i]
+			}
+		}
+		return nil
+	},
+	"pathCell": func(ip reportIP, path string) *reportPath {
+		for i := range ip.ByPath {
+			if ip.ByPath[i].Path == path {
+				return &ip.ByPath[i]
+			}
+		}
+		return nil
+	},
+	"methodLetters": func(path *reportPath) string {
+		letters := ""
+		for _, method := range path.Methods {
+			letters += methodAbbrev(method.Method)
+		}
+		return letters
+	},
+}
+
+var htmlReport = template.Must(template.New("report").Funcs(reportFuncs).Parse(htmlReportTemplate))
+
+// writeHtmlReport renders the self-contained (styles and scripts inline) HTML report;
+// html/template escapes everything taken from the log
+func writeHtmlReport(w io.Writer, data reportData) error {
+	return htmlReport.Execute(w, data)
+}
+
+// secondsString renders a number of seconds as a Go duration, e.g. 1h5m0s
+func secondsString(seconds float64) string {
+	return (time.Duration(seconds * float64(time.Second))).String()
+}
+
+// methodAbbrev is the single letter used for a method in the path tables
+func methodAbbrev(method string) string {
+	if strings.ToUpper(method) == "PUT" || strings.ToUpper(method) == "PATCH" {
+		return strings.ToUpper(method[1:2])
+	}
+	return strings.ToUpper(method[0:1])
+}
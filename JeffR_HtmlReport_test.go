@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// a client picks its path and method, so the report can't let either run as script
+func TestHtmlReportEscapesTheLog(t *testing.T) {
+	lines := []string{
+		"2024-03-01T10:00:00,10.0.0.1,GET /<script>alert(1)</script>,404",
+		"2024-03-01T10:00:01,10.0.0.1,<script>alert(2)</script> /index.html,200",
+		"2024-03-01T10:00:02,10.0.0.1,GET /\"><img/src=x/onerror=alert(3)>,404",
+		"2024-03-01T10:00:03,10.0.0.2,GET /index.html,200",
+	}
+	fileSpec := filepath.Join(t.TempDir(), "xss.log")
+	if err := os.WriteFile(fileSpec, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := writeHtmlReport(&out, analyzeSample(t, fileSpec)); err != nil {
+		t.Fatal(err)
+	}
+	html := out.String()
+
+	for _, raw := range []string{"<script>alert", "<SCRIPT>ALERT", "<SCRIPT>alert", "<img/src=x", `"><img`} {
+		if strings.Contains(html, raw) {
+			t.Errorf("the report has %s unescaped", raw)
+		}
+	}
+	// methods show only as their initials, so just the paths come through whole
+	for _, escaped := range []string{"/&lt;script&gt;alert(1)&lt;/script&gt;", "/&#34;&gt;&lt;img/src=x/onerror=alert(3)&gt;"} {
+		if !strings.Contains(html, escaped) {
+			t.Errorf("the report lacks %s", escaped)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// the version of the JSON report layout, see JeffR_ReportSchema.json;
+// bump the major version for anything that isn't a pure addition
+const REPORT_SCHEMA_VERSION = "1.3"
+
+// writeJsonReport emits the report model as indented JSON
+func writeJsonReport(w io.Writer, data reportData) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(data)
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// go test JeffR_*.go -update rewrites the golden reports from the current output
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// sampleLogs are the checked-in sample logs every golden test renders
+func sampleLogs(t testing.TB) []string {
+	logs, err := filepath.Glob("JeffR_Sample*.log")
+	if err != nil || len(logs) == 0 {
+		t.Fatal("no sample logs found:", err)
+	}
+	return logs
+}
+
+// analyzeSample ingests and analyzes a log from scratch, quietly, and builds its report
+func analyzeSample(t testing.TB, fileSpec string) reportData {
+	t.Helper()
+	statusOut = io.Discard
+	resetTrafficData()
+	if code := loadLogFile(fileSpec); code != EXIT_OK {
+		t.Fatalf("%s: load failed with exit code %d", fileSpec, code)
+	}
+	return buildReportData(filepath.Base(fileSpec))
+}
+
+func TestJsonReportGolden(t *testing.T) {
+	for _, fileSpec := range sampleLogs(t) {
+		t.Run(fileSpec, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := writeJsonReport(&out, analyzeSample(t, fileSpec)); err != nil {
+				t.Fatal(err)
+			}
+
+			golden := filepath.Join("testdata", strings.TrimSuffix(fileSpec, ".log")+".json")
+			if *updateGolden {
+				if err := os.WriteFile(golden, out.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err, "- run with -update to create it")
+			}
+			if !bytes.Equal(out.Bytes(), want) {
+				t.Errorf("report differs from %s (run with -update if intended):\n%s", golden, firstDifference(want, out.Bytes()))
+			}
+		})
+	}
+}
+
+func TestJsonReportGoldenMatchesSchema(t *testing.T) {
+	schema := loadReportSchema(t)
+	for _, fileSpec := range sampleLogs(t) {
+		golden := filepath.Join("testdata", strings.TrimSuffix(fileSpec, ".log")+".json")
+		report := decodeJson(t, golden)
+		for _, problem := range schema.validate(report) {
+			t.Errorf("%s: %s", golden, problem)
+		}
+		if version := report.(map[string]any)["schemaVersion"]; version != REPORT_SCHEMA_VERSION {
+			t.Errorf("%s: schemaVersion %v, expected %s", golden, version, REPORT_SCHEMA_VERSION)
+		}
+	}
+}
+
+// a 1.0 report, without what later minor versions added, must still validate
+func TestJsonReportSchemaAcceptsEarlierMinorVersions(t *testing.T) {
+	schema := loadReportSchema(t)
+	report := decodeJson(t, filepath.Join("testdata", "JeffR_SampleSpiky.json")).(map[string]any)
+
+	report["schemaVersion"] = "1.0"
+	for _, added := range []string{"summary", "timeline", "hourlyVolume", "statusCodes"} {
+		delete(report, added)
+	}
+	for _, finding := range report["findings"].([]any) {
+		for _, added := range []string{"severity", "confidence", "ip", "path", "from", "to", "period", "action", "evidence"} {
+			delete(finding.(map[string]any), added)
+		}
+	}
+
+	for _, problem := range schema.validate(report) {
+		t.Error(problem)
+	}
+}
+
+func TestReportSchemaRejects(t *testing.T) {
+	schema := loadReportSchema(t)
+	base := func() map[string]any {
+		return decodeJson(t, filepath.Join("testdata", "JeffR_SampleSpiky.json")).(map[string]any)
+	}
+
+	cases := map[string]func(report map[string]any){
+		"missing required": func(report map[string]any) { delete(report, "totals") },
+		"unknown property": func(report map[string]any) { report["extra"] = true },
+		"wrong type":       func(report map[string]any) { report["source"] = json.Number("1") },
+		"bad pattern":      func(report map[string]any) { report["schemaVersion"] = "2.0" },
+		"not an integer": func(report map[string]any) {
+			report["totals"].(map[string]any)["requests"] = json.Number("1.5")
+		},
+	}
+	for name, mutate := range cases {
+		report := base()
+		mutate(report)
+		if len(schema.validate(report)) == 0 {
+			t.Errorf("%s: schema accepted the report", name)
+		}
+	}
+}
+
+// firstDifference shows the first line where the reports part
+func firstDifference(want []byte, got []byte) string {
+	wantLines := strings.Split(string(want), "\n")
+	gotLines := strings.Split(string(got), "\n")
+	for i := 0; i < max(len(wantLines), len(gotLines)); i++ {
+		var w, g string
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if w != g {
+			return fmt.Sprintf("line %d:\n  want %s\n  got  %s", i+1, w, g)
+		}
+	}
+	return "(no difference)"
+}
+
+func decodeJson(t testing.TB, fileSpec string) any {
+	t.Helper()
+	text, err := os.ReadFile(fileSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(text))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		t.Fatalf("%s: %v", fileSpec, err)
+	}
+	return value
+}
+
+// jsonSchema checks a document against the subset of JSON Schema JeffR_ReportSchema.json
+// and the STIX schemas in testdata use; a keyword outside that subset is itself a problem,
+// so none is silently skipped
+type jsonSchema struct {
+	root      map[string]any
+	documents map[string]map[string]any // the schema files $refs can name, by $id
+}
+
+var jsonSchemaKeywords = []string{"$schema", "$id", "title", "description", "$defs", "$ref", "type", "properties",
+	"patternProperties", "required", "additionalProperties", "items", "enum", "pattern", "format", "minimum", "maximum",
+	"minLength", "maxLength", "minItems", "allOf", "anyOf", "oneOf"}
+
+func loadReportSchema(t testing.TB) jsonSchema {
+	t.Helper()
+	return jsonSchema{root: decodeJson(t, "JeffR_ReportSchema.json").(map[string]any)}
+}
+
+// loadJsonSchemas reads the schema files under dir, with their relative $refs resolved
+// against their $ids; validate checks against the one at rootSpec
+func loadJsonSchemas(t testing.TB, dir string, rootSpec string) jsonSchema {
+	t.Helper()
+	schema := jsonSchema{documents: make(map[string]map[string]any)}
+	err := filepath.WalkDir(dir, func(fileSpec string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || filepath.Ext(fileSpec) != ".json" {
+			return err
+		}
+		document := decodeJson(t, fileSpec).(map[string]any)
+		base, err := url.Parse(fmt.Sprint(document["$id"]))
+		if err != nil || !base.IsAbs() {
+			return fmt.Errorf("%s: $id %v s/b an absolute URL", fileSpec, document["$id"])
+		}
+		resolveSchemaRefs(document, base)
+		schema.documents[base.String()] = document
+		if rel, _ := filepath.Rel(dir, fileSpec); filepath.ToSlash(rel) == rootSpec {
+			schema.root = document
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schema.root == nil {
+		t.Fatalf("no %s in %s", rootSpec, dir)
+	}
+	return schema
+}
+
+func resolveSchemaRefs(value any, base *url.URL) {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, child := range value {
+			if ref, ok := child.(string); ok && key == "$ref" && !strings.HasPrefix(ref, "#") {
+				if resolved, err := base.Parse(ref); err == nil {
+					value[key] = resolved.String()
+				}
+			} else {
+				resolveSchemaRefs(child, base)
+			}
+		}
+	case []any:
+		for _, child := range value {
+			resolveSchemaRefs(child, base)
+		}
+	}
+}
+
+func (s jsonSchema) validate(value any) []string {
+	return s.check(s.root, value, "$")
+}
+
+func (s jsonSchema) check(schema map[string]any, value any, at string) []string {
+	problems := make([]string, 0)
+	for keyword := range schema {
+		if !slices.Contains(jsonSchemaKeywords, keyword) {
+			problems = append(problems, fmt.Sprintf("%s: schema keyword %s isn't supported by the test", at, keyword))
+		}
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		def, defined := s.documents[ref]
+		if name, found := strings.CutPrefix(ref, "#/$defs/"); found {
+			def, defined = s.root["$defs"].(map[string]any)[name].(map[string]any)
+		}
+		if !defined {
+			return append(problems, fmt.Sprintf("%s: unresolvable $ref %s", at, ref))
+		}
+		problems = append(problems, s.check(def, value, at)...)
+	}
+
+	for _, sub := range schemaList(schema["allOf"]) {
+		problems = append(problems, s.check(sub, value, at)...)
+	}
+	if anyOf := schemaList(schema["anyOf"]); len(anyOf) > 0 {
+		if passed, closest := s.passes(anyOf, value, at); passed == 0 {
+			problems = append(problems, fmt.Sprintf("%s: matches none of anyOf, nearest: %s", at, strings.Join(closest, "; ")))
+		}
+	}
+	if oneOf := schemaList(schema["oneOf"]); len(oneOf) > 0 {
+		if passed, closest := s.passes(oneOf, value, at); passed == 0 {
+			problems = append(problems, fmt.Sprintf("%s: matches none of oneOf, nearest: %s", at, strings.Join(closest, "; ")))
+		} else if passed > 1 {
+			problems = append(problems, fmt.Sprintf("%s: matches %d of oneOf, s/b just 1", at, passed))
+		}
+	}
+
+	if kind, ok := schema["type"].(string); ok && !jsonTypeMatches(kind, value) {
+		return append(problems, fmt.Sprintf("%s: %v s/b %s", at, value, kind))
+	}
+
+	if enum, ok := schema["enum"].([]any); ok && !slices.Contains(enum, value) {
+		problems = append(problems, fmt.Sprintf("%s: %v not one of %v", at, value, enum))
+	}
+
+	if text, ok := value.(string); ok {
+		if least, ok := schema["minLength"].(json.Number); ok {
+			if n, _ := least.Int64(); int64(utf8.RuneCountInString(text)) < n {
+				problems = append(problems, fmt.Sprintf("%s: %q shorter than %d", at, text, n))
+			}
+		}
+		if most, ok := schema["maxLength"].(json.Number); ok {
+			if n, _ := most.Int64(); int64(utf8.RuneCountInString(text)) > n {
+				problems = append(problems, fmt.Sprintf("%s: %q longer than %d", at, text, n))
+			}
+		}
+		if pattern, ok := schema["pattern"].(string); ok && !schemaPattern(pattern).MatchString(text) {
+			problems = append(problems, fmt.Sprintf("%s: %q doesn't match %s", at, text, pattern))
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, text); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %q isn't a date-time", at, text))
+			}
+		}
+	}
+
+	if number, ok := value.(json.Number); ok {
+		n, _ := number.Float64()
+		if minimum, ok := schema["minimum"].(json.Number); ok {
+			if least, _ := minimum.Float64(); n < least {
+				problems = append(problems, fmt.Sprintf("%s: %v under the minimum %v", at, n, least))
+			}
+		}
+		if maximum, ok := schema["maximum"].(json.Number); ok {
+			if most, _ := maximum.Float64(); n > most {
+				problems = append(problems, fmt.Sprintf("%s: %v over the maximum %v", at, n, most))
+			}
+		}
+	}
+
+	if object, ok := value.(map[string]any); ok {
+		properties, _ := schema["properties"].(map[string]any)
+		patternProperties, _ := schema["patternProperties"].(map[string]any)
+		for _, name := range asStrings(schema["required"]) {
+			if _, ok := object[name]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: missing required %s", at, name))
+			}
+		}
+		for name, property := range object {
+			propertySchema, known := properties[name].(map[string]any)
+			if known {
+				problems = append(problems, s.check(propertySchema, property, at+"."+name)...)
+			}
+			for pattern, patternSchema := range patternProperties {
+				if schemaPattern(pattern).MatchString(name) {
+					known = true
+					problems = append(problems, s.check(patternSchema.(map[string]any), property, at+"."+name)...)
+				}
+			}
+			if !known && schema["additionalProperties"] == false {
+				problems = append(problems, fmt.Sprintf("%s: unexpected property %s", at, name))
+			}
+		}
+	}
+
+	if array, ok := value.([]any); ok {
+		if least, ok := schema["minItems"].(json.Number); ok {
+			if n, _ := least.Int64(); int64(len(array)) < n {
+				problems = append(problems, fmt.Sprintf("%s: %d items, fewer than %d", at, len(array), n))
+			}
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range array {
+				problems = append(problems, s.check(items, item, fmt.Sprintf("%s[%d]", at, i))...)
+			}
+		}
+	}
+
+	return problems
+}
+
+// passes is how many of the schemas the value meets, and if none, what the one it came
+// closest to found
+func (s jsonSchema) passes(schemas []map[string]any, value any, at string) (int, []string) {
+	passed := 0
+	var closest []string
+	for _, sub := range schemas {
+		problems := s.check(sub, value, at)
+		if len(problems) == 0 {
+			passed++
+		} else if closest == nil || len(problems) < len(closest) {
+			closest = problems
+		}
+	}
+	if passed > 0 {
+		closest = nil
+	}
+	return passed, closest
+}
+
+// schemaPatterns are the schemas' patterns, compiled once; the STIX ones are checked many times over
+var schemaPatterns = make(map[string]*regexp.Regexp)
+
+func schemaPattern(pattern string) *regexp.Regexp {
+	compiled, ok := schemaPatterns[pattern]
+	if !ok {
+		compiled = regexp.MustCompile(pattern)
+		schemaPatterns[pattern] = compiled
+	}
+	return compiled
+}
+
+func schemaList(value any) []map[string]any {
+	list, _ := value.([]any)
+	schemas := make([]map[string]any, 0, len(list))
+	for _, item := range list {
+		if schema, ok := item.(map[string]any); ok {
+			schemas = append(schemas, schema)
+		}
+	}
+	return schemas
+}
+
+func jsonTypeMatches(kind string, value any) bool {
+	switch kind {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	case "number":
+		_, ok := value.(json.Number)
+		return ok
+	case "integer":
+		number, ok := value.(json.Number)
+		if !ok {
+			return false
+		}
+		_, err := number.Int64()
+		return err == nil
+	}
+	return false
+}
+
+func asStrings(value any) []string {
+	list, _ := value.([]any)
+	strs := make([]string, 0, len(list))
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			strs = append(strs, s)
+		}
+	}
+	return strs
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	_ "embed"
+	"html"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+)
+
+//go:embed JeffR_Report.md.tmpl
+var markdownReportTemplate string
+
+// MARKDOWN_TOP_IPS is how many IPs the summary table lists before the rest fold into <details>
+const MARKDOWN_TOP_IPS = 20
+
+// markdownEscaper backslash-escapes what Markdown (and GFM tables) would otherwise interpret
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "|", `\|`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"<", "&lt;", ">", "&gt;", "#", `\#`, "\r", " ", "\n", " ",
+)
+
+// markdownCode renders text as an inline code span: backslashes are literal there, so only
+// table pipes are escaped, and the fence outgrows any backtick run in the text
+func markdownCode(text string) string {
+	text = strings.NewReplacer("|", `\|`, "\r", " ", "\n", " ").Replace(text)
+	fence := "`"
+	for strings.Contains(text, fence) {
+		fence += "`"
+	}
+	if strings.HasPrefix(text, "`") || strings.HasSuffix(text, "`") {
+		text = " " + text + " "
+	}
+	return fence + text + fence
+}
+
+var markdownFuncs = template.FuncMap{
+	"md":   markdownEscaper.Replace,
+	"code": markdownCode,
+	"html": html.EscapeString,
+	"inc":  func(i int) int { return i + 1 },
+	"stamp": func(t time.Time) string {
+		return t.Format("2006-01-02 15:04:05")
+	},
+	"seconds":    secondsString,
+	"bucket":     bucketLabel,
+	"minutes":    bucketMinutes,
+	"slop":       bucketSlop,
+	"confidence": confidencePercent,
+	"upper":      strings.ToUpper,
+	"severity":   detectorSeverity,
+	"badge": func(severity string) string {
+		switch severity {
+		case "high":
+			return "🔴 **HIGH**"
+		case "medium":
+			return "🟠 **MEDIUM**"
+		case "none":
+			return "🟢 NONE"
+		}
+		return "🟡 LOW"
+	},
+	"topIPs": func(ips []reportIP) []reportIP {
+		return ips[:min(len(ips), MARKDOWN_TOP_IPS)]
+	},
+}
+
+var markdownReport = template.Must(template.New("report").Funcs(markdownFuncs).Parse(markdownReportTemplate))
+
+// writeMarkdownReport renders the report as GitHub-flavored Markdown for tickets and wikis;
+// per-IP detail folds into <details> blocks so long logs stay readable
+func writeMarkdownReport(w io.Writer, data reportData) error {
+	return markdownReport.Execute(w, data)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// -metrics: what a followed log has shown so far, in OpenMetrics text format for Prometheus / Grafana
+
+// set via -metrics, the address /metrics is served on while following
+var metricsAddr = ""
+
+const METRICS_TOP_IPS = 10 // IPs with the most failed logins given their own series
+
+var followDetectorNames = []string{"brute-force", "scanning", "spike"}
+
+// followMetrics reads the aggregates storeData maintains; the follow loop holds
+// the lock while it changes them
+type followMetrics struct {
+	lock      sync.Mutex
+	detectors *followDetectors
+	ordering  *reorderBuffer
+}
+
+// serveMetrics starts serving /metrics in the background; false if the address can't be had
+func (m *followMetrics) serveMetrics(addr string) bool {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Println("ERR:", err)
+		return false
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", m)
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			log.Println("ERR: metrics:", err)
+		}
+	}()
+
+	fmt.Fprintln(statusOut, "Serving metrics on", listener.Addr() /* the actual port for :0 */, "/metrics")
+	return true
+}
+
+func (m *followMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.write(w)
+}
+
+func (m *followMetrics) write(w io.Writer) {
+	family := func(name string, kind string, help string) {
+		fmt.Fprintf(w, "# TYPE %s %s\n# HELP %s %s\n", name, kind, name, help)
+	}
+
+	family("detective_requests", "counter", "Requests seen, by status class.")
+	classes := slices.Sorted(maps.Keys(requestsByStatusClass))
+	for _, class := range classes {
+		fmt.Fprintf(w, "detective_requests_total{class=\"%dxx\"} %d\n", class, requestsByStatusClass[class])
+	}
+
+	family("detective_failed_logins", "counter", fmt.Sprintf("Failed logins from the %d IPs with the most.", METRICS_TOP_IPS))
+	ips := sortedKeys(failedLoginsByIP)
+	slices.SortStableFunc(ips, func(a string, b string) int { return failedLoginsByIP[b] - failedLoginsByIP[a] })
+	for _, ip := range ips[:min(len(ips), METRICS_TOP_IPS)] {
+		if failedLoginsByIP[ip] > 0 {
+			fmt.Fprintf(w, "detective_failed_logins_total{ip=\"%s\"} %d\n", metricLabel(ip), failedLoginsByIP[ip])
+		}
+	}
+
+	active := make(map[string]int)
+	for key := range m.detectors.tripped {
+		detector, _, _ := strings.Cut(key, "|")
+		active[detector]++
+	}
+	raised := make(map[string]int)
+	for _, alert := range m.detectors.alerts {
+		raised[alert.detector]++
+	}
+
+	family("detective_active_alerts", "gauge", "Detectors currently tripped, by detector.")
+	for _, detector := range followDetectorNames {
+		fmt.Fprintf(w, "detective_active_alerts{detector=\"%s\"} %d\n", detector, active[detector])
+	}
+
+	family("detective_alerts", "counter", "Alerts raised, by detector.")
+	for _, detector := range followDetectorNames {
+		fmt.Fprintf(w, "detective_alerts_total{detector=\"%s\"} %d\n", detector, raised[detector])
+	}
+
+	family("detective_parse_errors", "counter", "Log lines that couldn't be parsed.")
+	fmt.Fprintf(w, "detective_parse_errors_total %d\n", m.detectors.parseErrors)
+
+	family("detective_late_events", "counter", "Events that arrived too late to be put in order.")
+	fmt.Fprintf(w, "detective_late_events_total %d\n", m.ordering.late)
+
+	family("detective_duplicates_dropped", "counter", "Exact duplicate events dropped.")
+	fmt.Fprintf(w, "detective_duplicates_dropped_total %d\n", duplicatesDropped)
+
+	family("detective_events", "gauge", "Events held for analysis.")
+	fmt.Fprintf(w, "detective_events %d\n", len(networkData))
+
+	if len(networkData) > 0 {
+		family("detective_last_event_timestamp_seconds", "gauge", "Time of the newest event.")
+		fmt.Fprintf(w, "detective_last_event_timestamp_seconds %d\n", maxTime.Unix())
+	}
+
+	fmt.Fprintln(w, "# EOF")
+}
+
+// metricLabel escapes a label value as OpenMetrics requires
+var metricLabel = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace
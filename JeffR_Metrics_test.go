@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// followedMetrics is what a follow would have gathered: two IPs beyond the top
+// METRICS_TOP_IPS, one that needs escaping, two brute-force alerts still tripped
+func followedMetrics() *followMetrics {
+	resetTrafficData()
+	requestsByStatusClass[2], requestsByStatusClass[4], requestsByStatusClass[5] = 5, 12, 1
+	for i := 1; i <= METRICS_TOP_IPS+1; i++ {
+		failedLoginsByIP[fmt.Sprint("10.0.0.", i)] = i
+	}
+	failedLoginsByIP["a\"b\\c\nd"] = 20
+	failedLoginsByIP["10.0.0.99"] = 0
+	duplicatesDropped = 4
+	at := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	networkData = make([]networkDataItem, 3)
+	maxTime = at
+
+	detectors := newFollowDetectors()
+	detectors.tripped["brute-force|10.0.0.9"] = true
+	detectors.tripped["brute-force|10.0.0.8"] = true
+	detectors.tripped["spike|"+at.String()] = true
+	for _, detector := range []string{"brute-force", "brute-force", "brute-force", "spike"} {
+		detectors.alerts = append(detectors.alerts, followAlert{at: at, detector: detector})
+	}
+	detectors.parseErrors = 2
+	ordering := newReorderBuffer(func(networkDataItem) {})
+	ordering.late = 1
+	return &followMetrics{detectors: detectors, ordering: ordering}
+}
+
+func TestMetricsExposition(t *testing.T) {
+	t.Cleanup(resetTrafficData)
+	var out bytes.Buffer
+	followedMetrics().write(&out)
+
+	want := strings.Join([]string{
+		"# TYPE detective_requests counter",
+		"# HELP detective_requests Requests seen, by status class.",
+		`detective_requests_total{class="2xx"} 5`,
+		`detective_requests_total{class="4xx"} 12`,
+		`detective_requests_total{class="5xx"} 1`,
+		"# TYPE detective_failed_logins counter",
+		"# HELP detective_failed_logins Failed logins from the 10 IPs with the most.",
+		`detective_failed_logins_total{ip="a\"b\\c\nd"} 20`,
+		`detective_failed_logins_total{ip="10.0.0.11"} 11`,
+		`detective_failed_logins_total{ip="10.0.0.10"} 10`,
+		`detective_failed_logins_total{ip="10.0.0.9"} 9`,
+		`detective_failed_logins_total{ip="10.0.0.8"} 8`,
+		`detective_failed_logins_total{ip="10.0.0.7"} 7`,
+		`detective_failed_logins_total{ip="10.0.0.6"} 6`,
+		`detective_failed_logins_total{ip="10.0.0.5"} 5`,
+		`detective_failed_logins_total{ip="10.0.0.4"} 4`,
+		`detective_failed_logins_total{ip="10.0.0.3"} 3`,
+		"# TYPE detective_active_alerts gauge",
+		"# HELP detective_active_alerts Detectors currently tripped, by detector.",
+		`detective_active_alerts{detector="brute-force"} 2`,
+		`detective_active_alerts{detector="scanning"} 0`,
+		`detective_active_alerts{detector="spike"} 1`,
+		"# TYPE detective_alerts counter",
+		"# HELP detective_alerts Alerts raised, by detector.",
+		`detective_alerts_total{detector="brute-force"} 3`,
+		`detective_alerts_total{detector="scanning"} 0`,
+		`detective_alerts_total{detector="spike"} 1`,
+		"# TYPE detective_parse_errors counter",
+		"# HELP detective_parse_errors Log lines that couldn't be parsed.",
+		"detective_parse_errors_total 2",
+		"# TYPE detective_late_events counter",
+		"# HELP detective_late_events Events that arrived too late to be put in order.",
+		"detective_late_events_total 1",
+		"# TYPE detective_duplicates_dropped counter",
+		"# HELP detective_duplicates_dropped Exact duplicate events dropped.",
+		"detective_duplicates_dropped_total 4",
+		"# TYPE detective_events gauge",
+		"# HELP detective_events Events held for analysis.",
+		"detective_events 3",
+		"# TYPE detective_last_event_timestamp_seconds gauge",
+		"# HELP detective_last_event_timestamp_seconds Time of the newest event.",
+		"detective_last_event_timestamp_seconds 1709287200",
+		"# EOF",
+	}, "\n") + "\n"
+	if got := out.String(); got != want {
+		t.Errorf("exposition differs at %s:\n%s", firstDifference([]byte(want), []byte(got)), got)
+	}
+}
+
+// before any event there's no newest one to give the time of, and the exposition still ends
+func TestMetricsExpositionEmpty(t *testing.T) {
+	t.Cleanup(resetTrafficData)
+	resetTrafficData()
+	var out bytes.Buffer
+	metrics := &followMetrics{detectors: newFollowDetectors(), ordering: newReorderBuffer(func(networkDataItem) {})}
+	metrics.write(&out)
+
+	got := out.String()
+	if strings.Contains(got, "detective_last_event_timestamp_seconds") || strings.Contains(got, "detective_failed_logins_total") {
+		t.Errorf("series for events that haven't happened:\n%s", got)
+	}
+	if !strings.HasSuffix(got, "\ndetective_events 0\n# EOF\n") || strings.Count(got, "# EOF") != 1 {
+		t.Errorf("exposition s/b end with the event count, then # EOF:\n%s", got)
+	}
+}
@@ -15,7 +15,23 @@ import (
 	"time"
 )
 
-const VERBOSE = false
+// analysis settings; set via the config file's input and analysis sections
+var (
+	VERBOSE          = false
+	MAX_SPIKES       = 10
+	MAX_GAPS         = 10
+	VOLUME_BUCKET    = 5 * time.Minute // traffic volume is tallied by weekday and time of day rounded to this
+	TIMESTAMP_LAYOUT = LOG_TIMESTAMP_LAYOUT
+	LOG_LOCATION     = time.UTC // the zone the log's timestamps are in
+)
+
+const LOG_TIMESTAMP_LAYOUT = "2006-01-02T15:04:05"
+
+// set via -output; text is the console report
+var outputFormat = "text"
+
+// progress / status messages; kept off stdout when it's carrying a machine-readable report
+var statusOut io.Writer = os.Stdout
 
 func main() {
 
@@ -30,22 +46,103 @@ func main() {
 		fmt.Println(arg)
 	*/
 
-	interactiveMode := false
-
-	if len(argsWithoutProg) == 0 {
-		interactiveMode = true
-	} else {
-
-	}
+	interactiveMode := len(argsWithoutProg) == 0
 
 	if interactiveMode {
-		fmt.Println("ERR parameter required")
-		advertiseHelpFlag()
-		// runConsole();
+		if !loadConfig("", nil) {
+			fail(EXIT_USAGE)
+			emitHelp()
+		} else {
+			runConsole("")
+		}
+	} else if argsWithoutProg[0] == "serve" {
+		if !runServer(argsWithoutProg[1:]) {
+			fail(EXIT_USAGE)
+			emitHelp()
+		}
 	} else {
 		helpPtr := flag.Bool("h", false, "")
+		serialPtr := flag.Bool("serial", false, "")
+		followPtr := flag.Bool("follow", false, "")
+		checkpointPtr := flag.String("checkpoint", "", "")
+		metricsPtr := flag.String("metrics", "", "")
+		outputPtr := flag.String("output", "text", "")
+		csvPtr := flag.String("csv", "", "")
+		blockListPtr := flag.String("blocklist", "", "")
+		allowListPtr := flag.String("allowlist", "", "")
+		blockThresholdPtr := flag.Int("blockthreshold", 1, "")
+		siemPtr := flag.String("siem", "", "")
+		siemToPtr := flag.String("siemto", "", "")
+		siemEventsPtr := flag.Bool("siemevents", false, "")
+		siemCAPtr := flag.String("siemca", "", "")
+		stixPtr := flag.String("stix", "", "")
+		flag.Func("webhook", "", addWebhook)
+		webhookSeverityPtr := flag.String("webhookseverity", "medium", "")
+		emailPtr := flag.String("email", "", "")
+		emailFromPtr := flag.String("emailfrom", "", "")
+		smtpPtr := flag.String("smtp", smtpAddr, "")
+		smtpTlsPtr := flag.String("smtptls", smtpTls, "")
+		smtpUserPtr := flag.String("smtpuser", "", "")
+		failOnPtr := flag.String("fail-on", "", "")
+		maxFailedLoginsPtr := flag.Int("max-failed-logins", -1, "")
+		maxFindingsPtr := flag.Int("max-findings", -1, "")
+		maxErrorRatePtr := flag.Float64("max-error-rate", -1, "")
+		previousPtr := flag.String("previous", "", "")
+		templatePtr := flag.String("template", "", "")
+		interactivePtr := flag.Bool("interactive", false, "")
+		consolePtr := flag.Bool("console", false, "")
+		configPtr := flag.String("config", "", "")
 		flag.Parse()
 
+		// before anything reads the flags, as the file fills in those not given
+		configOk := loadConfig(*configPtr, flag.CommandLine)
+
+		csvDir = *csvPtr
+
+		blockListDir = *blockListPtr
+		allowListSpec = *allowListPtr
+		blockThreshold = max(*blockThresholdPtr, 1)
+
+		siemFormat = strings.ToLower(*siemPtr)
+		siemDest = *siemToPtr
+		siemEvents = *siemEventsPtr
+		siemCA = *siemCAPtr
+		if len(siemFormat) > 0 && len(siemDest) == 0 {
+			siemDest = "-"
+		}
+
+		stixSpec = *stixPtr
+
+		webhookSeverity := strings.ToLower(*webhookSeverityPtr)
+		if webhooks != nil {
+			webhooks.minSeverity = webhookSeverity
+		}
+
+		emailTo = *emailPtr
+		emailFrom = *emailFromPtr
+		smtpAddr = *smtpPtr
+		smtpTls = strings.ToLower(*smtpTlsPtr)
+		smtpUser = *smtpUserPtr
+
+		failOn = strings.ToLower(*failOnPtr)
+		maxFailedLogins = *maxFailedLoginsPtr
+		maxFindings = *maxFindingsPtr
+		maxErrorRate = *maxErrorRatePtr
+
+		previousSpec = *previousPtr
+
+		outputFormat = strings.ToLower(*outputPtr)
+		templateSpec = *templatePtr
+		if outputFormat != "text" || len(templateSpec) > 0 {
+			statusOut = os.Stderr
+		}
+
+		checkpointSpec = *checkpointPtr
+
+		metricsAddr = *metricsPtr
+
+		serialIngest = *serialPtr
+
 		fileSpec := ""
 		if *helpPtr {
 			emitHelp()
@@ -55,17 +152,67 @@ func main() {
 				fileSpec = args[0]
 			}
 
-			if len(fileSpec) > 0 {
+			if configOk && len(configSpec) > 0 {
+				fmt.Fprintln(statusOut, "Settings from "+configSpec)
+			}
+
+			if !configOk {
+				fail(EXIT_USAGE)
+				emitHelp()
+			} else if !slices.Contains(outputFormats, outputFormat) {
+				log.Println("ERR: unknown output format", outputFormat, "- s/b one of", strings.Join(outputFormats, ", "))
+				fail(EXIT_USAGE)
+				emitHelp()
+			} else if _, ok := severityRanks[webhookSeverity]; !ok {
+				log.Println("ERR: unknown webhook severity", webhookSeverity, "- s/b low, medium or high")
+				fail(EXIT_USAGE)
+				emitHelp()
+			} else if _, ok := severityRanks[failOn]; len(failOn) > 0 && !ok {
+				log.Println("ERR: unknown --fail-on severity", failOn, "- s/b low, medium or high")
+				fail(EXIT_USAGE)
+				emitHelp()
+			} else if !slices.Contains(smtpTlsModes, smtpTls) {
+				log.Println("ERR: unknown SMTP TLS mode", smtpTls, "- s/b one of", strings.Join(smtpTlsModes, ", "))
+				fail(EXIT_USAGE)
+				emitHelp()
+			} else if len(metricsAddr) > 0 && !*followPtr {
+				log.Println("ERR: -metrics only applies with -follow")
+				fail(EXIT_USAGE)
+				emitHelp()
+			} else if len(previousSpec) > 0 && !loadPreviousReport(previousSpec) {
+				fail(EXIT_USAGE)
+				emitHelp()
+			} else if len(templateSpec) > 0 && !loadUserTemplate(templateSpec) {
+				fail(EXIT_USAGE)
+				emitHelp()
+			} else if *consolePtr {
+				runConsole(fileSpec)
+			} else if *interactivePtr && len(fileSpec) > 0 {
+				if code := loadLogFile(fileSpec); code != EXIT_OK {
+					fail(code)
+					emitHelp()
+				} else {
+					runDashboard()
+				}
+			} else if *followPtr && len(fileSpec) > 0 {
+				if !followLogFile(fileSpec) {
+					fail(EXIT_EXPORT_ERROR)
+					emitHelp()
+				}
+			} else if len(fileSpec) > 0 {
 				if !processLogFile(fileSpec) {
+					fail(EXIT_EXPORT_ERROR)
 					emitHelp()
 				}
 			} else {
+				fail(EXIT_USAGE)
 				emitHelp()
 			}
 		}
 
 	}
 
+	os.Exit(exitCode)
 }
 
 func advertiseHelpFlag() {
@@ -74,27 +221,197 @@ func advertiseHelpFlag() {
 
 func emitHelp() {
 	prog := filepath.Base(os.Args[0])
-	fmt.Println("Syntax: ", prog, " [-h|<trafficLogFileName>]")
+	fmt.Println("Syntax: ", prog, " [-h|[-config <settings.yaml>] [-serial|-follow [-metrics <host:port>]|-interactive|-console|-checkpoint <stateFileName>] [-output text|json|html|markdown|-template <layout.tmpl>] [-previous <report.json>] [-csv <dir>] [-blocklist <dir> [-allowlist <file>] [-blockthreshold <n>]] [-siem cef|leef|ecs [-siemto <file>|udp://|tcp://|tls://<host:port>] [-siemevents] [-siemca <ca.pem>]] [-stix <bundle.json>] [-webhook [slack=|teams=|json=]<url> ... [-webhookseverity low|medium|high]] [-email <to,...> [-emailfrom <from>] [-smtp <host:port>] [-smtptls auto|starttls|tls|none] [-smtpuser <user>]] [--fail-on low|medium|high] [--max-failed-logins <n>] [--max-findings <n>] [--max-error-rate <%>] <trafficLogFileName>]")
+	fmt.Println("        ", prog, " serve [-config <settings.yaml>] [-addr <host:port>] [-watch <dir>] [<trafficLogFileName> ...]")
 	fmt.Println("Analyzes a network traffic log and summarizes activity / identifies threats")
+	fmt.Println("  -config     read settings - log format, analysis, detectors, allowlist, outputs and gates - from the YAML file,")
+	fmt.Println("              see JeffR_Config.md; else from ./detective.yaml or <user config dir>/detective/config.yaml if found")
+	fmt.Println("  -serial     parse the log on a single thread with the original parser (default splits it across all CPUs)")
+	fmt.Println("  -follow     keep reading the log as it grows (like tail -F), alerting as detectors trip; ^C to report")
+	fmt.Println("  -metrics    with -follow, serve counters and gauges on http://<host:port>/metrics in OpenMetrics format")
+	fmt.Println("  -interactive browse the analysis in a terminal dashboard: sort, filter and drill into IPs")
+	fmt.Println("  -console    query the analysis at a prompt (help lists the commands); also what no arguments at all do")
+	fmt.Println("  -checkpoint resume from / save the analyzer state in the file, reading only lines appended since the last run")
+	fmt.Println("  -output     report format: text (default), json (see JeffR_ReportSchema.json), html or markdown")
+	fmt.Println("  -template   render the report with your own text/template (or html/template for .html layouts), see JeffR_Templates.md")
+	fmt.Println("  -previous   an earlier period's -output json report, for the executive summary to trend against")
+	fmt.Println("  -csv        also export the IP, path, weekday, spike and gap tables as CSV files into the directory")
+	fmt.Println("  -blocklist  also export the IPs detectors flagged as nftables, iptables, ipset, nginx deny and CIDR lists into the directory,")
+	fmt.Println("              aggregated into the fewest prefixes covering exactly them")
+	fmt.Println("  -allowlist  IPs / CIDRs (one per line) never to block")
+	fmt.Println("  -blockthreshold medium or high severity findings an IP needs before it's blocked (default 1)")
+	fmt.Println("  -siem       also emit each finding as an ArcSight CEF, QRadar LEEF or Elastic Common Schema JSON event")
+	fmt.Println("  -siemto     where to: a file (appended to), - for stdout (default), or a syslog collector over udp, tcp or tls")
+	fmt.Println("  -siemevents also emit each error response from a flagged IP")
+	fmt.Println("  -siemca     PEM CA certificates to trust for a tls:// collector")
+	fmt.Println("  -stix       also write the flagged IPs as a STIX 2.1 bundle of indicators, attack patterns, observed data and sightings")
+	fmt.Println("  -webhook    POST findings to the webhook (repeatable): Slack blocks, a Teams card, or JSON - signed with HMAC-SHA256")
+	fmt.Println("              in X-Detective-Signature when " + WEBHOOK_SECRET_ENV + " is set; as they trip with -follow, else at the end")
+	fmt.Println("  -webhookseverity the least severe finding to POST (default medium)")
+	fmt.Println("  -email      also mail the report to the addresses: a summary inline, the HTML and JSON reports attached")
+	fmt.Println("  -emailfrom  the sender (default detective@<this host>)")
+	fmt.Println("  -smtp       the mail server (default localhost:25)")
+	fmt.Println("  -smtptls    auto (STARTTLS when offered, the default), starttls (required), tls (implicit, e.g. :465) or none")
+	fmt.Println("  -smtpuser   authenticate as the user, with the password in " + SMTP_PASSWORD_ENV)
+	fmt.Println("Exits 0 when all is well, 1 when a gate fails, 2 for bad arguments, 3 when the log can't be read or parsed,")
+	fmt.Println("  4 when it holds no traffic, 5 when a report, export or delivery fails")
+	fmt.Println("serve shares analyses over HTTP (default -addr localhost:8080): a web UI plus JSON under /api/, of the logs named,")
+	fmt.Println("  uploaded, or (with -watch) appearing in the directory")
 }
 
 func processLogFile(fileSpec string) bool {
+	if code := loadLogFile(fileSpec); code != EXIT_OK {
+		fail(code)
+		return false
+	}
+	return emitReport(filepath.Base(fileSpec))
+}
+
+// loadLogFile ingests and analyzes the log, ready to report or browse; returns EXIT_OK,
+// or the exit code for why not - the caller decides whether that ends the process
+func loadLogFile(fileSpec string) int {
 
 	fileInfo, err := os.Stat(fileSpec)
 	if err != nil {
 		log.Println(err) // .Fatal or .Panic aborts processing
-		return false
+		return EXIT_INVALID_LOG
 	}
 
-	fmt.Println("Processing " + fileInfo.Name())
+	fmt.Fprintln(statusOut, "Processing "+fileInfo.Name())
 
-	file, err := os.Open(fileSpec)
-	if err != nil {
-		log.Println(err)
-		return false
+	lineNum := 0
+	dataLines := 0
+	ok := false
+
+	if len(checkpointSpec) > 0 {
+		lineNum, dataLines, ok = ingestFromCheckpoint(fileSpec)
+	} else if serialIngest {
+		file, err := os.Open(fileSpec)
+		if err != nil {
+			log.Println(err)
+			return EXIT_INVALID_LOG
+		}
+		defer file.Close()
+
+		lineNum, dataLines, ok = ingestSerial(file)
+	} else {
+		lineNum, dataLines, ok = ingestParallel(fileSpec)
+	}
+
+	if ok && len(checkpointSpec) == 0 {
+		orderNetworkData()
+	}
+
+	if !ok {
+		return EXIT_INVALID_LOG
+	}
+
+	fmt.Fprint(statusOut, "Processed ", lineNum, " lines of log input")
+
+	if dataLines != lineNum {
+		fmt.Fprint(statusOut, " with ", dataLines, " data points.")
 	}
 
-	reader := bufio.NewReader(file)
+	fmt.Fprintln(statusOut)
+
+	// n.b. resuming from a checkpoint may have history even when no new lines were read
+	if len(networkData) == 0 {
+		log.Println("ERR: no traffic found to analyze")
+		return EXIT_NO_DATA
+	}
+
+	analyze()
+
+	return EXIT_OK
+}
+
+// the report formats -output accepts
+var outputFormats = []string{"text", "json", "html", "markdown"}
+
+// emitReport renders the analysis in the chosen output format, plus any exports requested
+func emitReport(source string) bool {
+	data := buildReportData(source)
+
+	switch {
+	case userTemplate != nil:
+		if err := userTemplate.Execute(os.Stdout, data); err != nil {
+			log.Println("ERR: template", templateSpec, "failed:", err)
+			return false
+		}
+	case outputFormat == "json":
+		if err := writeJsonReport(os.Stdout, data); err != nil {
+			log.Println("ERR:", err)
+			return false
+		}
+	case outputFormat == "html":
+		if err := writeHtmlReport(os.Stdout, data); err != nil {
+			log.Println("ERR:", err)
+			return false
+		}
+	case outputFormat == "markdown":
+		if err := writeMarkdownReport(os.Stdout, data); err != nil {
+			log.Println("ERR:", err)
+			return false
+		}
+	default:
+		report()
+	}
+
+	if len(csvDir) > 0 {
+		if err := writeCsvExport(csvDir, data); err != nil {
+			log.Println("ERR: CSV export failed:", err)
+			return false
+		}
+		fmt.Fprintln(statusOut, "See CSV tables exported to", csvDir)
+	}
+
+	if len(blockListDir) > 0 {
+		if err := writeBlockLists(blockListDir, data); err != nil {
+			log.Println("ERR: block list export failed:", err)
+			return false
+		}
+		fmt.Fprintln(statusOut, "See block lists exported to", blockListDir)
+	}
+
+	if len(siemFormat) > 0 {
+		if err := writeSiemExport(siemFormat, siemDest, data); err != nil {
+			log.Println("ERR: SIEM export failed:", err)
+			return false
+		}
+	}
+
+	if len(stixSpec) > 0 {
+		if err := writeStixBundle(stixSpec, data); err != nil {
+			log.Println("ERR: STIX export failed:", err)
+			return false
+		}
+	}
+
+	if webhooks != nil {
+		for _, finding := range findings {
+			webhooks.notify(source, finding)
+		}
+		webhooks.drain()
+	}
+
+	if len(emailTo) > 0 {
+		if err := sendEmailDigest(data); err != nil {
+			log.Println("ERR: emailing the report failed:", err)
+			return false
+		}
+	}
+
+	// a breach fails the run but isn't a failure to report, so no help
+	checkGates(data)
+
+	return true
+}
+
+// ingestSerial is the original single-threaded line loop;
+// returns #lines read, #data lines stored and whether the log was valid
+func ingestSerial(input io.Reader) (int, int, bool) {
+
+	reader := bufio.NewReader(input)
 
 	lineNum := 0
 	dataLines := 0
@@ -114,60 +431,23 @@ func processLogFile(fileSpec string) bool {
 			}
 		} else if err != nil {
 			log.Println(err)
-			return false
+			return lineNum, dataLines, false
 		}
 
 		if len(line) > 0 {
-			fields := strings.FieldsFunc(line, func(r rune) bool {
-				if r == ',' {
-					return true
-				}
-				return false
-			})
-
-			if len(fields) != 4 {
-				log.Println("ERR: Invalid log format at line#", lineNum+1, ":", line)
-				log.Println("ERR: log line format s/b `<timestamp>,<ip>,<method> <path>,<status>`")
-				return false
-			}
-
-			parsedTime, err := time.Parse("2006-01-02T15:04:05", fields[0])
-
-			if err != nil {
-				log.Println("ERR:", err)
-				log.Println("ERR: Invalid timestamp at line#", lineNum+1, ":", fields[0])
-				log.Println("ERR: timestamp format s/b `<yyyy-mm-ddThh24:mm:ss>`")
-				return false
-			}
-
-			parsedIP := strings.TrimSpace(fields[1])
-
-			parsedMethodPath := strings.Fields(strings.TrimSpace(fields[2]))
-
-			if len(parsedMethodPath) != 2 {
-				log.Println("ERR: Invalid method+path at line#", lineNum+1, ":", fields[2])
-				log.Println("ERR: method+path format s/b `<(GET|PUT|POST|DELETE...)><space(s)><path>`")
-				return false
-			}
-
-			parsedMethod := strings.ToUpper(parsedMethodPath[0])
-			parsedPath := parsedMethodPath[1]
-
-			parsedStatus, err := strconv.Atoi(fields[3])
-
-			if err != nil {
-				log.Println("ERR: Invalid response status at line#", lineNum+1, ":", fields[3])
-				log.Println("ERR: response status format s/b `<100..599>`")
-				return false
+			item, lineErr := parseLogLine(line)
 
+			if lineErr != nil {
+				lineErr.log(lineNum + 1)
+				return lineNum, dataLines, false
 			}
 
 			// TODO consider valiating / normalizing other inputs
 
-			storeData(parsedTime, parsedIP, parsedMethod, parsedPath, parsedStatus)
+			storeData(item.timestamp, item.ipAddr, item.method, item.path, item.statusCode)
 
 			if VERBOSE {
-				fmt.Println("Processed", parsedTime, parsedIP, parsedMethod, parsedPath, parsedStatus)
+				fmt.Println("Processed", item.timestamp, item.ipAddr, item.method, item.path, item.statusCode)
 			}
 
 			dataLines++
@@ -181,23 +461,67 @@ func processLogFile(fileSpec string) bool {
 
 	}
 
-	fmt.Print("Processed ", lineNum, " lines of log input")
+	return lineNum, dataLines, true
+}
 
-	if dataLines != lineNum {
-		fmt.Print(" with ", dataLines, " data points.")
-	}
+// logLineError describes why a (trimmed, non-empty) log line was rejected;
+// the line number isn't known to the parser so it's supplied when logging
+type logLineError struct {
+	cause error  // underlying parse error, if any
+	what  string // the part of the line that was invalid
+	text  string // the offending text
+	hint  string // the expected format
+}
 
-	fmt.Println()
+func (e *logLineError) log(lineNum int) {
+	if e.cause != nil {
+		log.Println("ERR:", e.cause)
+	}
+	log.Println("ERR: Invalid "+e.what+" at line#", lineNum, ":", e.text)
+	log.Println("ERR: " + e.hint)
+}
 
-	if lineNum == 0 || dataLines == 0 {
-		log.Println("ERR: no traffic found to analyze")
+// parseLogLine splits a trimmed, non-empty log line into its data item
+func parseLogLine(line string) (networkDataItem, *logLineError) {
+	fields := strings.FieldsFunc(line, func(r rune) bool {
+		if r == ',' {
+			return true
+		}
 		return false
+	})
+
+	if len(fields) != 4 {
+		return networkDataItem{}, &logLineError{nil, "log format", line,
+			"log line format s/b `<timestamp>,<ip>,<method> <path>,<status>`"}
 	}
 
-	analyze()
-	report()
+	parsedTime, err := time.ParseInLocation(TIMESTAMP_LAYOUT, fields[0], LOG_LOCATION)
 
-	return true
+	if err != nil {
+		return networkDataItem{}, &logLineError{err, "timestamp", fields[0],
+			"timestamp format s/b `" + timestampHint() + "`"}
+	}
+
+	parsedIP := strings.TrimSpace(fields[1])
+
+	parsedMethodPath := strings.Fields(strings.TrimSpace(fields[2]))
+
+	if len(parsedMethodPath) != 2 {
+		return networkDataItem{}, &logLineError{nil, "method+path", fields[2],
+			"method+path format s/b `<(GET|PUT|POST|DELETE...)><space(s)><path>`"}
+	}
+
+	parsedMethod := strings.ToUpper(parsedMethodPath[0])
+	parsedPath := parsedMethodPath[1]
+
+	parsedStatus, err := strconv.Atoi(fields[3])
+
+	if err != nil {
+		return networkDataItem{}, &logLineError{nil, "response status", fields[3],
+			"response status format s/b `<100..599>`"}
+	}
+
+	return networkDataItem{parsedTime, parsedIP, parsedMethod, parsedPath, parsedStatus}, nil
 }
 
 type networkDataItem struct {
@@ -212,6 +536,9 @@ var networkData []networkDataItem
 var byIP map[string][]int = make(map[string][]int)
 var requestsByIP map[string]int = make(map[string]int)
 var failedLoginsByIP map[string]int = make(map[string]int)
+
+// requests by status class, i.e. status / 100
+var requestsByStatusClass map[int]int = make(map[int]int)
 var minTime time.Time
 var maxTime time.Time
 
@@ -252,8 +579,7 @@ func storeData(timestamp time.Time, ipAddr string, method string, path string, s
 		}
 	}
 
-	hours, minutes, seconds := timestamp.Round(time.Duration(5 * int(time.Minute))).Clock()
-	timeOfDay := time.Duration((hours*int(time.Hour) + minutes*int(time.Minute) + seconds*int(time.Second)))
+	timeOfDay := timeOfDayBucket(timestamp)
 	// YGBFKM
 	// timeOfDay, _ := time.ParseDuration("" + strconv.Itoa(hours) + "h" + strconv.Itoa(minutes) + "m" + strconv.Itoa(seconds) + "s")
 
@@ -276,13 +602,28 @@ func storeData(timestamp time.Time, ipAddr string, method string, path string, s
 
 	requestsByIP[ipAddr]++
 
-	if path == "/login" && isHttpError(statusCode) {
+	if isLoginPath(path) && isHttpError(statusCode) {
 		failedLoginsByIP[ipAddr]++
 	}
 
+	requestsByStatusClass[statusCode/100]++
+
 	///////////////////////////////
 
-	_, ok = trafficByIP[ipAddr]
+	tallyTraffic(trafficByIP, ipAddr, method, path, timestamp.Weekday(), timeOfDay, statusCode)
+
+}
+
+// timeOfDayBucket rounds the timestamp to its VOLUME_BUCKET interval within the day
+func timeOfDayBucket(timestamp time.Time) time.Duration {
+	hours, minutes, seconds := timestamp.Round(VOLUME_BUCKET).Clock()
+	return time.Duration((hours*int(time.Hour) + minutes*int(time.Minute) + seconds*int(time.Second)))
+}
+
+// tallyTraffic accumulates one request into the by path/method and by weekday results for its IP
+func tallyTraffic(trafficByIP map[string]trafficDetails, ipAddr string, method string, path string, weekday time.Weekday, timeOfDay time.Duration, statusCode int) {
+
+	_, ok := trafficByIP[ipAddr]
 
 	if !ok {
 		trafficByIP[ipAddr] = trafficDetails{make(map[string]map[string]results), make(map[time.Weekday]results)}
@@ -315,13 +656,13 @@ func storeData(timestamp time.Time, ipAddr string, method string, path string, s
 	}
 	trafficByIP[ipAddr].byPath[path][method] = resultsVal
 
-	_, ok = trafficByIP[ipAddr].byWeekday[timestamp.Weekday()]
+	_, ok = trafficByIP[ipAddr].byWeekday[weekday]
 
 	if !ok {
-		trafficByIP[ipAddr].byWeekday[timestamp.Weekday()] = results{0, 0, timeOfDay, timeOfDay, 0}
+		trafficByIP[ipAddr].byWeekday[weekday] = results{0, 0, timeOfDay, timeOfDay, 0}
 	}
 
-	resultsVal = trafficByIP[ipAddr].byWeekday[timestamp.Weekday()]
+	resultsVal = trafficByIP[ipAddr].byWeekday[weekday]
 	if isHttpSuccess(statusCode) {
 		resultsVal.succeeded++
 	} else {
@@ -333,10 +674,22 @@ func storeData(timestamp time.Time, ipAddr string, method string, path string, s
 	if timeOfDay > resultsVal.maxTOD {
 		resultsVal.maxTOD = timeOfDay
 	}
-	trafficByIP[ipAddr].byWeekday[timestamp.Weekday()] = resultsVal
+	trafficByIP[ipAddr].byWeekday[weekday] = resultsVal
 
 }
 
+// mergeResults combines the results of the same path/method or weekday from two partial data sets
+func mergeResults(a results, b results) results {
+	merged := results{a.succeeded + b.succeeded, a.failed + b.failed, a.minTOD, a.maxTOD, a.weight + b.weight}
+	if b.minTOD < merged.minTOD {
+		merged.minTOD = b.minTOD
+	}
+	if b.maxTOD > merged.maxTOD {
+		merged.maxTOD = b.maxTOD
+	}
+	return merged
+}
+
 var totalRequests = 0
 var totalFailedLogins = 0
 
@@ -369,17 +722,24 @@ type span struct {
 
 var activityGapsAbsolute []span = make([]span, 0)
 
+// resetAnalysis clears the results of a previous analyze() so it can be re-run over a grown data set
+func resetAnalysis() {
+	trafficDays = make(map[trafficVolumeKey]int)
+	activitySpikes = make([]spike, 0)
+	activityGapsCyclical = make([]cyclicalGap, 0)
+	activityGapsAbsolute = make([]span, 0)
+}
+
 func analyze() {
+	resetAnalysis()
+
 	totalRequests = len(networkData)
-	isFailedLogin := func(i networkDataItem) bool { return i.path == "/login" && isHttpError(i.statusCode) }
 	totalFailedLogins = Count(networkData, isFailedLogin)
 
 	// by IP analysis was done when storing
 
 	// find the spikes
-	// here, we go by Day of week and time of day rounded to 5 minute intervals
-
-	const MAX_SPIKES = 10
+	// here, we go by Day of week and time of day rounded to VOLUME_BUCKET intervals
 
 	dataSetSpan := maxTime.Sub(minTime)
 
@@ -454,16 +814,14 @@ func analyze() {
 	for i, timestamp := range timestamps {
 		inc := false
 
-		hours, minutes, seconds := timestamp.Round(time.Duration(5 * int(time.Minute))).Clock()
-		timeOfDay := time.Duration((hours*int(time.Hour) + minutes*int(time.Minute) + seconds*int(time.Second)))
+		timeOfDay := timeOfDayBucket(timestamp)
 
 		if i > 0 {
 			if !(prevTimestamp.Year() == timestamp.Year() && prevTimestamp.YearDay() == timestamp.YearDay()) {
 				// !sameDay(prevTimestamp,timestamp) {
 				inc = true
 			} else {
-				hours, minutes, seconds := prevTimestamp.Round(time.Duration(5 * int(time.Minute))).Clock()
-				prevTimeOfDay := time.Duration((hours*int(time.Hour) + minutes*int(time.Minute) + seconds*int(time.Second)))
+				prevTimeOfDay := timeOfDayBucket(prevTimestamp)
 				if timeOfDay != prevTimeOfDay {
 					inc = true
 				}
@@ -486,16 +844,16 @@ func analyze() {
 				if j < len(volumeKeys)-1 {
 					nextSpike := volumeKeys[j+1]
 
-					if nextSpike.weekday == startSpike.weekday && int(nextSpike.timeOfDay-startSpike.timeOfDay) == int(5*time.Minute) {
+					if nextSpike.weekday == startSpike.weekday && nextSpike.timeOfDay-startSpike.timeOfDay == VOLUME_BUCKET {
 						continue
 					}
 
-					if int(nextSpike.weekday) == (int(startSpike.weekday)+1)%7 && toClock(nextSpike.timeOfDay) == "00:00:00" && toClock(startSpike.timeOfDay) == "23:55:00" {
+					if int(nextSpike.weekday) == (int(startSpike.weekday)+1)%7 && toClock(nextSpike.timeOfDay) == "00:00:00" && startSpike.timeOfDay == 24*time.Hour-VOLUME_BUCKET {
 						continue
 					}
 				}
 
-				endSpike.timeOfDay = time.Duration(int(endSpike.timeOfDay.Seconds())*int(time.Second) + int(5*time.Minute) - int(1*time.Second))
+				endSpike.timeOfDay = time.Duration(int(endSpike.timeOfDay.Seconds())*int(time.Second) + int(VOLUME_BUCKET) - int(1*time.Second))
 			}
 			var spikeRequests int64 = 0
 			var trafficDayCount int64 = 0
@@ -520,7 +878,7 @@ func analyze() {
 			if i == j {
 				spikeDuration++
 			} else {
-				spikeDuration += 5 * 60
+				spikeDuration += int(VOLUME_BUCKET / time.Second)
 			}
 			// fmt.Println("spikeDuration:", spikeDuration)
 			spikeAverage := float64(spikeRequests) / float64(spikeDuration) / float64(trafficDayCount)
@@ -574,8 +932,7 @@ func analyze() {
 	}
 
 	// find the cyclical gaps in traffic
-	// here, we use the data "normalized" to weekday and rounded to 5m intervals
-	const MAX_GAPS = 10
+	// here, we use the data "normalized" to weekday and rounded to VOLUME_BUCKET intervals
 
 	var prev trafficVolumeKey
 	for i, curr := range volumeKeys {
@@ -596,13 +953,13 @@ func analyze() {
 			dummyCurr, _ := time.Parse("2006-01-02T15:04:05", fmt.Sprintf("2006-01-02T%s", toClock(curr.timeOfDay)))
 			dummyCurr = dummyCurr.Add(time.Duration(days * 24 * int(time.Hour)))
 
-			if dummyCurr.Sub(dummyPrev) > time.Duration(5*int(time.Minute)) {
+			if dummyCurr.Sub(dummyPrev) > VOLUME_BUCKET {
 				if VERBOSE {
 					fmt.Println("cyclicalGap- processing", toClock(cycleStart.timeOfDay), toClock(cycleEnd.timeOfDay))
 				}
 				// account for crossing midnight boundary in either direction
 				prevDayWas := dummyPrev.Day()
-				dummyPrev = dummyPrev.Add(time.Duration(5 * int(time.Minute)))
+				dummyPrev = dummyPrev.Add(VOLUME_BUCKET)
 				if dummyPrev.Day() > prevDayWas {
 					if cycleStart.weekday == time.Saturday {
 						cycleStart.weekday = time.Sunday
@@ -725,6 +1082,10 @@ func analyze() {
 
 	weightTrafficByIP()
 
+	detectorAlerts = detectAlerts()
+
+	findings = rankFindings()
+
 }
 
 func weightTrafficByIP() {
@@ -735,6 +1096,16 @@ func weightTrafficByIP() {
 
 	}
 
+	// weights accumulate below, so start from scratch in case we're re-analyzing
+	for _, ipAddr := range ipAddrs {
+		for path, methods := range trafficByIP[ipAddr].byPath {
+			for method, results := range methods {
+				results.weight = 0
+				trafficByIP[ipAddr].byPath[path][method] = results
+			}
+		}
+	}
+
 	for _, ipAddr := range ipAddrs {
 		ipDetails := trafficByIP[ipAddr]
 
@@ -788,15 +1159,33 @@ func Count[T any](ts []T, pred func(T) bool) int {
 	return matches
 }
 
+// set via the config file's analysis section: the paths that are logins, and the
+// statuses that count as failures (nil for anything but 2xx)
+var loginPaths = []string{"/login"}
+var failureStatuses map[int]bool
+
 func isHttpError(statusCode int) bool {
+	if failureStatuses != nil {
+		return failureStatuses[statusCode]
+	}
 	return statusCode/100 != 2
 }
 
+func isLoginPath(path string) bool {
+	return slices.Contains(loginPaths, path)
+}
+
+func isFailedLogin(item networkDataItem) bool {
+	return isLoginPath(item.path) && isHttpError(item.statusCode)
+}
+
 func isHttpSuccess(statusCode int) bool {
 	return !isHttpError(statusCode)
 }
 
 func report() {
+	out := detectTerminal(os.Stdout)
+
 	fmt.Println()
 	fmt.Println("========================")
 	fmt.Println("Network Traffic Analysis")
@@ -805,6 +1194,11 @@ func report() {
 	fmt.Println("Data spans", minTime, "to", maxTime)
 	fmt.Println("Total Requests:", totalRequests)
 	fmt.Println("Total Failed Logins:", totalFailedLogins)
+	fmt.Println("Duplicate Events Dropped:", duplicatesDropped)
+
+	reportExecutiveSummary(out)
+
+	reportFindings(out)
 
 	keys := make([]string, 0, len(byIP))
 	for key := range byIP {
@@ -828,26 +1222,73 @@ func report() {
 
 	})
 
+	// IPv6 addresses widen the IP column
+	ipWidth := columnWidth(15, keys...)
+
 	fmt.Println()
 	fmt.Println("Activity By IP")
 	fmt.Println("==============")
-	fmt.Println("IP                     #Requests  #Failed Logins")
-	fmt.Println("---------------  --------------- ---------------")
+	fmt.Println(padRight("IP", ipWidth) + "        #Requests  #Failed Logins")
+	fmt.Println(strings.Repeat("-", ipWidth) + "  --------------- ---------------")
 	for _, key := range keys {
-		fmt.Printf("%-15s  %15d %15d\n", key, requestsByIP[key], failedLoginsByIP[key])
+		failedLogins := failedLoginsByIP[key]
+		fmt.Printf("%s  %15d %s\n", padRight(key, ipWidth), requestsByIP[key],
+			out.failures(int64(failedLogins), fmt.Sprintf("%15d", failedLogins)))
 	}
 
+	reportSpikes()
+	reportCyclicalGaps()
+	reportAbsoluteGaps()
+
+	reportTrafficByIP(out)
+
+}
+
+// reportFindings is the Comprehensive Threat Report: every analysis's findings, most
+// severe then most certain first, each with what to do about it
+func reportFindings(out terminal) {
+	fmt.Println()
+	fmt.Println("Comprehensive Threat Report")
+	fmt.Println("===========================")
+	if len(findings) == 0 {
+		fmt.Println("Nothing found.")
+		return
+	}
+
+	detectorWidth := columnWidth(len("Detector"))
+	subjectWidth := columnWidth(len("Subject"))
+	for _, finding := range findings {
+		detectorWidth = columnWidth(detectorWidth, finding.Detector)
+		subjectWidth = columnWidth(subjectWidth, finding.Subject.String())
+	}
+
+	fmt.Printf("%-8s  %-4s  %-19s  %s  %s  %s\n", "Severity", "Conf", "At", padRight("Detector", detectorWidth), padRight("Subject", subjectWidth), "Detail")
+	fmt.Printf("%s  %s  %s  %s  %s  %s\n", strings.Repeat("-", 8), strings.Repeat("-", 4), strings.Repeat("-", 19),
+		strings.Repeat("-", detectorWidth), strings.Repeat("-", subjectWidth), strings.Repeat("-", 6))
+	for _, finding := range findings {
+		fmt.Printf("%s  %4s  %s  %s  %s  %s\n", out.severity(finding.Severity, padRight(strings.ToUpper(finding.Severity), 8)),
+			confidencePercent(finding.Confidence), finding.At.Format("2006-01-02 15:04:05"), padRight(finding.Detector, detectorWidth),
+			padRight(finding.Subject.String(), subjectWidth), finding.Detail)
+		evidence := ""
+		if len(finding.Evidence) > 0 {
+			evidence = fmt.Sprintf(" (%d events of evidence)", len(finding.Evidence))
+		}
+		fmt.Printf("%s  -> %s%s\n", strings.Repeat(" ", 8), finding.Action, evidence)
+	}
+}
+
+func reportSpikes() {
 	fmt.Println()
 	fmt.Println("Top Activity Spikes**")
 	fmt.Println("=====================")
-	fmt.Println("Start (+/-2.5m)      End  (+/-2.5m)           ~Spans        #Rqs        Days  Rq/S/Day")
+	fmt.Printf("%-19s  %-19s      ~Spans        #Rqs        Days  Rq/S/Day\n", "Start ("+bucketSlop()+")", "End  ("+bucketSlop()+")")
 	fmt.Println("-------------------  -------------------  ----------  ----------  ----------  ----------")
 	for _, spike := range activitySpikes {
 		if spike.singleton {
 			fmt.Printf("%9s  %8s  %9s  %8s  %10s  %10d  %10d  %10f\n",
 				spike.start.weekday, toClock(spike.start.timeOfDay),
 				"", "*",
-				"(5m)", spike.requests, trafficDays[spike.start], spike.avgRqs)
+				"("+bucketLabel()+")", spike.requests, trafficDays[spike.start], spike.avgRqs)
 
 		} else {
 			fmt.Printf("%9s  %8s  %9s  %8s  %10s  %10d  %10d  %10f\n",
@@ -856,8 +1297,10 @@ func report() {
 				spike.spans, spike.requests, trafficDays[spike.start], spike.avgRqs)
 		}
 	}
-	fmt.Println("** data timestamps rounded to 5 minute intervals")
+	fmt.Println("** data timestamps rounded to " + bucketMinutes() + " minute intervals")
+}
 
+func reportCyclicalGaps() {
 	fmt.Println()
 	fmt.Println("Top Cyclical Activity Gaps**")
 	fmt.Println("============================")
@@ -868,9 +1311,11 @@ func report() {
 			cyclical.end.weekday, toClock(cyclical.end.timeOfDay),
 			cyclical.spans)
 	}
-	fmt.Println("** data timestamps rounded to 5 minute intervals")
+	fmt.Println("** data timestamps rounded to " + bucketMinutes() + " minute intervals")
 	fmt.Println("** longer-duration logs (minimum > 1 week) produce more predictive long-term cyclical gaps")
+}
 
+func reportAbsoluteGaps() {
 	fmt.Println()
 	fmt.Println("Top Absolute Activity Gaps")
 	fmt.Println("==========================")
@@ -879,24 +1324,9 @@ func report() {
 	for _, gap := range activityGapsAbsolute {
 		fmt.Printf("%s  %s  %s\n", gap.start, gap.end, gap.elapsed)
 	}
-
-	reportTrafficByIP()
-
 }
 
-func reportTrafficByIP() {
-	fmt.Println()
-	fmt.Println("Traffic By IP")
-	fmt.Println("=============")
-	fmt.Println("Sorted by overall Failure \"Density\" (Worst-to-Best)")
-	fmt.Println("#Succeeded/#Requests by Day of Week")
-	fmt.Printf("%15s", "")
-	dayNames := []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
-	for _, dayName := range dayNames {
-		fmt.Printf("  %15s", dayName)
-	}
-	fmt.Println()
-
+func reportTrafficByIP(out terminal) {
 	ipAddrs := make([]string, 0)
 	for ipAddr := range trafficByIP {
 		ipAddrs = append(ipAddrs, ipAddr)
@@ -935,13 +1365,36 @@ func reportTrafficByIP() {
 		return strings.Compare(a, b)
 	})
 
+	ipWidth := columnWidth(15, ipAddrs...)
+
 	weekdays := []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday}
+	dayNames := []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
+	dayWidth := columnWidth(len("Mon"))
 	for _, ipAddr := range ipAddrs {
-		fmt.Printf("%-15s", ipAddr)
 		for _, weekday := range weekdays {
 			byWeekday := trafficByIP[ipAddr].byWeekday[weekday]
-			stats := fmt.Sprintf("  %d/%d", byWeekday.succeeded, byWeekday.succeeded+byWeekday.failed)
-			fmt.Printf("  %15s", stats)
+			dayWidth = columnWidth(dayWidth, fmt.Sprintf("%d/%d", byWeekday.succeeded, byWeekday.succeeded+byWeekday.failed))
+		}
+	}
+
+	fmt.Println()
+	fmt.Println("Traffic By IP")
+	fmt.Println("=============")
+	fmt.Println("Sorted by overall Failure \"Density\" (Worst-to-Best)")
+	fmt.Println("#Succeeded/#Requests by Day of Week")
+	fmt.Print(padRight("", ipWidth))
+	for _, dayName := range dayNames {
+		fmt.Print("  " + padLeft(dayName, dayWidth))
+	}
+	fmt.Println()
+
+	for _, ipAddr := range ipAddrs {
+		fmt.Print(padRight(ipAddr, ipWidth))
+		for _, weekday := range weekdays {
+			byWeekday := trafficByIP[ipAddr].byWeekday[weekday]
+			requests := byWeekday.succeeded + byWeekday.failed
+			stats := padLeft(fmt.Sprintf("%d/%d", byWeekday.succeeded, requests), dayWidth)
+			fmt.Print("  " + out.ratio(byWeekday.succeeded, requests, stats))
 		}
 		fmt.Println()
 	}
@@ -1030,53 +1483,81 @@ func reportTrafficByIP() {
 
 	sort.Strings(pathNames)
 
-	fmt.Println()
-	fmt.Println("#Succeeded/#Requests By Path / Method(s)** Weight")
-	fmt.Printf("%15s", "")
-	for _, path := range pathNames {
-		fmt.Printf("  %15s", path)
+	// each path's cell is #succeeded/#requests over its method letters and weight
+	type pathCell struct {
+		stats     string
+		methods   string
+		weight    int64
+		ok        bool
+		succeeded int64
+		requests  int64
+	}
+	cells := make(map[string][]pathCell)
+	pathWidths := make([]int, len(pathNames))
+	for i, path := range pathNames {
+		pathWidths[i] = columnWidth(1, path)
 	}
-	fmt.Println()
-
 	for _, ipAddr := range ipAddrs {
 		trafficDetail := trafficByIP[ipAddr]
-		fmt.Printf("%-15s", ipAddr)
-		rqSummary := ""
-		mwSummary := ""
-		for _, path := range pathNames {
-			// fmt.Printf(" %10s", path)
+		row := make([]pathCell, len(pathNames))
+		for i, path := range pathNames {
 			ipPaths, ok := trafficDetail.byPath[path]
-			if ok {
-				methods := ""
-				var totSuccesses int64 = 0
-				var totFailures int64 = 0
-				var weight int64 = 0
-				for method, results := range ipPaths {
-					abbrev := "?"
-					if strings.ToUpper(method) == "PUT" || strings.ToUpper(method) == "PATCH" {
-						abbrev = strings.ToUpper(method[1:2])
-					} else {
-						abbrev = strings.ToUpper(method[0:1])
-					}
-					methods += abbrev
-
-					weight += results.weight
-					totSuccesses += results.succeeded
-					totFailures += results.failed
-				}
-				mwSummary += fmt.Sprintf("  %9s %5d", methods, weight)
-				stats := fmt.Sprintf("  %d/%d", totSuccesses, totSuccesses+totFailures)
-				rqSummary += fmt.Sprintf("  %15s", stats)
-			} else {
-				mwSummary += fmt.Sprintf("  %15s", "")
-				rqSummary += fmt.Sprintf("  %15s", "x")
+			if !ok {
+				row[i] = pathCell{stats: "x"}
+				continue
+			}
+			cell := pathCell{ok: true}
+			for method, results := range ipPaths {
+				cell.methods += methodAbbrev(method)
+				cell.weight += results.weight
+				cell.succeeded += results.succeeded
+				cell.requests += results.succeeded + results.failed
 			}
+			cell.stats = fmt.Sprintf("%d/%d", cell.succeeded, cell.requests)
+			pathWidths[i] = columnWidth(pathWidths[i], cell.stats, fmt.Sprintf("%s %d", cell.methods, cell.weight))
+			row[i] = cell
+		}
+		cells[ipAddr] = row
+	}
 
+	// page the matrix when it's wider than the terminal
+	pages := pageColumns(out.width, ipWidth, pathWidths)
+
+	fmt.Println()
+	fmt.Println("#Succeeded/#Requests By Path / Method(s)** Weight")
+	for pageNum, page := range pages {
+		if len(pages) > 1 {
+			if pageNum > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("(paths %d-%d of %d)\n", page[0]+1, page[len(page)-1]+1, len(pathNames))
 		}
 
-		fmt.Println(rqSummary)
-		fmt.Printf("%15s%s\n", "", mwSummary)
+		fmt.Print(padRight("", ipWidth))
+		for _, i := range page {
+			fmt.Print("  " + padLeft(pathNames[i], pathWidths[i]))
+		}
+		fmt.Println()
 
+		for _, ipAddr := range ipAddrs {
+			rqSummary := padRight(ipAddr, ipWidth)
+			mwSummary := padRight("", ipWidth)
+			for _, i := range page {
+				cell := cells[ipAddr][i]
+				rqSummary += "  " + out.ratio(cell.succeeded, cell.requests, padLeft(cell.stats, pathWidths[i]))
+				if cell.ok {
+					weight := fmt.Sprintf("%d", cell.weight)
+					if cell.weight < 0 {
+						weight = out.paint(weight, ansiRed)
+					}
+					mwSummary += "  " + padLeft("", pathWidths[i]-len(cell.methods)-1-len(fmt.Sprint(cell.weight))) + cell.methods + " " + weight
+				} else {
+					mwSummary += "  " + padLeft("", pathWidths[i])
+				}
+			}
+			fmt.Println(rqSummary)
+			fmt.Println(strings.TrimRight(mwSummary, " "))
+		}
 	}
 	fmt.Println("**Methods: G=GET, POST=P, DELETE=D, U=PUT, A=PATCH, H=HEAD, C=CONNECT, O=OPTIONS, T=TRACE")
 
@@ -1092,3 +1573,26 @@ func toClock(v time.Duration) string {
 
 	return fmt.Sprintf("%02d:%02d:%02d", h, m, s)
 }
+
+// bucketMinutes is VOLUME_BUCKET in minutes, e.g. 5
+func bucketMinutes() string {
+	return strconv.FormatFloat(VOLUME_BUCKET.Minutes(), 'f', -1, 64)
+}
+
+// bucketLabel is VOLUME_BUCKET as a short duration, e.g. 5m
+func bucketLabel() string {
+	return bucketMinutes() + "m"
+}
+
+// bucketSlop is how far a rounded time of day may be off, e.g. +/-2.5m
+func bucketSlop() string {
+	return "+/-" + strconv.FormatFloat((VOLUME_BUCKET/2).Minutes(), 'f', -1, 64) + "m"
+}
+
+// timestampHint describes the timestamp format expected of the log
+func timestampHint() string {
+	if TIMESTAMP_LAYOUT == LOG_TIMESTAMP_LAYOUT {
+		return "<yyyy-mm-ddThh24:mm:ss>"
+	}
+	return TIMESTAMP_LAYOUT
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// a spike over consecutive buckets spans all of them, whatever the bucket size
+func TestSpikeSpansWholeBuckets(t *testing.T) {
+	was := VOLUME_BUCKET
+	t.Cleanup(func() { VOLUME_BUCKET = was })
+
+	for _, bucket := range []time.Duration{5 * time.Minute, 15 * time.Minute, time.Hour} {
+		t.Run(bucket.String(), func(t *testing.T) {
+			VOLUME_BUCKET = bucket
+			start := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+			var lines string
+			for i := range 3 {
+				for _, at := range []time.Time{start, start.Add(bucket)} {
+					lines += fmt.Sprintf("%s,10.0.0.%d,GET /,200\n", at.Add(time.Duration(i)*time.Second).Format(LOG_TIMESTAMP_LAYOUT), i)
+				}
+			}
+			fileSpec := filepath.Join(t.TempDir(), "spike.log")
+			if err := os.WriteFile(fileSpec, []byte(lines), 0644); err != nil {
+				t.Fatal(err)
+			}
+			analyzeSample(t, fileSpec)
+
+			for _, spike := range activitySpikes {
+				if !spike.singleton {
+					if spike.spans != 2*bucket {
+						t.Errorf("spike %s - %s spans %v, expected %v", toClock(spike.start.timeOfDay), toClock(spike.end.timeOfDay), spike.spans, 2*bucket)
+					}
+					if spike.avgRqs != 6/(2*bucket).Seconds() {
+						t.Errorf("spike averages %f requests a second, expected %f", spike.avgRqs, 6/(2*bucket).Seconds())
+					}
+					return
+				}
+			}
+			t.Errorf("no spike over both buckets in %v", activitySpikes)
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"container/heap"
+	"slices"
+	"time"
+)
+
+// exact duplicate events dropped from the data set, over its whole history
+var duplicatesDropped = 0
+
+// a stream is released in timestamp order once this many events are held...
+const REORDER_BUFFER = 1024
+
+// ...or once an event is this far behind the newest one seen
+const REORDER_WINDOW = 30 * time.Second
+
+// how long a released stream event is remembered for duplicate detection
+const DUPLICATE_WINDOW = 5 * time.Minute
+
+// eventKey identifies an event exactly, for duplicate detection
+type eventKey struct {
+	unixTime   int64
+	ipAddr     string
+	method     string
+	path       string
+	statusCode int
+}
+
+func keyOf(item networkDataItem) eventKey {
+	return eventKey{item.timestamp.Unix(), item.ipAddr, item.method, item.path, item.statusCode}
+}
+
+// orderNetworkData is the ordering stage for whole files: it puts the data set
+// (and so each IP's sequence in byIP) in timestamp order, file order breaking ties,
+// and drops exact duplicate events
+func orderNetworkData() {
+
+	slices.SortStableFunc(networkData, func(a networkDataItem, b networkDataItem) int {
+		return a.timestamp.Compare(b.timestamp)
+	})
+
+	// duplicates share a timestamp, so only the current timestamp's events need remembering
+	seen := make(map[eventKey]bool)
+	var groupTime time.Time
+
+	ordered := networkData[:0]
+	for i, item := range networkData {
+		if i == 0 || !item.timestamp.Equal(groupTime) {
+			clear(seen)
+			groupTime = item.timestamp
+		}
+
+		key := keyOf(item)
+		if seen[key] {
+			unstoreDuplicate(item)
+			duplicatesDropped++
+			continue
+		}
+		seen[key] = true
+
+		ordered = append(ordered, item)
+	}
+	networkData = ordered
+
+	byIP = make(map[string][]int)
+	for i, item := range networkData {
+		byIP[item.ipAddr] = append(byIP[item.ipAddr], i)
+	}
+}
+
+// unstoreDuplicate backs a duplicate out of the aggregates storeData built;
+// the event it duplicates stays, so the min/max times of day are unaffected
+func unstoreDuplicate(item networkDataItem) {
+	timeOfDay := timeOfDayBucket(item.timestamp)
+	weekday := item.timestamp.Weekday()
+
+	trafficVolume[trafficVolumeKey{weekday, timeOfDay}]--
+
+	requestsByIP[item.ipAddr]--
+
+	if isFailedLogin(item) {
+		failedLoginsByIP[item.ipAddr]--
+	}
+
+	requestsByStatusClass[item.statusCode/100]--
+
+	details := trafficByIP[item.ipAddr]
+
+	resultsVal := details.byPath[item.path][item.method]
+	weekdayResults := details.byWeekday[weekday]
+	if isHttpSuccess(item.statusCode) {
+		resultsVal.succeeded--
+		weekdayResults.succeeded--
+	} else {
+		resultsVal.failed--
+		weekdayResults.failed--
+	}
+	details.byPath[item.path][item.method] = resultsVal
+	details.byWeekday[weekday] = weekdayResults
+}
+
+// reorderBuffer is the ordering stage for streams: it holds back a bounded number
+// of events and releases them in timestamp order, dropping exact duplicates.
+// Events arriving after later ones were already released can't be reordered; they're
+// released straight away and counted as late
+type reorderBuffer struct {
+	pending      reorderHeap
+	pendingKeys  map[eventKey]bool
+	released     []eventKey // recently released, oldest first
+	releasedKeys map[eventKey]bool
+	newest       time.Time
+	lastReleased time.Time
+	sequence     int
+	late         int
+	release      func(networkDataItem)
+}
+
+type reorderEntry struct {
+	item     networkDataItem
+	sequence int // arrival order, to keep ties stable
+}
+
+type reorderHeap []reorderEntry
+
+func (h reorderHeap) Len() int { return len(h) }
+func (h reorderHeap) Less(i, j int) bool {
+	if c := h[i].item.timestamp.Compare(h[j].item.timestamp); c != 0 {
+		return c < 0
+	}
+	return h[i].sequence < h[j].sequence
+}
+func (h reorderHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *reorderHeap) Push(x any)   { *h = append(*h, x.(reorderEntry)) }
+func (h *reorderHeap) Pop() any {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
+
+func newReorderBuffer(release func(networkDataItem)) *reorderBuffer {
+	return &reorderBuffer{
+		pending:      make(reorderHeap, 0),
+		pendingKeys:  make(map[eventKey]bool),
+		released:     make([]eventKey, 0),
+		releasedKeys: make(map[eventKey]bool),
+		release:      release,
+	}
+}
+
+func (b *reorderBuffer) push(item networkDataItem) {
+	key := keyOf(item)
+	if b.pendingKeys[key] || b.releasedKeys[key] {
+		duplicatesDropped++
+		return
+	}
+
+	if !b.lastReleased.IsZero() && item.timestamp.Before(b.lastReleased) {
+		b.late++
+		b.remember(key)
+		b.release(item)
+		return
+	}
+
+	b.sequence++
+	heap.Push(&b.pending, reorderEntry{item, b.sequence})
+	b.pendingKeys[key] = true
+
+	if item.timestamp.After(b.newest) {
+		b.newest = item.timestamp
+	}
+
+	for b.pending.Len() > REORDER_BUFFER || (b.pending.Len() > 0 && b.newest.Sub(b.pending[0].item.timestamp) > REORDER_WINDOW) {
+		b.releaseOldest()
+	}
+}
+
+// flush releases everything held, e.g. when the stream goes quiet or ends
+func (b *reorderBuffer) flush() {
+	for b.pending.Len() > 0 {
+		b.releaseOldest()
+	}
+}
+
+func (b *reorderBuffer) releaseOldest() {
+	entry := heap.Pop(&b.pending).(reorderEntry)
+	key := keyOf(entry.item)
+	delete(b.pendingKeys, key)
+
+	b.lastReleased = entry.item.timestamp
+	b.remember(key)
+	b.release(entry.item)
+}
+
+// remember keeps released events around for duplicate checks, for DUPLICATE_WINDOW
+func (b *reorderBuffer) remember(key eventKey) {
+	b.released = append(b.released, key)
+	b.releasedKeys[key] = true
+
+	cutoff := b.lastReleased.Add(-DUPLICATE_WINDOW).Unix()
+	expired := 0
+	for expired < len(b.released) && b.released[expired].unixTime < cutoff {
+		delete(b.releasedKeys, b.released[expired])
+		expired++
+	}
+	b.released = b.released[expired:]
+}
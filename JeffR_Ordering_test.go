@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// orderedIngest is the log ingested and put through the whole-file ordering stage
+func orderedIngest(t *testing.T, lines ...string) ingested {
+	t.Helper()
+	fileSpec := filepath.Join(t.TempDir(), "ordering.log")
+	if err := os.WriteFile(fileSpec, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result := ingestWith(t, fileSpec, true)
+	if !result.ok {
+		t.Fatal("ingest failed")
+	}
+	orderNetworkData()
+	return result.withData()
+}
+
+// the jumbled log with duplicates orders and aggregates as if it had been in order without them
+func TestOrderNetworkData(t *testing.T) {
+	jumbled := orderedIngest(t,
+		"2024-03-01T10:00:05,10.0.0.1,GET /b,200",
+		"2024-03-01T10:00:00,10.0.0.1,GET /a,200",
+		"2024-03-01T10:00:05,10.0.0.2,POST /login,401",
+		"2024-03-01T10:00:00,10.0.0.1,GET /a,200",      // duplicate, ahead in time
+		"2024-03-01T10:00:05,10.0.0.1,GET /b,200",      // duplicate, behind another event
+		"2024-03-01T10:00:05,10.0.0.2,POST /login,401", // duplicate failed login
+		"2024-03-01T10:00:05,10.0.0.2,POST /login,403", // differs only by status
+		"2024-03-01T10:00:06,10.0.0.1,GET /b,200",      // differs only by time
+		"2024-03-01T09:59:59,10.0.0.3,GET /a,500",
+		"2024-03-01T10:00:05,10.0.0.1,GET /b,200", // a third time
+	)
+	jumbledDropped := duplicatesDropped
+
+	ordered := orderedIngest(t,
+		"2024-03-01T09:59:59,10.0.0.3,GET /a,500",
+		"2024-03-01T10:00:00,10.0.0.1,GET /a,200",
+		"2024-03-01T10:00:05,10.0.0.1,GET /b,200",
+		"2024-03-01T10:00:05,10.0.0.2,POST /login,401",
+		"2024-03-01T10:00:05,10.0.0.2,POST /login,403",
+		"2024-03-01T10:00:06,10.0.0.1,GET /b,200",
+	)
+
+	if jumbledDropped != 4 || duplicatesDropped != 0 {
+		t.Errorf("dropped %d and %d duplicates, expected 4 and 0", jumbledDropped, duplicatesDropped)
+	}
+	jumbledFields, orderedFields := jumbled.fields(), ordered.fields()
+	for i, field := range orderedFields {
+		if field.name == "lines" || field.name == "data lines" {
+			continue
+		}
+		if !reflect.DeepEqual(field.value, jumbledFields[i].value) {
+			t.Errorf("%s differs:\n  in order %v\n  jumbled  %v", field.name, field.value, jumbledFields[i].value)
+		}
+	}
+}
+
+// testReorderBuffer is a buffer recording what it releases, as "<seconds after 10:00> <path>"
+func testReorderBuffer(t *testing.T) (*reorderBuffer, *[]string, func(seconds int, path string)) {
+	was := duplicatesDropped
+	t.Cleanup(func() { duplicatesDropped = was })
+	duplicatesDropped = 0
+
+	base := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	released := make([]string, 0)
+	buffer := newReorderBuffer(func(item networkDataItem) {
+		released = append(released, fmt.Sprintf("%d %s", int(item.timestamp.Sub(base).Seconds()), item.path))
+	})
+	push := func(seconds int, path string) {
+		buffer.push(networkDataItem{base.Add(time.Duration(seconds) * time.Second), "10.0.0.1", "GET", path, 200})
+	}
+	return buffer, &released, push
+}
+
+func TestReorderBufferOrders(t *testing.T) {
+	buffer, released, push := testReorderBuffer(t)
+	push(3, "/c")
+	push(1, "/a")
+	push(2, "/b")
+	push(1, "/a2") // ties keep their arrival order
+	if len(*released) != 0 {
+		t.Fatalf("released %v before the window passed", *released)
+	}
+	buffer.flush()
+	if want := []string{"1 /a", "1 /a2", "2 /b", "3 /c"}; !slices.Equal(*released, want) {
+		t.Errorf("released %v, expected %v", *released, want)
+	}
+}
+
+// an event is held until one more than REORDER_WINDOW newer arrives
+func TestReorderBufferReleasesPastTheWindow(t *testing.T) {
+	_, released, push := testReorderBuffer(t)
+	window := int(REORDER_WINDOW / time.Second)
+	push(0, "/a")
+	push(5, "/b")
+	push(window, "/c")
+	if len(*released) != 0 {
+		t.Fatalf("released %v within the window", *released)
+	}
+	push(window+1, "/d")
+	if want := []string{"0 /a"}; !slices.Equal(*released, want) {
+		t.Errorf("released %v, expected %v", *released, want)
+	}
+	push(window+10, "/e")
+	if want := []string{"0 /a", "5 /b"}; !slices.Equal(*released, want) {
+		t.Errorf("released %v, expected %v", *released, want)
+	}
+}
+
+// a burst within the window is held only up to REORDER_BUFFER events
+func TestReorderBufferReleasesPastCapacity(t *testing.T) {
+	buffer, released, push := testReorderBuffer(t)
+	for i := range REORDER_BUFFER {
+		push(0, fmt.Sprint("/", i))
+	}
+	if len(*released) != 0 || buffer.pending.Len() != REORDER_BUFFER {
+		t.Fatalf("released %d, holding %d, of a full buffer", len(*released), buffer.pending.Len())
+	}
+	push(1, "/over")
+	if want := []string{"0 /0"}; !slices.Equal(*released, want) || buffer.pending.Len() != REORDER_BUFFER {
+		t.Errorf("released %v, holding %d, expected %v and %d", *released, buffer.pending.Len(), want, REORDER_BUFFER)
+	}
+}
+
+// once later events are out, an earlier one can't go ahead of them: it's released
+// straight away, and counted
+func TestReorderBufferLateEvents(t *testing.T) {
+	buffer, released, push := testReorderBuffer(t)
+	push(10, "/a")
+	push(20, "/b")
+	buffer.flush()
+	push(15, "/late")
+	push(20, "/c") // not late, just tied with the last released
+	push(5, "/later")
+	if want := []string{"10 /a", "20 /b", "15 /late", "5 /later"}; !slices.Equal(*released, want) {
+		t.Errorf("released %v, expected %v", *released, want)
+	}
+	if buffer.late != 2 || buffer.pending.Len() != 1 {
+		t.Errorf("%d late, %d held, expected 2 and 1", buffer.late, buffer.pending.Len())
+	}
+}
+
+// duplicates are caught held or released, until DUPLICATE_WINDOW has passed them
+func TestReorderBufferDropsDuplicates(t *testing.T) {
+	buffer, released, push := testReorderBuffer(t)
+	push(0, "/a")
+	push(0, "/a") // held
+	push(1, "/b")
+	buffer.flush()
+	push(0, "/a") // released
+	push(1, "/b")
+	if duplicatesDropped != 3 {
+		t.Errorf("dropped %d duplicates, expected 3", duplicatesDropped)
+	}
+
+	expired := int(DUPLICATE_WINDOW/time.Second) + 2
+	push(expired, "/c")
+	buffer.flush()
+	push(0, "/a") // forgotten: late, but no longer a known duplicate
+	push(1, "/b")
+	if want := []string{"0 /a", "1 /b", fmt.Sprint(expired, " /c"), "0 /a", "1 /b"}; !slices.Equal(*released, want) {
+		t.Errorf("released %v, expected %v", *released, want)
+	}
+	if duplicatesDropped != 3 || buffer.late != 2 {
+		t.Errorf("dropped %d, %d late, expected 3 and 2", duplicatesDropped, buffer.late)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// set via -serial to fall back to the original single-threaded line loop
+var serialIngest = false
+
+// below this size the chunking overhead outweighs the parallelism; a var so the tests can chunk small logs
+var MIN_CHUNK_SIZE = 64 * 1024
+
+// partialAggregate is one worker's share of what storeData maintains globally;
+// byIP and the min/max times are derived from items when merging
+type partialAggregate struct {
+	items            []networkDataItem
+	trafficVolume    map[trafficVolumeKey]int
+	requestsByIP     map[string]int
+	failedLoginsByIP map[string]int
+	byStatusClass    map[int]int
+	trafficByIP      map[string]trafficDetails
+	lineNum          int // lines in the chunk
+	dataLines        int // non-blank lines in the chunk
+	err              *logLineError
+	errLine          int // chunk-relative line# of err
+	interner         stringInterner
+}
+
+func newPartialAggregate() *partialAggregate {
+	return &partialAggregate{
+		items:            make([]networkDataItem, 0),
+		trafficVolume:    make(map[trafficVolumeKey]int),
+		requestsByIP:     make(map[string]int),
+		failedLoginsByIP: make(map[string]int),
+		byStatusClass:    make(map[int]int),
+		trafficByIP:      make(map[string]trafficDetails),
+		interner:         make(stringInterner),
+	}
+}
+
+// store mirrors storeData for the worker's own maps
+func (p *partialAggregate) store(item networkDataItem) {
+	timeOfDay := timeOfDayBucket(item.timestamp)
+
+	p.trafficVolume[trafficVolumeKey{item.timestamp.Weekday(), timeOfDay}]++
+
+	p.items = append(p.items, item)
+
+	p.requestsByIP[item.ipAddr]++
+
+	if isFailedLogin(item) {
+		p.failedLoginsByIP[item.ipAddr]++
+	}
+
+	p.byStatusClass[item.statusCode/100]++
+
+	tallyTraffic(p.trafficByIP, item.ipAddr, item.method, item.path, item.timestamp.Weekday(), timeOfDay, item.statusCode)
+}
+
+// parse consumes every line of the chunk, stopping at the first invalid one;
+// an unterminated trailing line only counts if it has content, same as ingestSerial
+func (p *partialAggregate) parse(chunk []byte) {
+	for len(chunk) > 0 {
+		eol := bytes.IndexByte(chunk, '\n')
+		var raw []byte
+		if eol < 0 {
+			raw = chunk
+			chunk = nil
+		} else {
+			raw = chunk[:eol]
+			chunk = chunk[eol+1:]
+		}
+
+		item, ok, lineErr := parseLogLineBytes(raw, p.interner)
+
+		if eol < 0 && !ok && lineErr == nil {
+			break
+		}
+
+		if lineErr != nil {
+			p.err = lineErr
+			p.errLine = p.lineNum + 1
+			return
+		}
+
+		if ok {
+			p.store(item)
+			p.dataLines++
+		}
+
+		p.lineNum++
+	}
+}
+
+// splitChunks cuts data into (at most) n pieces, each ending just after a newline
+func splitChunks(data []byte, n int) [][]byte {
+	chunks := make([][]byte, 0, n)
+
+	if n > len(data)/MIN_CHUNK_SIZE {
+		n = max(1, len(data)/MIN_CHUNK_SIZE)
+	}
+
+	chunkSize := len(data) / n
+
+	for len(data) > 0 {
+		if len(chunks) == n-1 || chunkSize >= len(data) {
+			chunks = append(chunks, data)
+			break
+		}
+
+		end := chunkSize
+		eol := bytes.IndexByte(data[end:], '\n')
+		if eol < 0 {
+			chunks = append(chunks, data)
+			break
+		}
+		end += eol + 1
+
+		chunks = append(chunks, data[:end])
+		data = data[end:]
+	}
+
+	return chunks
+}
+
+// ingestParallel reads the whole log in one go, parses newline-aligned chunks
+// on GOMAXPROCS workers and merges their partial aggregates in file order,
+// so the result is identical to ingestSerial
+func ingestParallel(fileSpec string) (int, int, bool) {
+
+	data, err := os.ReadFile(fileSpec)
+	if err != nil {
+		log.Println(err)
+		return 0, 0, false
+	}
+
+	chunks := splitChunks(data, runtime.GOMAXPROCS(0))
+
+	if VERBOSE {
+		fmt.Println("ingestParallel- chunks:", len(chunks))
+	}
+
+	partials := make([]*partialAggregate, len(chunks))
+
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		partials[i] = newPartialAggregate()
+		wg.Add(1)
+		go func(p *partialAggregate, chunk []byte) {
+			defer wg.Done()
+			p.parse(chunk)
+		}(partials[i], chunk)
+	}
+	wg.Wait()
+
+	lineNum := 0
+	dataLines := 0
+
+	for _, p := range partials {
+		if p.err != nil {
+			p.err.log(lineNum + p.errLine)
+			return lineNum + p.errLine - 1, dataLines + p.dataLines, false
+		}
+
+		mergePartial(p)
+
+		lineNum += p.lineNum
+		dataLines += p.dataLines
+	}
+
+	return lineNum, dataLines, true
+}
+
+// mergePartial folds one worker's aggregate into the global data set
+func mergePartial(p *partialAggregate) {
+	offset := len(networkData)
+
+	for i, item := range p.items {
+		if len(networkData) == 0 && i == 0 {
+			minTime = item.timestamp
+			maxTime = item.timestamp
+		} else {
+			if item.timestamp.Before(minTime) {
+				minTime = item.timestamp
+			}
+			if item.timestamp.After(maxTime) {
+				maxTime = item.timestamp
+			}
+		}
+
+		indexes, ok := byIP[item.ipAddr]
+		if !ok {
+			indexes = make([]int, 0)
+		}
+		byIP[item.ipAddr] = append(indexes, offset+i)
+	}
+
+	networkData = append(networkData, p.items...)
+
+	for key, count := range p.trafficVolume {
+		trafficVolume[key] += count
+	}
+
+	for ipAddr, count := range p.requestsByIP {
+		requestsByIP[ipAddr] += count
+	}
+
+	for ipAddr, count := range p.failedLoginsByIP {
+		failedLoginsByIP[ipAddr] += count
+	}
+
+	for class, count := range p.byStatusClass {
+		requestsByStatusClass[class] += count
+	}
+
+	for ipAddr, details := range p.trafficByIP {
+		merged, ok := trafficByIP[ipAddr]
+		if !ok {
+			trafficByIP[ipAddr] = details
+			continue
+		}
+
+		for path, methods := range details.byPath {
+			if _, ok := merged.byPath[path]; !ok {
+				merged.byPath[path] = methods
+				continue
+			}
+			for method, resultsVal := range methods {
+				if existing, ok := merged.byPath[path][method]; ok {
+					resultsVal = mergeResults(existing, resultsVal)
+				}
+				merged.byPath[path][method] = resultsVal
+			}
+		}
+
+		for weekday, resultsVal := range details.byWeekday {
+			if existing, ok := merged.byWeekday[weekday]; ok {
+				resultsVal = mergeResults(existing, resultsVal)
+			}
+			merged.byWeekday[weekday] = resultsVal
+		}
+	}
+}
+
+// resetTrafficData clears everything storeData accumulates so a data set can be reloaded
+func resetTrafficData() {
+	networkData = nil
+	byIP = make(map[string][]int)
+	requestsByIP = make(map[string]int)
+	failedLoginsByIP = make(map[string]int)
+	requestsByStatusClass = make(map[int]int)
+	minTime = time.Time{}
+	maxTime = time.Time{}
+	trafficVolume = make(map[trafficVolumeKey]int)
+	trafficByIP = make(map[string]trafficDetails)
+	duplicatesDropped = 0
+	detectorAlerts = nil
+	findings = nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ingested is everything an ingest leaves behind, to compare one against another
+type ingested struct {
+	lines, dataLines int
+	ok               bool
+	data             []networkDataItem
+	byIP             map[string][]int
+	requests         map[string]int
+	failedLogins     map[string]int
+	statusClasses    map[int]int
+	minTime, maxTime time.Time
+	volume           map[trafficVolumeKey]int
+	traffic          map[string]trafficDetails
+}
+
+type ingestedField struct {
+	name  string
+	value any
+}
+
+func (i ingested) fields() []ingestedField {
+	return []ingestedField{{"lines", i.lines}, {"data lines", i.dataLines}, {"networkData", i.data}, {"byIP", i.byIP},
+		{"requestsByIP", i.requests}, {"failedLoginsByIP", i.failedLogins}, {"requestsByStatusClass", i.statusClasses},
+		{"minTime", i.minTime}, {"maxTime", i.maxTime}, {"trafficVolume", i.volume}, {"trafficByIP", i.traffic}}
+}
+
+func ingestWith(t testing.TB, fileSpec string, serial bool) ingested {
+	t.Helper()
+	resetTrafficData()
+	var result ingested
+	if serial {
+		file, err := os.Open(fileSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		result.lines, result.dataLines, result.ok = ingestSerial(file)
+	} else {
+		result.lines, result.dataLines, result.ok = ingestParallel(fileSpec)
+	}
+	return result.withData()
+}
+
+// withData is the result, with the data set as it now stands
+func (i ingested) withData() ingested {
+	i.data, i.byIP, i.requests, i.failedLogins = networkData, byIP, requestsByIP, failedLoginsByIP
+	i.statusClasses, i.minTime, i.maxTime = requestsByStatusClass, minTime, maxTime
+	i.volume, i.traffic = trafficVolume, trafficByIP
+	return i
+}
+
+// chunkSmallLogs has ingestParallel split even the sample logs across workers
+func chunkSmallLogs(t *testing.T, workers int) {
+	was, wasProcs := MIN_CHUNK_SIZE, runtime.GOMAXPROCS(workers)
+	MIN_CHUNK_SIZE = 1
+	t.Cleanup(func() { MIN_CHUNK_SIZE = was; runtime.GOMAXPROCS(wasProcs) })
+}
+
+// splitsMidLine is whether any of the workers' chunks would begin part way through a line
+func splitsMidLine(data []byte, workers int) bool {
+	chunkSize := len(data) / workers
+	for i := 1; i < workers; i++ {
+		if data[i*chunkSize-1] != '\n' {
+			return true
+		}
+	}
+	return false
+}
+
+func TestIngestParallelMatchesSerial(t *testing.T) {
+	const workers = 4
+	chunkSmallLogs(t, workers)
+	dir := t.TempDir()
+
+	// each sample log both with and without a trailing newline...
+	logs := make(map[string][]byte)
+	for _, fileSpec := range sampleLogs(t) {
+		content, err := os.ReadFile(fileSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trimmed := bytes.TrimRight(content, "\n")
+		logs[fileSpec] = trimmed
+		logs[fileSpec+"+newline"] = append(trimmed[:len(trimmed):len(trimmed)], '\n')
+	}
+
+	// ...plus lines of one length, so the chunks can't help but start mid-line
+	var even strings.Builder
+	for i := range 10 {
+		fmt.Fprintf(&even, "2024-04-01T00:00:0%d,192.168.0.1%d,POST /login,401\n", i, i)
+	}
+	logs["even"] = []byte(even.String())
+	logs["even-no-newline"] = []byte(strings.TrimSuffix(even.String(), "\n"))
+	if !splitsMidLine(logs["even"], workers) || !splitsMidLine(logs["even-no-newline"], workers) {
+		t.Fatal("the even log's chunks s/b split mid-line")
+	}
+
+	for name, content := range logs {
+		t.Run(name, func(t *testing.T) {
+			fileSpec := filepath.Join(dir, strings.ReplaceAll(name, "+", "-"))
+			if err := os.WriteFile(fileSpec, content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if chunks := len(splitChunks(content, workers)); chunks < 2 && len(bytes.Split(content, []byte("\n"))) > workers {
+				t.Fatalf("split into %d chunks", chunks)
+			}
+
+			serial := ingestWith(t, fileSpec, true)
+			parallel := ingestWith(t, fileSpec, false)
+			if !serial.ok || !parallel.ok {
+				t.Fatalf("ingest failed: serial %t, parallel %t", serial.ok, parallel.ok)
+			}
+
+			serialFields, parallelFields := serial.fields(), parallel.fields()
+			for i, field := range serialFields {
+				if !reflect.DeepEqual(field.value, parallelFields[i].value) {
+					t.Errorf("%s differs:\n  serial   %v\n  parallel %v", field.name, field.value, parallelFields[i].value)
+				}
+			}
+		})
+	}
+}
+
+// generateSampleLog writes roughly size bytes of plausible traffic, reproducibly
+func generateSampleLog(w io.Writer, size int64) error {
+	random := rand.New(rand.NewSource(20240410))
+
+	requests := []string{"GET /index.html", "GET /dashboard", "GET /profile", "GET /settings",
+		"GET /about", "GET /contact", "POST /login", "POST /logout", "POST /api/data",
+		"PUT /api/user", "DELETE /api/user", "PATCH /api/user"}
+	statuses := []int{200, 200, 200, 200, 201, 304, 401, 403, 404, 500, 503}
+
+	timestamp := time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC)
+
+	var written int64 = 0
+	for written < size {
+		timestamp = timestamp.Add(time.Duration(random.Intn(3)) * time.Second)
+		line := fmt.Sprintf("%s,192.168.%d.%d,%s,%d\n", timestamp.Format("2006-01-02T15:04:05"),
+			random.Intn(4), 1+random.Intn(254), requests[random.Intn(len(requests))], statuses[random.Intn(len(statuses))])
+		n, err := io.WriteString(w, line)
+		if err != nil {
+			return err
+		}
+		written += int64(n)
+	}
+
+	return nil
+}
+
+const BENCHMARK_LOG_SIZE = 50 * 1024 * 1024
+
+// benchmarkLog is a generated 50MB log
+func benchmarkLog(b *testing.B) string {
+	b.Helper()
+	file, err := os.Create(filepath.Join(b.TempDir(), "network_log.txt"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	writer := bufio.NewWriter(file)
+	err = generateSampleLog(writer, BENCHMARK_LOG_SIZE)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(BENCHMARK_LOG_SIZE)
+	return file.Name()
+}
+
+func BenchmarkIngestSerial(b *testing.B) {
+	fileSpec := benchmarkLog(b)
+	for b.Loop() {
+		if !ingestWith(b, fileSpec, true).ok {
+			b.Fatal("ingest failed")
+		}
+	}
+}
+
+func BenchmarkIngestParallel(b *testing.B) {
+	fileSpec := benchmarkLog(b)
+	for b.Loop() {
+		if !ingestWith(b, fileSpec, false).ok {
+			b.Fatal("ingest failed")
+		}
+	}
+}
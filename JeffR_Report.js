@@ -0,0 +1,40 @@
+// click a header to sort its table (cells may carry a data-sort value), type in a
+// table's filter box to show only the rows containing the text
+document.querySelectorAll("table.sortable").forEach(function (table) {
+	table.querySelectorAll("th").forEach(function (th, column) {
+		th.addEventListener("click", function () {
+			var ascending = !th.classList.contains("asc");
+			table.querySelectorAll("th").forEach(function (other) {
+				other.classList.remove("asc", "desc");
+			});
+			th.classList.add(ascending ? "asc" : "desc");
+
+			var body = table.tBodies[0];
+			var rows = Array.prototype.slice.call(body.rows);
+			var key = function (row) {
+				var cell = row.cells[column];
+				var value = cell.hasAttribute("data-sort") ? cell.getAttribute("data-sort") : cell.textContent;
+				var number = parseFloat(value);
+				return isNaN(number) || String(number) !== value.trim() ? value : number;
+			};
+			rows.sort(function (a, b) {
+				var ka = key(a), kb = key(b);
+				var order = typeof ka === "number" && typeof kb === "number" ? ka - kb : String(ka).localeCompare(String(kb));
+				return ascending ? order : -order;
+			});
+			rows.forEach(function (row) {
+				body.appendChild(row);
+			});
+		});
+	});
+});
+
+document.querySelectorAll("input.filter").forEach(function (input) {
+	var table = document.getElementById(input.getAttribute("data-table"));
+	input.addEventListener("input", function () {
+		var text = input.value.toLowerCase();
+		Array.prototype.forEach.call(table.tBodies[0].rows, function (row) {
+			row.style.display = row.textContent.toLowerCase().indexOf(text) >= 0 ? "" : "none";
+		});
+	});
+});
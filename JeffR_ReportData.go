@@ -0,0 +1,332 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"time"
+)
+
+// reportData is the complete analysis result in one (exported, serializable) shape;
+// built once after analyze() so every report format renders the same thing
+type reportData struct {
+	SchemaVersion string              `json:"schemaVersion"`
+	Source        string              `json:"source"`
+	Totals        reportTotals        `json:"totals"`
+	Summary       reportSummary       `json:"summary"`
+	IPs           []reportIP          `json:"ips"`
+	Paths         []string            `json:"paths"`
+	Spikes        []reportSpike       `json:"spikes"`
+	CyclicalGaps  []reportCyclicalGap `json:"cyclicalGaps"`
+	AbsoluteGaps  []reportAbsoluteGap `json:"absoluteGaps"`
+	Findings      []reportFinding     `json:"findings"`
+	Timeline      reportTimeline      `json:"timeline"`
+	HourlyVolume  []reportHourly      `json:"hourlyVolume"` // by weekday and hour of day, from the bucketed traffic volume
+	StatusCodes   []reportStatusCount `json:"statusCodes"`
+}
+
+type reportTotals struct {
+	Requests          int       `json:"requests"`
+	FailedLogins      int       `json:"failedLogins"`
+	DuplicatesDropped int       `json:"duplicatesDropped"`
+	IPs               int       `json:"ips"`
+	Start             time.Time `json:"start"`
+	End               time.Time `json:"end"`
+	SpanSeconds       float64   `json:"spanSeconds"`
+}
+
+type reportIP struct {
+	Address      string          `json:"address"`
+	Requests     int             `json:"requests"`
+	FailedLogins int             `json:"failedLogins"`
+	Succeeded    int64           `json:"succeeded"`
+	Failed       int64           `json:"failed"`
+	UpWeight     int64           `json:"upWeight"`
+	DownWeight   int64           `json:"downWeight"`
+	FirstSeen    time.Time       `json:"firstSeen"`
+	LastSeen     time.Time       `json:"lastSeen"`
+	ByWeekday    []reportWeekday `json:"byWeekday"` // Monday first, days without traffic omitted
+	ByPath       []reportPath    `json:"byPath"`
+}
+
+type reportWeekday struct {
+	Weekday   string `json:"weekday"`
+	Succeeded int64  `json:"succeeded"`
+	Requests  int64  `json:"requests"`
+}
+
+type reportPath struct {
+	Path      string         `json:"path"`
+	Succeeded int64          `json:"succeeded"`
+	Requests  int64          `json:"requests"`
+	Weight    int64          `json:"weight"`
+	Methods   []reportMethod `json:"methods"`
+}
+
+type reportMethod struct {
+	Method       string `json:"method"`
+	Succeeded    int64  `json:"succeeded"`
+	Failed       int64  `json:"failed"`
+	Weight       int64  `json:"weight"`
+	MinTimeOfDay string `json:"minTimeOfDay"`
+	MaxTimeOfDay string `json:"maxTimeOfDay"`
+}
+
+type reportSpike struct {
+	StartWeekday            string  `json:"startWeekday"`
+	StartTimeOfDay          string  `json:"startTimeOfDay"`
+	EndWeekday              string  `json:"endWeekday"`
+	EndTimeOfDay            string  `json:"endTimeOfDay"`
+	SpansSeconds            float64 `json:"spansSeconds"`
+	Requests                int64   `json:"requests"`
+	Days                    int     `json:"days"`
+	RequestsPerSecondPerDay float64 `json:"requestsPerSecondPerDay"`
+	Singleton               bool    `json:"singleton"` // a single VOLUME_BUCKET interval
+}
+
+type reportCyclicalGap struct {
+	StartWeekday   string  `json:"startWeekday"`
+	StartTimeOfDay string  `json:"startTimeOfDay"`
+	EndWeekday     string  `json:"endWeekday"`
+	EndTimeOfDay   string  `json:"endTimeOfDay"`
+	SpansSeconds   float64 `json:"spansSeconds"`
+}
+
+type reportAbsoluteGap struct {
+	Start          time.Time `json:"start"`
+	End            time.Time `json:"end"`
+	ElapsedSeconds float64   `json:"elapsedSeconds"`
+}
+
+type reportFinding struct {
+	Detector   string           `json:"detector"`
+	Severity   string           `json:"severity"`
+	Confidence float64          `json:"confidence"`
+	Subject    string           `json:"subject"` // the IP, path, time range or period below, as text
+	IP         string           `json:"ip,omitempty"`
+	Path       string           `json:"path,omitempty"`
+	From       time.Time        `json:"from,omitzero"`
+	To         time.Time        `json:"to,omitzero"`
+	Period     string           `json:"period,omitempty"`
+	At         time.Time        `json:"at"`
+	Detail     string           `json:"detail"`
+	Action     string           `json:"action"`
+	Evidence   []reportEvidence `json:"evidence"` // the latest events behind it, oldest first
+}
+
+type reportEvidence struct {
+	At     time.Time `json:"at"`
+	IP     string    `json:"ip"`
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	Status int       `json:"status"`
+}
+
+// reportTimeline is requests over the actual time span, in equal buckets
+type reportTimeline struct {
+	BucketSeconds float64          `json:"bucketSeconds"`
+	Buckets       []reportTimeSlot `json:"buckets"`
+}
+
+type reportTimeSlot struct {
+	Start    time.Time `json:"start"`
+	Requests int       `json:"requests"`
+	Failed   int       `json:"failed"`
+}
+
+type reportHourly struct {
+	Weekday  string `json:"weekday"`
+	Hour     int    `json:"hour"`
+	Requests int    `json:"requests"`
+}
+
+type reportStatusCount struct {
+	Status int `json:"status"`
+	Count  int `json:"count"`
+}
+
+// the timeline gets the smallest of these bucket sizes that keeps it within MAX_TIMELINE_BUCKETS
+var timelineBucketSizes = []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute, time.Hour,
+	3 * time.Hour, 6 * time.Hour, 24 * time.Hour, 7 * 24 * time.Hour}
+
+const MAX_TIMELINE_BUCKETS = 120
+
+var reportWeekdays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday}
+
+// buildReportData gathers the results of analyze() into the report model;
+// everything is put in a stable order so the same log always yields the same report
+func buildReportData(source string) reportData {
+	data := reportData{
+		SchemaVersion: REPORT_SCHEMA_VERSION,
+		Source:        source,
+		Totals: reportTotals{
+			Requests:          totalRequests,
+			FailedLogins:      totalFailedLogins,
+			DuplicatesDropped: duplicatesDropped,
+			IPs:               len(byIP),
+			Start:             minTime,
+			End:               maxTime,
+			SpanSeconds:       maxTime.Sub(minTime).Seconds(),
+		},
+		Summary:      summarize(),
+		IPs:          make([]reportIP, 0, len(byIP)),
+		Paths:        make([]string, 0),
+		Spikes:       make([]reportSpike, 0, len(activitySpikes)),
+		CyclicalGaps: make([]reportCyclicalGap, 0, len(activityGapsCyclical)),
+		AbsoluteGaps: make([]reportAbsoluteGap, 0, len(activityGapsAbsolute)),
+		Findings:     make([]reportFinding, 0, len(findings)),
+		HourlyVolume: make([]reportHourly, 0),
+		StatusCodes:  make([]reportStatusCount, 0),
+	}
+
+	paths := make(map[string]bool)
+
+	for ipAddr, indexes := range byIP {
+		ip := reportIP{
+			Address:      ipAddr,
+			Requests:     requestsByIP[ipAddr],
+			FailedLogins: failedLoginsByIP[ipAddr],
+			FirstSeen:    networkData[indexes[0]].timestamp,
+			LastSeen:     networkData[indexes[len(indexes)-1]].timestamp,
+			ByWeekday:    make([]reportWeekday, 0),
+			ByPath:       make([]reportPath, 0),
+		}
+
+		details := trafficByIP[ipAddr]
+
+		for _, weekday := range reportWeekdays {
+			if resultsVal, ok := details.byWeekday[weekday]; ok {
+				ip.ByWeekday = append(ip.ByWeekday, reportWeekday{weekday.String(), resultsVal.succeeded, resultsVal.succeeded + resultsVal.failed})
+				ip.Succeeded += resultsVal.succeeded
+				ip.Failed += resultsVal.failed
+			}
+		}
+
+		for _, path := range sortedKeys(details.byPath) {
+			paths[path] = true
+			reportedPath := reportPath{Path: path, Methods: make([]reportMethod, 0)}
+			for _, method := range sortedKeys(details.byPath[path]) {
+				resultsVal := details.byPath[path][method]
+				reportedPath.Methods = append(reportedPath.Methods, reportMethod{method, resultsVal.succeeded, resultsVal.failed,
+					resultsVal.weight, toClock(resultsVal.minTOD), toClock(resultsVal.maxTOD)})
+				reportedPath.Succeeded += resultsVal.succeeded
+				reportedPath.Requests += resultsVal.succeeded + resultsVal.failed
+				reportedPath.Weight += resultsVal.weight
+				if resultsVal.weight < 0 {
+					ip.DownWeight += resultsVal.weight
+				} else {
+					ip.UpWeight += resultsVal.weight
+				}
+			}
+			ip.ByPath = append(ip.ByPath, reportedPath)
+		}
+
+		data.IPs = append(data.IPs, ip)
+	}
+
+	// most failed logins first, then busiest
+	sort.Slice(data.IPs, func(i, j int) bool {
+		a, b := data.IPs[i], data.IPs[j]
+		if a.FailedLogins != b.FailedLogins {
+			return a.FailedLogins > b.FailedLogins
+		}
+		if a.Requests != b.Requests {
+			return a.Requests > b.Requests
+		}
+		return a.Address < b.Address
+	})
+
+	data.Paths = sortedKeys(paths)
+
+	for _, spike := range activitySpikes {
+		data.Spikes = append(data.Spikes, reportSpike{
+			spike.start.weekday.String(), toClock(spike.start.timeOfDay),
+			spike.end.weekday.String(), toClock(spike.end.timeOfDay),
+			spike.spans.Seconds(), spike.requests, trafficDays[spike.start], spike.avgRqs, spike.singleton})
+	}
+
+	for _, gap := range activityGapsCyclical {
+		data.CyclicalGaps = append(data.CyclicalGaps, reportCyclicalGap{
+			gap.start.weekday.String(), toClock(gap.start.timeOfDay),
+			gap.end.weekday.String(), toClock(gap.end.timeOfDay),
+			gap.spans.Seconds()})
+	}
+
+	for _, gap := range activityGapsAbsolute {
+		data.AbsoluteGaps = append(data.AbsoluteGaps, reportAbsoluteGap{gap.start, gap.end, gap.elapsed.Seconds()})
+	}
+
+	for _, finding := range findings {
+		evidence := make([]reportEvidence, 0, len(finding.Evidence))
+		for _, i := range finding.Evidence {
+			item := networkData[i]
+			evidence = append(evidence, reportEvidence{item.timestamp, item.ipAddr, item.method, item.path, item.statusCode})
+		}
+		subject := finding.Subject
+		data.Findings = append(data.Findings, reportFinding{finding.Detector, finding.Severity, finding.Confidence,
+			subject.String(), subject.IP, subject.Path, subject.From, subject.To, subject.Period,
+			finding.At, finding.Detail, finding.Action, evidence})
+	}
+
+	data.Timeline = buildTimeline()
+
+	hourly := make(map[trafficVolumeKey]int)
+	for key, count := range trafficVolume {
+		hourly[trafficVolumeKey{key.weekday, key.timeOfDay.Truncate(time.Hour)}] += count
+	}
+	for _, weekday := range reportWeekdays {
+		for hour := 0; hour < 24; hour++ {
+			if count := hourly[trafficVolumeKey{weekday, time.Duration(hour) * time.Hour}]; count > 0 {
+				data.HourlyVolume = append(data.HourlyVolume, reportHourly{weekday.String(), hour, count})
+			}
+		}
+	}
+
+	statusCounts := make(map[int]int)
+	for _, item := range networkData {
+		statusCounts[item.statusCode]++
+	}
+	for status, count := range statusCounts {
+		data.StatusCodes = append(data.StatusCodes, reportStatusCount{status, count})
+	}
+	sort.Slice(data.StatusCodes, func(i, j int) bool { return data.StatusCodes[i].Status < data.StatusCodes[j].Status })
+
+	return data
+}
+
+// buildTimeline buckets the requests over the data set's span
+func buildTimeline() reportTimeline {
+	span := maxTime.Sub(minTime)
+
+	bucketSize := timelineBucketSizes[len(timelineBucketSizes)-1]
+	for _, size := range timelineBucketSizes {
+		if span/size < MAX_TIMELINE_BUCKETS {
+			bucketSize = size
+			break
+		}
+	}
+
+	start := minTime.Truncate(bucketSize)
+	buckets := make([]reportTimeSlot, int(maxTime.Sub(start)/bucketSize)+1)
+	for i := range buckets {
+		buckets[i].Start = start.Add(time.Duration(i) * bucketSize)
+	}
+
+	for _, item := range networkData {
+		i := int(item.timestamp.Sub(start) / bucketSize)
+		buckets[i].Requests++
+		if isHttpError(item.statusCode) {
+			buckets[i].Failed++
+		}
+	}
+
+	return reportTimeline{bucketSize.Seconds(), buckets}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// detective serve: analyses of uploaded (or watched) logs shared over HTTP, as JSON and HTML
+
+const MAX_UPLOAD = 64 << 20 // bigger logs belong on the command line or in the -watch directory
+const WATCH_POLL = 5 * time.Second
+
+//go:embed JeffR_Server.html.tmpl
+var serverIndexTemplate string
+
+var serverIndex = template.Must(template.New("index").Funcs(reportFuncs).Parse(serverIndexTemplate))
+
+// serverLog is an analysis as listed by the server
+type serverLog struct {
+	Source   string       `json:"source"`
+	Analyzed time.Time    `json:"analyzed"`
+	Totals   reportTotals `json:"totals"`
+	Findings int          `json:"findings"`
+}
+
+type analysisServer struct {
+	lock     sync.RWMutex
+	analyses map[string]reportData
+	analyzed map[string]time.Time
+
+	// the analyzer's state is global, so one log at a time
+	loading sync.Mutex
+
+	watching string
+}
+
+// runServer is "detective serve [-addr <addr>] [-watch <dir>] [<logFile> ...]"
+func runServer(args []string) bool {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := flags.String("addr", "localhost:8080", "")
+	watch := flags.String("watch", "", "")
+	config := flags.String("config", "", "")
+	flags.Usage = func() {}
+	if err := flags.Parse(args); err != nil {
+		log.Println("ERR:", err)
+		return false
+	}
+	if !loadConfig(*config, nil) {
+		return false
+	}
+
+	s := &analysisServer{
+		analyses: make(map[string]reportData),
+		analyzed: make(map[string]time.Time),
+		watching: *watch,
+	}
+
+	// status to stderr, the server's own log
+	statusOut = os.Stderr
+
+	for _, fileSpec := range flags.Args() {
+		if _, err := s.analyze(fileSpec, filepath.Base(fileSpec)); err != nil {
+			log.Println("ERR:", err)
+			return false
+		}
+	}
+
+	if len(s.watching) > 0 {
+		if info, err := os.Stat(s.watching); err != nil || !info.IsDir() {
+			log.Println("ERR: can't watch", s.watching, "- not a directory")
+			return false
+		}
+		go s.watch()
+	}
+
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           s.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	stopped := make(chan os.Signal, 1)
+	signal.Notify(stopped, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stopped)
+	go func() {
+		<-stopped
+		server.Close()
+	}()
+
+	log.Println("Serving analyses on", *addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Println("ERR:", err)
+		return false
+	}
+	return true
+}
+
+func (s *analysisServer) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", s.handleIndex)
+	mux.HandleFunc("GET /report", s.handleReport)
+	mux.HandleFunc("POST /upload", s.handleUpload)
+
+	mux.HandleFunc("GET /api/logs", s.handleLogs)
+	mux.HandleFunc("POST /api/logs", s.handleApiUpload)
+	mux.HandleFunc("GET /api/summary", s.withAnalysis(func(data reportData) any {
+		return s.summary(data)
+	}))
+	mux.HandleFunc("GET /api/ips", s.withAnalysis(func(data reportData) any {
+		return data.IPs
+	}))
+	mux.HandleFunc("GET /api/ips/{ip}", s.handleIP)
+	mux.HandleFunc("GET /api/spikes", s.withAnalysis(func(data reportData) any {
+		return data.Spikes
+	}))
+	mux.HandleFunc("GET /api/gaps", s.withAnalysis(func(data reportData) any {
+		return map[string]any{"cyclical": data.CyclicalGaps, "absolute": data.AbsoluteGaps}
+	}))
+	mux.HandleFunc("GET /api/findings", s.withAnalysis(func(data reportData) any {
+		return data.Findings
+	}))
+	mux.HandleFunc("GET /api/report", s.withAnalysis(func(data reportData) any {
+		return data
+	}))
+	return mux
+}
+
+// analyze runs the analysis over a log file and keeps it under the name given; a failed
+// one leaves whatever was kept under the name before
+func (s *analysisServer) analyze(fileSpec string, name string) (reportData, error) {
+	data, err := s.load(fileSpec, name)
+	if err != nil {
+		return reportData{}, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.analyses[name] = data
+	s.analyzed[name] = time.Now()
+	return data, nil
+}
+
+// load runs the analyzer, which is global, one log at a time
+func (s *analysisServer) load(fileSpec string, name string) (reportData, error) {
+	s.loading.Lock()
+	defer s.loading.Unlock()
+
+	resetTrafficData()
+	if loadLogFile(fileSpec) != EXIT_OK {
+		return reportData{}, fmt.Errorf("%s: no analysis, see the server log", name)
+	}
+	return buildReportData(name), nil
+}
+
+// analyzeUpload analyzes a log read from a request
+func (s *analysisServer) analyzeUpload(body io.Reader, name string) (reportData, error) {
+	name = filepath.Base(strings.TrimSpace(name))
+	if name == "." || name == "/" || len(name) == 0 {
+		name = "upload-" + time.Now().Format("20060102T150405")
+	}
+
+	file, err := os.CreateTemp("", "detective-upload-*.log")
+	if err != nil {
+		return reportData{}, err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = io.Copy(file, body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return reportData{}, err
+	}
+
+	return s.analyze(file.Name(), name)
+}
+
+// lookup finds an analysis by name, or the newest for ""
+func (s *analysisServer) lookup(name string) (reportData, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if len(name) == 0 {
+		var newest time.Time
+		for source, analyzed := range s.analyzed {
+			if analyzed.After(newest) {
+				name, newest = source, analyzed
+			}
+		}
+	}
+	data, ok := s.analyses[name]
+	return data, ok
+}
+
+// logs lists the analyses, newest first
+func (s *analysisServer) logs() []serverLog {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	logs := make([]serverLog, 0, len(s.analyses))
+	for name, data := range s.analyses {
+		logs = append(logs, serverLog{name, s.analyzed[name], data.Totals, len(data.Findings)})
+	}
+	slices.SortFunc(logs, func(a serverLog, b serverLog) int {
+		return b.Analyzed.Compare(a.Analyzed)
+	})
+	return logs
+}
+
+func (s *analysisServer) summary(data reportData) serverLog {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return serverLog{data.Source, s.analyzed[data.Source], data.Totals, len(data.Findings)}
+}
+
+// watch analyzes logs as they appear or change in the watched directory
+func (s *analysisServer) watch() {
+	type seen struct {
+		size    int64
+		modTime time.Time
+	}
+	watched := make(map[string]seen)
+
+	for {
+		entries, err := os.ReadDir(s.watching)
+		if err != nil {
+			log.Println("ERR:", err)
+		}
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			current := seen{info.Size(), info.ModTime()}
+			if watched[entry.Name()] == current {
+				continue
+			}
+			watched[entry.Name()] = current
+			if _, err := s.analyze(filepath.Join(s.watching, entry.Name()), entry.Name()); err != nil {
+				log.Println("ERR:", err)
+			}
+		}
+		time.Sleep(WATCH_POLL)
+	}
+}
+
+func (s *analysisServer) handleIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := serverIndex.Execute(w, map[string]any{"Logs": s.logs(), "Watching": s.watching})
+	if err != nil {
+		log.Println("ERR:", err)
+	}
+}
+
+func (s *analysisServer) handleReport(w http.ResponseWriter, r *http.Request) {
+	data, ok := s.lookup(r.URL.Query().Get("log"))
+	if !ok {
+		http.Error(w, "no such analysis", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := writeHtmlReport(w, data); err != nil {
+		log.Println("ERR:", err)
+	}
+}
+
+// handleUpload takes the UI's multipart form, then shows the report
+func (s *analysisServer) handleUpload(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, MAX_UPLOAD)
+	file, header, err := r.FormFile("log")
+	if err != nil {
+		http.Error(w, "expected a log file upload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	data, err := s.analyzeUpload(file, header.Filename)
+	if err != nil {
+		http.Error(w, err.Error(), uploadStatus(err))
+		return
+	}
+	http.Redirect(w, r, "/report?log="+url.QueryEscape(data.Source), http.StatusSeeOther)
+}
+
+// handleApiUpload analyzes a raw request body as a log
+func (s *analysisServer) handleApiUpload(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, MAX_UPLOAD)
+	data, err := s.analyzeUpload(r.Body, r.URL.Query().Get("name"))
+	if err != nil {
+		writeJsonError(w, uploadStatus(err), err.Error())
+		return
+	}
+	writeJson(w, http.StatusCreated, s.summary(data))
+}
+
+// uploadStatus is the response status for an upload that didn't analyze
+func uploadStatus(err error) int {
+	var tooBig *http.MaxBytesError
+	if errors.As(err, &tooBig) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusUnprocessableEntity
+}
+
+func (s *analysisServer) handleLogs(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, s.logs())
+}
+
+func (s *analysisServer) handleIP(w http.ResponseWriter, r *http.Request) {
+	data, ok := s.lookup(r.URL.Query().Get("log"))
+	if !ok {
+		writeJsonError(w, http.StatusNotFound, "no such analysis")
+		return
+	}
+	address := r.PathValue("ip")
+	i := slices.IndexFunc(data.IPs, func(ip reportIP) bool { return ip.Address == address })
+	if i < 0 {
+		writeJsonError(w, http.StatusNotFound, "no traffic from "+address)
+		return
+	}
+	writeJson(w, http.StatusOK, data.IPs[i])
+}
+
+// withAnalysis serves part of the analysis picked by ?log=
+func (s *analysisServer) withAnalysis(part func(data reportData) any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, ok := s.lookup(r.URL.Query().Get("log"))
+		if !ok {
+			writeJsonError(w, http.StatusNotFound, "no such analysis")
+			return
+		}
+		writeJson(w, http.StatusOK, part(data))
+	}
+}
+
+func writeJson(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		log.Println("ERR:", err)
+	}
+}
+
+func writeJsonError(w http.ResponseWriter, status int, message string) {
+	writeJson(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"maps"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer is the server's routes over no analyses yet, its status and log kept out
+// of the test's output
+func newTestServer(t *testing.T) *httptest.Server {
+	was := statusOut
+	t.Cleanup(func() {
+		statusOut = was
+		log.SetOutput(os.Stderr)
+	})
+	statusOut = io.Discard
+	log.SetOutput(io.Discard)
+
+	s := &analysisServer{analyses: make(map[string]reportData), analyzed: make(map[string]time.Time)}
+	server := httptest.NewServer(s.routes())
+	t.Cleanup(server.Close)
+	return server
+}
+
+// call makes the request, and decodes the JSON response into v unless it's nil
+func call(t *testing.T, method string, url string, contentType string, body io.Reader, v any) *http.Response {
+	t.Helper()
+	request, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contentType) > 0 {
+		request.Header.Set("Content-Type", contentType)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(response.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s: %v", method, url, err)
+		}
+	}
+	return response
+}
+
+func apiUpload(t *testing.T, server *httptest.Server, name string, fileSpec string) serverLog {
+	t.Helper()
+	content, err := os.ReadFile(fileSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var summary serverLog
+	response := call(t, "POST", server.URL+"/api/logs?name="+name, "text/plain", bytes.NewReader(content), &summary)
+	if response.StatusCode != http.StatusCreated {
+		t.Fatalf("uploading %s: %s", name, response.Status)
+	}
+	return summary
+}
+
+func TestServerApi(t *testing.T) {
+	server := newTestServer(t)
+
+	var failed map[string]string
+	if response := call(t, "GET", server.URL+"/api/summary", "", nil, &failed); response.StatusCode != http.StatusNotFound || failed["error"] != "no such analysis" {
+		t.Errorf("summary of nothing: %s %v", response.Status, failed)
+	}
+
+	attack := apiUpload(t, server, "attack.log", writeAttackLog(t))
+	if attack.Source != "attack.log" || attack.Totals.Requests != 58 || attack.Findings == 0 {
+		t.Errorf("uploaded %+v, expected attack.log, 58 requests and findings", attack)
+	}
+	time.Sleep(time.Millisecond) // so the next is newer
+	sample := apiUpload(t, server, "../sample.log", sampleLogs(t)[0])
+	if sample.Source != "sample.log" {
+		t.Errorf("uploaded as %q, expected the name without its directory", sample.Source)
+	}
+
+	var logs []serverLog
+	call(t, "GET", server.URL+"/api/logs", "", nil, &logs)
+	if len(logs) != 2 || logs[0].Source != "sample.log" || logs[1].Source != "attack.log" {
+		t.Errorf("logs %+v, expected sample.log then attack.log", logs)
+	}
+
+	// without ?log= it's the newest
+	var summary serverLog
+	call(t, "GET", server.URL+"/api/summary", "", nil, &summary)
+	if summary.Source != "sample.log" {
+		t.Errorf("summary of %q, expected the newest", summary.Source)
+	}
+	call(t, "GET", server.URL+"/api/summary?log=attack.log", "", nil, &summary)
+	if summary != attack {
+		t.Errorf("summary %+v, expected %+v", summary, attack)
+	}
+
+	var ips []reportIP
+	call(t, "GET", server.URL+"/api/ips?log=attack.log", "", nil, &ips)
+	addresses := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		addresses = append(addresses, ip.Address)
+	}
+	slices.Sort(addresses)
+	if want := []string{"10.0.0.9", "10.9.9.9", "192.168.1.50", "2001:db8::7"}; !slices.Equal(addresses, want) {
+		t.Errorf("ips %v, expected %v", addresses, want)
+	}
+
+	var ip reportIP
+	call(t, "GET", server.URL+"/api/ips/2001:db8::7?log=attack.log", "", nil, &ip)
+	if ip.Address != "2001:db8::7" || ip.FailedLogins != 8 {
+		t.Errorf("ip %s with %d failed logins, expected 2001:db8::7 with 8", ip.Address, ip.FailedLogins)
+	}
+	if response := call(t, "GET", server.URL+"/api/ips/10.1.1.1?log=attack.log", "", nil, &failed); response.StatusCode != http.StatusNotFound || failed["error"] != "no traffic from 10.1.1.1" {
+		t.Errorf("unknown ip: %s %v", response.Status, failed)
+	}
+
+	var findings []reportFinding
+	call(t, "GET", server.URL+"/api/findings?log=attack.log", "", nil, &findings)
+	if len(findings) != attack.Findings {
+		t.Errorf("%d findings, the summary has %d", len(findings), attack.Findings)
+	}
+
+	var gaps map[string]json.RawMessage
+	call(t, "GET", server.URL+"/api/gaps?log=attack.log", "", nil, &gaps)
+	if _, ok := gaps["cyclical"]; !ok || len(gaps) != 2 {
+		t.Errorf("gaps %v, expected cyclical and absolute", slices.Sorted(maps.Keys(gaps)))
+	}
+
+	var spikes []reportSpike
+	if response := call(t, "GET", server.URL+"/api/spikes?log=attack.log", "", nil, &spikes); response.StatusCode != http.StatusOK {
+		t.Errorf("spikes: %s", response.Status)
+	}
+
+	var report reportData
+	response := call(t, "GET", server.URL+"/api/report?log=attack.log", "", nil, &report)
+	if response.Header.Get("Content-Type") != "application/json" || report.Source != "attack.log" || len(report.IPs) != len(ips) {
+		t.Errorf("report of %q with %d IPs, as %s", report.Source, len(report.IPs), response.Header.Get("Content-Type"))
+	}
+
+	for _, path := range []string{"/api/report", "/api/ips", "/api/ips/10.0.0.9", "/api/spikes", "/api/gaps", "/api/findings"} {
+		if response := call(t, "GET", server.URL+path+"?log=missing.log", "", nil, nil); response.StatusCode != http.StatusNotFound {
+			t.Errorf("%s of a missing log: %s", path, response.Status)
+		}
+	}
+}
+
+// a log with nothing to analyze isn't kept, and doesn't replace what was kept under its name
+func TestServerApiUploadWithoutData(t *testing.T) {
+	server := newTestServer(t)
+	attack := apiUpload(t, server, "traffic.log", writeAttackLog(t))
+
+	var failed map[string]string
+	response := call(t, "POST", server.URL+"/api/logs?name=traffic.log", "text/plain", strings.NewReader("not a log line\n"), &failed)
+	if response.StatusCode != http.StatusUnprocessableEntity || len(failed["error"]) == 0 {
+		t.Errorf("upload of no data: %s %v", response.Status, failed)
+	}
+	var summary serverLog
+	call(t, "GET", server.URL+"/api/summary?log=traffic.log", "", nil, &summary)
+	if summary != attack {
+		t.Errorf("summary %+v after a failed upload, expected %+v", summary, attack)
+	}
+}
+
+// the UI's form upload redirects to the report, under the file's name
+func TestServerFormUpload(t *testing.T) {
+	server := newTestServer(t)
+	content, err := os.ReadFile(writeAttackLog(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	part, err := writer.CreateFormFile("log", "my traffic.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	writer.Close()
+
+	response := call(t, "POST", server.URL+"/upload", writer.FormDataContentType(), &form, nil)
+	if response.StatusCode != http.StatusSeeOther || response.Header.Get("Location") != "/report?log=my+traffic.log" {
+		t.Fatalf("upload: %s to %q", response.Status, response.Header.Get("Location"))
+	}
+	response = call(t, "GET", server.URL+response.Header.Get("Location"), "", nil, nil)
+	if response.StatusCode != http.StatusOK || !strings.HasPrefix(response.Header.Get("Content-Type"), "text/html") {
+		t.Errorf("report: %s, %s", response.Status, response.Header.Get("Content-Type"))
+	}
+
+	var logs []serverLog
+	call(t, "GET", server.URL+"/api/logs", "", nil, &logs)
+	if len(logs) != 1 || logs[0].Source != "my traffic.log" {
+		t.Errorf("logs %+v, expected the upload", logs)
+	}
+
+	if response := call(t, "POST", server.URL+"/upload", "text/plain", strings.NewReader("no form"), nil); response.StatusCode != http.StatusBadRequest {
+		t.Errorf("upload without a file: %s", response.Status)
+	}
+	if response := call(t, "GET", server.URL+"/report?log=missing.log", "", nil, nil); response.StatusCode != http.StatusNotFound {
+		t.Errorf("report of a missing log: %s", response.Status)
+	}
+}
+
+func TestUploadStatus(t *testing.T) {
+	if status := uploadStatus(&http.MaxBytesError{Limit: MAX_UPLOAD}); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("too big an upload is %d", status)
+	}
+	if status := uploadStatus(io.ErrUnexpectedEOF); status != http.StatusUnprocessableEntity {
+		t.Errorf("a failed upload is %d", status)
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -siem: findings (and, with -siemevents, the flagged requests behind them) as SIEM events -
+// ArcSight CEF, QRadar LEEF or Elastic Common Schema JSON - written to a file or sent over syslog
+
+// set via -siem cef|leef|ecs
+var siemFormat = ""
+
+// set via -siemto: a file, - for stdout, or udp:// tcp:// tls:// <host:port> of a syslog collector
+var siemDest = ""
+
+// set via -siemevents: also send every error response from a flagged IP
+var siemEvents = false
+
+// set via -siemca: PEM CA certificates to trust for a tls:// collector, beyond the system's
+var siemCA = ""
+
+const SIEM_VENDOR = "JeffR"
+const SIEM_PRODUCT = "Network Detective"
+const SIEM_DIAL_TIMEOUT = 10 * time.Second
+const SYSLOG_FACILITY = 16 // local0
+
+// siemEvent is one finding or flagged request, whatever the format
+type siemEvent struct {
+	at       time.Time
+	kind     string // alert for findings, event for flagged requests
+	name     string // the detector, or flagged-request
+	severity string // high, medium or low
+	ip       string // "" when the finding isn't about an IP, e.g. a spike
+	method   string
+	path     string
+	status   int // 0 for findings
+	detail   string
+}
+
+var siemFormats = map[string]func(event siemEvent) string{
+	"cef":  formatCef,
+	"leef": formatLeef,
+	"ecs":  formatEcs,
+}
+
+// the formats' numeric severities (CEF 0-10, LEEF 1-10, ECS event.severity)...
+var siemSeverities = map[string]int{"high": 8, "medium": 5, "low": 3}
+
+// ...and syslog's (crit, warning, notice; info for requests)
+var syslogSeverities = map[string]int{"high": 2, "medium": 4, "low": 5}
+
+// siemEventsOf turns the findings, then any flagged requests, into events
+func siemEventsOf(data reportData, withRequests bool) []siemEvent {
+	events := make([]siemEvent, 0, len(data.Findings))
+	flagged := make(map[string]string) // IP -> severity of its worst finding
+	for _, finding := range data.Findings {
+		severity := finding.Severity
+		event := siemEvent{at: finding.At, kind: "alert", name: finding.Detector, severity: severity, path: finding.Path, detail: finding.Detail}
+		if len(finding.IP) > 0 {
+			event.ip = finding.IP
+			if siemSeverities[severity] > siemSeverities[flagged[event.ip]] {
+				flagged[event.ip] = severity
+			}
+		} else {
+			event.detail = finding.Subject + ": " + finding.Detail
+		}
+		events = append(events, event)
+	}
+
+	if withRequests {
+		for _, item := range networkData {
+			if severity, ok := flagged[item.ipAddr]; ok && isHttpError(item.statusCode) {
+				events = append(events, siemEvent{at: item.timestamp, kind: "event", name: "flagged-request", severity: severity,
+					ip: item.ipAddr, method: item.method, path: item.path, status: item.statusCode})
+			}
+		}
+	}
+	return events
+}
+
+// writeSiemExport sends the events to the -siemto destination in the -siem format
+func writeSiemExport(format string, dest string, data reportData) error {
+	formatEvent, ok := siemFormats[format]
+	if !ok {
+		return fmt.Errorf("can't export %s - s/b cef, leef or ecs", format)
+	}
+
+	sink, err := openSiemSink(dest)
+	if err != nil {
+		return err
+	}
+
+	events := siemEventsOf(data, siemEvents)
+	for _, event := range events {
+		if err = sink.send(event, formatEvent(event)); err != nil {
+			break
+		}
+	}
+	if closeErr := sink.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(statusOut, "Sent", len(events), format, "events to", dest)
+	return nil
+}
+
+// siemSink is where formatted events go: a file as lines, a collector as syslog messages
+type siemSink interface {
+	send(event siemEvent, message string) error
+	io.Closer
+}
+
+func openSiemSink(dest string) (siemSink, error) {
+	if !strings.Contains(dest, "://") {
+		if dest == "-" {
+			return &fileSink{writer: bufio.NewWriter(os.Stdout)}, nil
+		}
+		file, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		return &fileSink{file: file, writer: bufio.NewWriter(file)}, nil
+	}
+
+	collector, err := url.Parse(dest)
+	if err != nil || len(collector.Host) == 0 {
+		return nil, fmt.Errorf("%s s/b udp://, tcp:// or tls:// <host:port>", dest)
+	}
+
+	var conn net.Conn
+	switch collector.Scheme {
+	case "udp", "tcp":
+		conn, err = net.DialTimeout(collector.Scheme, collector.Host, SIEM_DIAL_TIMEOUT)
+	case "tls":
+		config := &tls.Config{}
+		if len(siemCA) > 0 {
+			if config.RootCAs, err = loadCertPool(siemCA); err != nil {
+				return nil, err
+			}
+		}
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: SIEM_DIAL_TIMEOUT}, "tcp", collector.Host, config)
+	default:
+		return nil, fmt.Errorf("%s s/b udp://, tcp:// or tls:// <host:port>", dest)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	return &syslogSink{conn: conn, stream: collector.Scheme != "udp", hostname: hostname}, nil
+}
+
+// loadCertPool is the system's roots plus the PEM certificates in the file
+func loadCertPool(fileSpec string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(fileSpec)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s has no PEM certificates", fileSpec)
+	}
+	return pool, nil
+}
+
+type fileSink struct {
+	file   *os.File // nil for stdout
+	writer *bufio.Writer
+}
+
+func (s *fileSink) send(event siemEvent, message string) error {
+	_, err := fmt.Fprintln(s.writer, message)
+	return err
+}
+
+func (s *fileSink) Close() error {
+	err := s.writer.Flush()
+	if s.file != nil {
+		if closeErr := s.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// syslogSink sends RFC 5424 messages: a datagram each over UDP,
+// octet-counted (RFC 6587) over TCP and TLS
+type syslogSink struct {
+	conn     net.Conn
+	stream   bool
+	hostname string
+}
+
+func (s *syslogSink) send(event siemEvent, message string) error {
+	severity, ok := syslogSeverities[event.severity]
+	if !ok || event.kind == "event" {
+		severity = 6 // info
+	}
+	hostname := s.hostname
+	if len(hostname) == 0 {
+		hostname = "-"
+	}
+
+	frame := fmt.Sprintf("<%d>1 %s %s detective %d %s - %s", SYSLOG_FACILITY*8+severity,
+		event.at.UTC().Format(time.RFC3339), hostname, os.Getpid(), event.kind, message)
+	if s.stream {
+		frame = strconv.Itoa(len(frame)) + " " + frame
+	}
+	_, err := io.WriteString(s.conn, frame)
+	return err
+}
+
+func (s *syslogSink) Close() error {
+	return s.conn.Close()
+}
+
+// CEF escapes pipes and backslashes in the header, and equals signs and newlines in extension values
+var cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, "|", `\|`)
+var cefValueEscaper = strings.NewReplacer(`\`, `\\`, "=", `\=`, "\r", `\r`, "\n", `\n`)
+
+func formatCef(event siemEvent) string {
+	extension := []string{"rt=" + strconv.FormatInt(event.at.UnixMilli(), 10)}
+	add := func(key string, value string) {
+		if len(value) > 0 {
+			extension = append(extension, key+"="+cefValueEscaper.Replace(value))
+		}
+	}
+	add("src", event.ip)
+	add("requestMethod", event.method)
+	add("request", event.path)
+	if event.status > 0 {
+		add("cn1", strconv.Itoa(event.status))
+		add("cn1Label", "status")
+	}
+	add("cs1", event.name)
+	add("cs1Label", "detector")
+	add("msg", event.detail)
+
+	return fmt.Sprintf("CEF:0|%s|%s|%s|%s|%s|%d|%s", cefHeaderEscaper.Replace(SIEM_VENDOR), cefHeaderEscaper.Replace(SIEM_PRODUCT),
+		REPORT_SCHEMA_VERSION, cefHeaderEscaper.Replace(event.name), cefHeaderEscaper.Replace(siemTitle(event)),
+		siemSeverities[event.severity], strings.Join(extension, " "))
+}
+
+// LEEF 1.0 attributes are tab separated, so values mustn't hold tabs or newlines
+var leefHeaderEscaper = strings.NewReplacer(`\`, `\\`, "|", `\|`)
+var leefValueEscaper = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+
+func formatLeef(event siemEvent) string {
+	attributes := []string{
+		"devTime=" + event.at.Format("Jan 02 2006 15:04:05"),
+		"cat=" + event.kind,
+		"sev=" + strconv.Itoa(siemSeverities[event.severity]),
+	}
+	add := func(key string, value string) {
+		if len(value) > 0 {
+			attributes = append(attributes, key+"="+leefValueEscaper.Replace(value))
+		}
+	}
+	add("src", event.ip)
+	add("method", event.method)
+	add("url", event.path)
+	if event.status > 0 {
+		add("status", strconv.Itoa(event.status))
+	}
+	add("detector", event.name)
+	add("msg", event.detail)
+
+	return fmt.Sprintf("LEEF:1.0|%s|%s|%s|%s|%s", leefHeaderEscaper.Replace(SIEM_VENDOR), leefHeaderEscaper.Replace(SIEM_PRODUCT),
+		REPORT_SCHEMA_VERSION, leefHeaderEscaper.Replace(event.name), strings.Join(attributes, "\t"))
+}
+
+// ecsEvent is the Elastic Common Schema subset an event fills in
+type ecsEvent struct {
+	Timestamp time.Time   `json:"@timestamp"`
+	Message   string      `json:"message"`
+	Event     ecsCategory `json:"event"`
+	Rule      ecsRule     `json:"rule"`
+	Source    *ecsSource  `json:"source,omitempty"`
+	Http      *ecsHttp    `json:"http,omitempty"`
+	Url       *ecsUrl     `json:"url,omitempty"`
+	Observer  ecsObserver `json:"observer"`
+	Ecs       ecsVersion  `json:"ecs"`
+}
+
+type ecsCategory struct {
+	Kind     string   `json:"kind"`
+	Category []string `json:"category"`
+	Severity int      `json:"severity"`
+	Dataset  string   `json:"dataset"`
+}
+
+type ecsRule struct {
+	Name string `json:"name"`
+}
+
+type ecsSource struct {
+	IP string `json:"ip"`
+}
+
+type ecsHttp struct {
+	Request struct {
+		Method string `json:"method"`
+	} `json:"request"`
+	Response struct {
+		StatusCode int `json:"status_code"`
+	} `json:"response"`
+}
+
+type ecsUrl struct {
+	Path string `json:"path"`
+}
+
+type ecsObserver struct {
+	Vendor  string `json:"vendor"`
+	Product string `json:"product"`
+}
+
+type ecsVersion struct {
+	Version string `json:"version"`
+}
+
+func formatEcs(event siemEvent) string {
+	ecs := ecsEvent{
+		Timestamp: event.at,
+		Message:   event.detail,
+		Event:     ecsCategory{event.kind, []string{"intrusion_detection", "network"}, siemSeverities[event.severity], "detective." + event.kind},
+		Rule:      ecsRule{event.name},
+		Observer:  ecsObserver{SIEM_VENDOR, SIEM_PRODUCT},
+		Ecs:       ecsVersion{"8.11.0"},
+	}
+	if len(event.ip) > 0 {
+		ecs.Source = &ecsSource{event.ip}
+	}
+	if event.status > 0 {
+		ecs.Http = &ecsHttp{}
+		ecs.Http.Request.Method = event.method
+		ecs.Http.Response.StatusCode = event.status
+		ecs.Url = &ecsUrl{event.path}
+		ecs.Message = siemTitle(event)
+	}
+
+	var line strings.Builder
+	encoder := json.NewEncoder(&line)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(ecs) // nothing in it can fail to marshal
+	return strings.TrimSuffix(line.String(), "\n")
+}
+
+// siemTitle is the human readable event name CEF wants
+func siemTitle(event siemEvent) string {
+	if event.kind == "event" {
+		return fmt.Sprintf("%s %s -> %d", event.method, event.path, event.status)
+	}
+	return event.name + " detected"
+}
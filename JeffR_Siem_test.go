@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// siemTestData has a finding about an IP, with every character the formats escape in its
+// detail (and a multi-byte one, for octet counting), and one that isn't about an IP
+func siemTestData() reportData {
+	at := time.Date(2024, time.March, 1, 10, 0, 30, 0, time.UTC)
+	return reportData{Source: "test.log", Findings: []reportFinding{
+		{Detector: "brute-force", Severity: "high", Subject: "10.0.0.9", IP: "10.0.0.9", At: at,
+			Detail: `6 failed logins as user=admin|root \ café`},
+		{Detector: "spike", Severity: "low", Subject: "2024-03-01 10:00 - 10:04", At: at.Add(time.Hour),
+			Detail: "120 requests"},
+	}}
+}
+
+// an RFC 5424 message: <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+var syslogFrame = regexp.MustCompile(`^<(\d{1,3})>1 (\S+) (\S+) detective (\d+) (alert|event) - (.*)$`)
+
+func checkSyslogFrame(t *testing.T, frame string, event siemEvent, format string) {
+	t.Helper()
+	match := syslogFrame.FindStringSubmatch(frame)
+	if match == nil {
+		t.Errorf("%q isn't an RFC 5424 message", frame)
+		return
+	}
+
+	severity, ok := syslogSeverities[event.severity]
+	if !ok || event.kind == "event" {
+		severity = 6
+	}
+	if pri, _ := strconv.Atoi(match[1]); pri != SYSLOG_FACILITY*8+severity {
+		t.Errorf("PRI %d s/b %d for a %s %s", pri, SYSLOG_FACILITY*8+severity, event.severity, event.kind)
+	}
+	if at, err := time.Parse(time.RFC3339, match[2]); err != nil || !at.Equal(event.at) {
+		t.Errorf("timestamp %s s/b %s", match[2], event.at.Format(time.RFC3339))
+	}
+	if match[4] != strconv.Itoa(os.Getpid()) {
+		t.Errorf("PROCID %s s/b %d", match[4], os.Getpid())
+	}
+	if match[5] != event.kind {
+		t.Errorf("MSGID %s s/b %s", match[5], event.kind)
+	}
+	if want := siemFormats[format](event); match[6] != want {
+		t.Errorf("MSG\n  %s\ns/b\n  %s", match[6], want)
+	}
+}
+
+func TestSiemSyslogUdp(t *testing.T) {
+	statusOut = io.Discard
+	collector, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer collector.Close()
+
+	data := siemTestData()
+	if err := writeSiemExport("cef", "udp://"+collector.LocalAddr().String(), data); err != nil {
+		t.Fatal(err)
+	}
+
+	// a datagram per message, unframed
+	collector.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buffer := make([]byte, 65536)
+	for _, event := range siemEventsOf(data, false) {
+		n, _, err := collector.ReadFrom(buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkSyslogFrame(t, string(buffer[:n]), event, "cef")
+	}
+}
+
+func TestSiemSyslogTcp(t *testing.T) {
+	collector, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer collector.Close()
+	received := make(chan []byte)
+	go func() {
+		conn, err := collector.Accept()
+		if err != nil {
+			received <- nil
+			return
+		}
+		defer conn.Close()
+		stream, _ := io.ReadAll(conn)
+		received <- stream
+	}()
+
+	// the flagged requests too, sent at info
+	defer func(was bool) { siemEvents = was }(siemEvents)
+	siemEvents = true
+	data := analyzeSample(t, writeAttackLog(t))
+	data.Findings = append(data.Findings, siemTestData().Findings...)
+	events := siemEventsOf(data, true)
+
+	if err := writeSiemExport("leef", "tcp://"+collector.Addr().String(), data); err != nil {
+		t.Fatal(err)
+	}
+	stream := <-received
+
+	// RFC 6587 octet counting: MSG-LEN SP SYSLOG-MSG, the length in bytes, back to back
+	frames := make([]string, 0)
+	for len(stream) > 0 {
+		space := bytes.IndexByte(stream, ' ')
+		length, err := strconv.Atoi(string(stream[:max(space, 0)]))
+		if space < 1 || err != nil || length > len(stream)-space-1 {
+			t.Fatalf("bad octet count before %q", stream[:min(len(stream), 40)])
+		}
+		frames = append(frames, string(stream[space+1:space+1+length]))
+		stream = stream[space+1+length:]
+	}
+
+	if len(frames) != len(events) {
+		t.Fatalf("%d messages, expected %d", len(frames), len(events))
+	}
+	sawEvent := false
+	for i, event := range events {
+		checkSyslogFrame(t, frames[i], event, "leef")
+		sawEvent = sawEvent || event.kind == "event"
+	}
+	if !sawEvent {
+		t.Error("no flagged requests were sent")
+	}
+}
+
+func TestCefEscaping(t *testing.T) {
+	event := siemEvent{at: time.UnixMilli(1709287230000).UTC(), kind: "alert", name: `odd|name\x`, severity: "high",
+		ip: "10.0.0.9", path: `/a=b`, detail: "a=b|c\\d\r\nnext"}
+	cef := formatCef(event)
+
+	// the header escapes pipes and backslashes...
+	header := `CEF:0|JeffR|Network Detective|` + REPORT_SCHEMA_VERSION + `|odd\|name\\x|odd\|name\\x detected|8|`
+	if !strings.HasPrefix(cef, header) {
+		t.Errorf("header of\n  %s\ns/b\n  %s", cef, header)
+	}
+
+	// ...extension values equals signs, backslashes and newlines, but not pipes
+	extension := strings.TrimPrefix(cef, header)
+	for _, want := range []string{"rt=1709287230000", "src=10.0.0.9", `request=/a\=b`, `cs1=odd|name\\x`, `msg=a\=b|c\\d\r\nnext`} {
+		if !strings.Contains(" "+extension+" ", " "+want+" ") {
+			t.Errorf("extension %s lacks %s", extension, want)
+		}
+	}
+}
+
+func TestLeefEscaping(t *testing.T) {
+	event := siemEvent{at: time.Date(2024, time.March, 1, 10, 0, 30, 0, time.UTC), kind: "alert", name: `odd|name\x`,
+		severity: "medium", ip: "10.0.0.9", path: "/a=b", detail: "a=b|c\\d\tnext\nline"}
+	leef := formatLeef(event)
+
+	// the header escapes pipes and backslashes
+	header := `LEEF:1.0|JeffR|Network Detective|` + REPORT_SCHEMA_VERSION + `|odd\|name\\x|`
+	if !strings.HasPrefix(leef, header) {
+		t.Fatalf("header of\n  %s\ns/b\n  %s", leef, header)
+	}
+
+	// attributes split on tabs, then the first equals sign, so values keep theirs (and
+	// pipes and backslashes) and lose only tabs and newlines
+	attributes := make(map[string]string)
+	for _, attribute := range strings.Split(strings.TrimPrefix(leef, header), "\t") {
+		key, value, _ := strings.Cut(attribute, "=")
+		attributes[key] = value
+	}
+	want := map[string]string{"devTime": "Mar 01 2024 10:00:30", "cat": "alert", "sev": "5", "src": "10.0.0.9",
+		"url": "/a=b", "detector": `odd|name\x`, "msg": `a=b|c\d next line`}
+	for key, value := range want {
+		if attributes[key] != value {
+			t.Errorf("%s=%q s/b %q", key, attributes[key], value)
+		}
+	}
+	if len(attributes) != len(want) {
+		t.Errorf("attributes %v s/b %v", attributes, want)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// -stix: flagged IPs as a STIX 2.1 bundle, for sharing indicators with other teams
+
+// set via -stix <file>
+var stixSpec = ""
+
+// the SCO namespace STIX 2.1 derives deterministic cyber-observable ids from...
+const STIX_SCO_NAMESPACE = "00abedb4-aa42-466c-9c01-fed23315a9b7"
+
+// ...and ours, for everything else, so rerunning over the same log gives the same ids
+const STIX_NAMESPACE = "5c0d8a2e-3f7b-4e51-9a6c-1d2e3f4a5b6c"
+
+// created is immutable for an id, so objects take their times from the data, not the clock:
+// the identity and attack patterns are the same for every log, so were created (and last
+// modified - move that forward on changing them) when they were defined
+var stixDefinitionsCreated = time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC)
+var stixDefinitionsModified = stixDefinitionsCreated
+
+// stixAttackPattern is what each IP detector finds, in MITRE ATT&CK terms
+type stixAttackPattern struct {
+	name        string
+	techniqueId string
+	description string
+}
+
+var stixAttackPatterns = map[string]stixAttackPattern{
+	"brute-force": {"Brute Force", "T1110", "Repeated failed logins from one IP in a short window"},
+	"scanning":    {"Active Scanning", "T1595", "One IP drawing errors from many distinct paths in a short window"},
+}
+
+type stixBundle struct {
+	Type    string `json:"type"`
+	Id      string `json:"id"`
+	Objects []any  `json:"objects"`
+}
+
+// stixCommon is what every STIX domain and relationship object carries
+type stixCommon struct {
+	Type         string `json:"type"`
+	SpecVersion  string `json:"spec_version"`
+	Id           string `json:"id"`
+	Created      string `json:"created"`
+	Modified     string `json:"modified"`
+	CreatedByRef string `json:"created_by_ref,omitempty"`
+	Description  string `json:"description,omitempty"`
+	Name         string `json:"name,omitempty"`
+}
+
+type stixIdentity struct {
+	stixCommon
+	IdentityClass string `json:"identity_class"`
+}
+
+type stixExternalReference struct {
+	SourceName string `json:"source_name"`
+	ExternalId string `json:"external_id,omitempty"`
+	Url        string `json:"url,omitempty"`
+}
+
+type stixAttackPatternObject struct {
+	stixCommon
+	ExternalReferences []stixExternalReference `json:"external_references"`
+}
+
+type stixIndicator struct {
+	stixCommon
+	IndicatorTypes []string `json:"indicator_types"`
+	Pattern        string   `json:"pattern"`
+	PatternType    string   `json:"pattern_type"`
+	ValidFrom      string   `json:"valid_from"`
+}
+
+type stixAddress struct {
+	Type        string `json:"type"`
+	SpecVersion string `json:"spec_version"`
+	Id          string `json:"id"`
+	Value       string `json:"value"`
+}
+
+type stixObservedData struct {
+	stixCommon
+	FirstObserved  string   `json:"first_observed"`
+	LastObserved   string   `json:"last_observed"`
+	NumberObserved int      `json:"number_observed"`
+	ObjectRefs     []string `json:"object_refs"`
+}
+
+type stixRelationship struct {
+	stixCommon
+	RelationshipType string `json:"relationship_type"`
+	SourceRef        string `json:"source_ref"`
+	TargetRef        string `json:"target_ref"`
+}
+
+type stixSighting struct {
+	stixCommon
+	FirstSeen        string   `json:"first_seen"`
+	LastSeen         string   `json:"last_seen"`
+	Count            int      `json:"count"`
+	SightingOfRef    string   `json:"sighting_of_ref"`
+	ObservedDataRefs []string `json:"observed_data_refs"`
+	WhereSightedRefs []string `json:"where_sighted_refs"`
+}
+
+// writeStixBundle writes the bundle to a file, or stdout for -
+func writeStixBundle(fileSpec string, data reportData) error {
+	bundle := buildStixBundle(data)
+
+	if fileSpec == "-" {
+		return encodeStixBundle(os.Stdout, bundle)
+	}
+	file, err := os.Create(fileSpec)
+	if err != nil {
+		return err
+	}
+	err = encodeStixBundle(file, bundle)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(statusOut, "Bundled", len(bundle.Objects), "STIX objects into", fileSpec)
+	return nil
+}
+
+func encodeStixBundle(w io.Writer, bundle stixBundle) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(bundle)
+}
+
+// buildStixBundle holds, for every IP a detector flagged: an indicator (indicating the
+// detectors' attack patterns), the IP as an observable, what was observed of it, and a
+// sighting of the indicator in that observed data. An IP's objects were created when it
+// was first seen and last modified when it was last seen, so a rerun over the log as it
+// grows re-issues the same ids and created times, modified only moving forward
+func buildStixBundle(data reportData) stixBundle {
+	common := func(kind string, key string, created time.Time, modified time.Time, name string, description string) stixCommon {
+		return stixCommon{Type: kind, SpecVersion: "2.1", Id: stixId(kind, STIX_NAMESPACE, key),
+			Created: stixTime(created), Modified: stixTime(modified), Name: name, Description: description}
+	}
+	defined := func(kind string, key string, name string, description string) stixCommon {
+		return common(kind, key, stixDefinitionsCreated, stixDefinitionsModified, name, description)
+	}
+
+	identity := stixIdentity{defined("identity", SIEM_VENDOR+"/"+SIEM_PRODUCT, SIEM_PRODUCT, "Network traffic log analysis"), "system"}
+	bundle := stixBundle{Type: "bundle", Id: "bundle--" + randomUuid(), Objects: []any{identity}}
+	own := func(object stixCommon) stixCommon {
+		object.CreatedByRef = identity.Id
+		return object
+	}
+
+	// which detectors flagged each IP
+	detectorsByIP := make(map[string][]string)
+	for _, finding := range data.Findings {
+		if _, ok := stixAttackPatterns[finding.Detector]; !ok {
+			continue
+		}
+		if !slices.Contains(detectorsByIP[finding.IP], finding.Detector) {
+			detectorsByIP[finding.IP] = append(detectorsByIP[finding.IP], finding.Detector)
+		}
+	}
+
+	patternIds := make(map[string]string)
+	for _, detector := range sortedKeys(stixAttackPatterns) {
+		pattern := stixAttackPatterns[detector]
+		object := stixAttackPatternObject{own(defined("attack-pattern", detector, pattern.name, pattern.description)),
+			[]stixExternalReference{{"mitre-attack", pattern.techniqueId,
+				"https://attack.mitre.org/techniques/" + pattern.techniqueId + "/"}}}
+		patternIds[detector] = object.Id
+		bundle.Objects = append(bundle.Objects, object)
+	}
+
+	for _, ip := range data.IPs {
+		detectors := detectorsByIP[ip.Address]
+		addr, err := netip.ParseAddr(ip.Address)
+		if len(detectors) == 0 || err != nil {
+			continue
+		}
+		addr = addr.Unmap()
+		addressType := "ipv4-addr"
+		if addr.Is6() {
+			addressType = "ipv6-addr"
+		}
+		key := data.Source + "/" + addr.String()
+		seen := func(kind string, key string, name string, description string) stixCommon {
+			return common(kind, key, ip.FirstSeen, ip.LastSeen, name, description)
+		}
+
+		address := stixAddress{addressType, "2.1", stixId(addressType, STIX_SCO_NAMESPACE, `{"value":"`+addr.String()+`"}`), addr.String()}
+
+		indicator := stixIndicator{
+			own(seen("indicator", key, "Malicious IP "+addr.String(),
+				fmt.Sprintf("Flagged for %s in %s", strings.Join(detectors, ", "), data.Source))),
+			[]string{"malicious-activity"},
+			fmt.Sprintf("[%s:value = '%s']", addressType, addr),
+			"stix",
+			stixTime(ip.FirstSeen),
+		}
+
+		observed := stixObservedData{
+			own(seen("observed-data", key, "", "")),
+			stixTime(ip.FirstSeen), stixTime(ip.LastSeen), max(ip.Requests, 1), []string{address.Id},
+		}
+
+		sighting := stixSighting{
+			own(seen("sighting", key, "", fmt.Sprintf("%d requests, %d failed logins", ip.Requests, ip.FailedLogins))),
+			stixTime(ip.FirstSeen), stixTime(ip.LastSeen), max(ip.Requests, 1),
+			indicator.Id, []string{observed.Id}, []string{identity.Id},
+		}
+
+		bundle.Objects = append(bundle.Objects, address, indicator, observed, sighting)
+		for _, detector := range detectors {
+			bundle.Objects = append(bundle.Objects, stixRelationship{
+				own(seen("relationship", key+"/"+detector, "", "")), "indicates", indicator.Id, patternIds[detector]})
+		}
+	}
+	return bundle
+}
+
+// stixTime is the timestamp format STIX requires: UTC, to the millisecond
+func stixTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// stixId is a deterministic (UUIDv5) id for the object of that type and key
+func stixId(kind string, namespace string, key string) string {
+	space, _ := hex.DecodeString(strings.ReplaceAll(namespace, "-", "")) // a constant, known good
+
+	hash := sha1.Sum(append(space, key...))
+	hash[6] = hash[6]&0x0f | 0x50 // version 5
+	hash[8] = hash[8]&0x3f | 0x80 // RFC 4122 variant
+	return kind + "--" + formatUuid(hash[:16])
+}
+
+// randomUuid is a UUIDv4, for the bundle - a container, not something anyone refers to
+func randomUuid() string {
+	uuid := make([]byte, 16)
+	rand.Read(uuid)
+	uuid[6] = uuid[6]&0x0f | 0x40
+	uuid[8] = uuid[8]&0x3f | 0x80
+	return formatUuid(uuid)
+}
+
+func formatUuid(uuid []byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeAttackLog writes a log the brute-force and scanning detectors both flag: failed logins
+// from an IPv4 and an IPv6 address, 4xx probing from another, among ordinary traffic
+func writeAttackLog(t testing.TB) string {
+	t.Helper()
+	start := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	var lines []string
+	add := func(at time.Duration, ip string, request string, status int) {
+		lines = append(lines, fmt.Sprintf("%s,%s,%s,%d", start.Add(at).Format(LOG_TIMESTAMP_LAYOUT), ip, request, status))
+	}
+	for i := range 8 {
+		add(time.Duration(i)*5*time.Second, "10.0.0.9", "POST /login", 401)
+		add(time.Duration(i)*7*time.Second, "2001:db8::7", "POST /login", 401)
+	}
+	for i := range 12 {
+		add(time.Minute+time.Duration(i)*10*time.Second, "10.9.9.9", fmt.Sprintf("GET /admin%d.php", i), 404)
+	}
+	for i := range 30 {
+		add(time.Duration(i)*time.Minute, "192.168.1.50", "GET /index.html", 200)
+	}
+
+	fileSpec := filepath.Join(t.TempDir(), "attack.log")
+	if err := os.WriteFile(fileSpec, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fileSpec
+}
+
+var stixIdFormat = regexp.MustCompile(`^([a-z0-9-]+)--[0-9a-f]{8}-[0-9a-f]{4}-([1-5])[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+// stixObjects renders the bundle as JSON and reads it back, as a consumer would see it
+func stixObjects(t *testing.T, data reportData) (map[string]any, []map[string]any) {
+	t.Helper()
+	text, err := json.Marshal(buildStixBundle(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(text))
+	decoder.UseNumber() // as the schema validator expects
+	var bundle map[string]any
+	if err := decoder.Decode(&bundle); err != nil {
+		t.Fatal(err)
+	}
+	objects := make([]map[string]any, 0)
+	for _, object := range bundle["objects"].([]any) {
+		objects = append(objects, object.(map[string]any))
+	}
+	return bundle, objects
+}
+
+// loadStixSchemas is the official STIX 2.1 schemas for a bundle and the objects ours holds
+func loadStixSchemas(t *testing.T) jsonSchema {
+	return loadJsonSchemas(t, filepath.Join("testdata", "stix2.1"), "common/bundle.json")
+}
+
+func TestStixBundleMatchesSchemas(t *testing.T) {
+	bundle, objects := stixObjects(t, analyzeSample(t, writeAttackLog(t)))
+	schema := loadStixSchemas(t)
+	for _, problem := range schema.validate(bundle) {
+		t.Error(problem)
+	}
+
+	// the bundle's anyOf only says which object type came nearest, so each on its own too
+	for _, object := range objects {
+		kind := fmt.Sprint(object["type"])
+		var objectSchema map[string]any
+		for id, document := range schema.documents {
+			if strings.HasSuffix(id, "/"+kind+".json") {
+				objectSchema = document
+			}
+		}
+		if objectSchema == nil {
+			t.Errorf("%v: no schema for %s", object["id"], kind)
+			continue
+		}
+		for _, problem := range schema.check(objectSchema, object, fmt.Sprint(object["id"])) {
+			t.Error(problem)
+		}
+	}
+}
+
+// the schemas catch what the spec requires, so the test above can't pass vacuously
+func TestStixSchemasReject(t *testing.T) {
+	schema := loadStixSchemas(t)
+	data := analyzeSample(t, writeAttackLog(t))
+	first := func(objects []map[string]any, kind string) map[string]any {
+		for _, object := range objects {
+			if object["type"] == kind {
+				return object
+			}
+		}
+		t.Fatalf("no %s in the bundle", kind)
+		return nil
+	}
+
+	cases := map[string]func(bundle map[string]any, objects []map[string]any){
+		"no objects":        func(bundle map[string]any, _ []map[string]any) { bundle["objects"] = []any{} },
+		"bundle id":         func(bundle map[string]any, _ []map[string]any) { bundle["id"] = "indicator--" + STIX_NAMESPACE },
+		"indicator pattern": func(_ map[string]any, objects []map[string]any) { delete(first(objects, "indicator"), "pattern") },
+		"identity name":     func(_ map[string]any, objects []map[string]any) { delete(first(objects, "identity"), "name") },
+		"spec version":      func(_ map[string]any, objects []map[string]any) { first(objects, "sighting")["spec_version"] = "2.0" },
+		"created to the second": func(_ map[string]any, objects []map[string]any) {
+			first(objects, "relationship")["created"] = "2024-03-01T10:00:00Z"
+		},
+		"valid_from": func(_ map[string]any, objects []map[string]any) {
+			first(objects, "indicator")["valid_from"] = "2024-03-01 10:00:00"
+		},
+		"number observed": func(_ map[string]any, objects []map[string]any) {
+			first(objects, "observed-data")["number_observed"] = json.Number("0")
+		},
+		"empty object_refs": func(_ map[string]any, objects []map[string]any) {
+			first(objects, "observed-data")["object_refs"] = []any{}
+		},
+		"ref to a non-id": func(_ map[string]any, objects []map[string]any) {
+			first(objects, "relationship")["target_ref"] = "10.0.0.9"
+		},
+		"where sighted isn't an identity": func(_ map[string]any, objects []map[string]any) {
+			sighting := first(objects, "sighting")
+			sighting["where_sighted_refs"] = []any{sighting["sighting_of_ref"]}
+		},
+		"external reference with only a source": func(_ map[string]any, objects []map[string]any) {
+			first(objects, "attack-pattern")["external_references"] = []any{map[string]any{"source_name": "mitre-attack"}}
+		},
+		"address without a value": func(_ map[string]any, objects []map[string]any) { delete(first(objects, "ipv6-addr"), "value") },
+	}
+	for name, mutate := range cases {
+		t.Run(name, func(t *testing.T) {
+			bundle, objects := stixObjects(t, data)
+			mutate(bundle, objects)
+			if len(schema.validate(bundle)) == 0 {
+				t.Error("the schemas accepted the bundle")
+			}
+		})
+	}
+}
+
+// what the schemas can't say: which UUID versions the ids are, that refs resolve within
+// the bundle, that nothing was modified before it was created, and what the bundle holds
+func TestStixBundleMeetsSpec(t *testing.T) {
+	bundle, objects := stixObjects(t, analyzeSample(t, writeAttackLog(t)))
+
+	if match := stixIdFormat.FindStringSubmatch(fmt.Sprint(bundle["id"])); match == nil || match[1] != "bundle" || match[2] != "4" {
+		t.Errorf("bundle id %v s/b bundle--<UUIDv4>", bundle["id"])
+	}
+
+	ids := make(map[string]bool)
+	kinds := make(map[string]int)
+	for _, object := range objects {
+		ids[fmt.Sprint(object["id"])] = true
+	}
+	for _, object := range objects {
+		kind, _ := object["type"].(string)
+		id := fmt.Sprint(object["id"])
+		kinds[kind]++
+
+		if match := stixIdFormat.FindStringSubmatch(id); match == nil || match[1] != kind || match[2] != "5" {
+			t.Errorf("id %s s/b %s--<UUIDv5>", id, kind)
+		}
+		for property, value := range object {
+			if strings.HasSuffix(property, "_ref") && !ids[fmt.Sprint(value)] {
+				t.Errorf("%s: %s %v isn't in the bundle", id, property, value)
+			}
+			if strings.HasSuffix(property, "_refs") {
+				for _, ref := range value.([]any) {
+					if !ids[fmt.Sprint(ref)] {
+						t.Errorf("%s: %s %v isn't in the bundle", id, property, ref)
+					}
+				}
+			}
+		}
+		if created, modified := fmt.Sprint(object["created"]), fmt.Sprint(object["modified"]); created > modified {
+			t.Errorf("%s: modified %s before created %s", id, modified, created)
+		}
+	}
+
+	for _, kind := range []string{"identity", "attack-pattern", "indicator", "observed-data", "relationship", "sighting", "ipv4-addr", "ipv6-addr"} {
+		if kinds[kind] == 0 {
+			t.Errorf("no %s in the bundle", kind)
+		}
+	}
+}
+
+// ids are deterministic, so the same object must keep its created time between runs, with
+// only modified moving forward as the log grows
+func TestStixBundleReissuesObjects(t *testing.T) {
+	fileSpec := writeAttackLog(t)
+	_, first := stixObjects(t, analyzeSample(t, fileSpec))
+	_, again := stixObjects(t, analyzeSample(t, fileSpec))
+	if fmt.Sprint(first) != fmt.Sprint(again) {
+		t.Error("rerunning over the same log changed the bundle's objects")
+	}
+
+	text, err := os.ReadFile(fileSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := "2024-03-01T12:00:00,10.0.0.9,GET /index.html,200\n"
+	if err := os.WriteFile(fileSpec, append(text, later...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, grown := stixObjects(t, analyzeSample(t, fileSpec))
+
+	before := make(map[string]map[string]any)
+	for _, object := range first {
+		before[fmt.Sprint(object["id"])] = object
+	}
+	moved := false
+	for _, object := range grown {
+		id := fmt.Sprint(object["id"])
+		earlier, ok := before[id]
+		if !ok {
+			continue
+		}
+		if object["created"] != earlier["created"] {
+			t.Errorf("%s: created changed from %v to %v", id, earlier["created"], object["created"])
+		}
+		if fmt.Sprint(object["modified"]) < fmt.Sprint(earlier["modified"]) {
+			t.Errorf("%s: modified went back from %v to %v", id, earlier["modified"], object["modified"])
+		}
+		moved = moved || object["modified"] != earlier["modified"]
+	}
+	if !moved {
+		t.Error("no object's modified moved forward with 10.0.0.9's later request")
+	}
+}
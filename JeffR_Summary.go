@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// the executive summary: an overall risk rating, the most important findings in plain
+// sentences, the trend against an earlier period's report, and what to do about it all
+
+// set via -previous <report.json>: an earlier period's JSON report to trend against
+var previousSpec = ""
+var previousReport *reportData
+
+const SUMMARY_HEADLINES = 3 // findings told in sentences
+const SUMMARY_SUBJECTS = 5  // subjects named per recommendation
+
+type reportSummary struct {
+	Risk            string                 `json:"risk"` // high, medium, low or none
+	RiskReason      string                 `json:"riskReason"`
+	Headlines       []string               `json:"headlines"` // the most important findings, as sentences
+	Compared        string                 `json:"compared,omitempty"`
+	Trend           []string               `json:"trend"` // versus the -previous report, empty without one
+	Recommendations []reportRecommendation `json:"recommendations"`
+}
+
+// reportRecommendation is what to do about every finding of one kind
+type reportRecommendation struct {
+	Detector string   `json:"detector"`
+	Severity string   `json:"severity"`
+	Findings int      `json:"findings"`
+	Subjects []string `json:"subjects"` // the first SUMMARY_SUBJECTS
+	Action   string   `json:"action"`
+}
+
+// what to do about each kind of finding, given the subjects it names
+var summaryRecommendations = map[string]string{
+	"brute-force": "Block %s (-blocklist writes the firewall rules), put lockouts or MFA in front of the login, and reset the passwords of any account they got into",
+	"scanning":    "Block %s at the edge, and check that nothing the scans probed is exposed that shouldn't be",
+	"spike":       "Match the surges at %s against releases and campaigns; rate limit at the load balancer if they were floods",
+	"busy-period": "Size capacity and rate limits for the recurring peak at %s",
+	"failing-path": "Check the clients at %s: a broken integration fails the same request over and over, " +
+		"a prober moves on - block the probers",
+}
+
+// loadPreviousReport reads the -previous report; reports from before findings carried
+// their severity and IP have them filled in
+func loadPreviousReport(fileSpec string) bool {
+	file, err := os.Open(fileSpec)
+	if err != nil {
+		log.Println("ERR:", err)
+		return false
+	}
+	defer file.Close()
+
+	var previous reportData
+	if err := json.NewDecoder(file).Decode(&previous); err != nil || !strings.HasPrefix(previous.SchemaVersion, "1.") {
+		log.Println("ERR: -previous", fileSpec, "s/b a JSON report (-output json) of schema version 1.x")
+		return false
+	}
+	for i, finding := range previous.Findings {
+		if len(finding.Severity) == 0 {
+			previous.Findings[i].Severity = detectorSeverity(finding.Detector)
+		}
+		if _, err := netip.ParseAddr(finding.Subject); len(finding.IP) == 0 && err == nil {
+			previous.Findings[i].IP = finding.Subject
+		}
+	}
+	previousReport = &previous
+	return true
+}
+
+// summarize is the executive summary of the current analysis
+func summarize() reportSummary {
+	summary := reportSummary{Risk: "none", RiskReason: "Nothing found", Headlines: make([]string, 0),
+		Trend: make([]string, 0), Recommendations: make([]reportRecommendation, 0)}
+
+	if len(findings) > 0 {
+		// findings are ranked, so the first is the most severe
+		summary.Risk = findings[0].Severity
+		detectors := make([]string, 0)
+		at := 0
+		for _, finding := range findings {
+			if finding.Severity == summary.Risk {
+				at++
+				if !slices.Contains(detectors, finding.Detector) {
+					detectors = append(detectors, finding.Detector)
+				}
+			}
+		}
+		summary.RiskReason = fmt.Sprintf("%d %s severity %s (%s)", at, summary.Risk, plural(at, "finding"), strings.Join(detectors, ", "))
+	}
+
+	for _, finding := range findings[:min(len(findings), SUMMARY_HEADLINES)] {
+		summary.Headlines = append(summary.Headlines, findingSentence(finding))
+	}
+
+	if previousReport != nil {
+		summary.Compared = fmt.Sprintf("%s, %s to %s", previousReport.Source,
+			previousReport.Totals.Start.Format("2006-01-02 15:04"), previousReport.Totals.End.Format("2006-01-02 15:04"))
+		summary.Trend = trendSentences(*previousReport)
+	}
+
+	// every distinct subject by detector; a subject can have several findings
+	distinct := make(map[string]map[string]bool)
+	for _, finding := range findings {
+		i := slices.IndexFunc(summary.Recommendations, func(r reportRecommendation) bool { return r.Detector == finding.Detector })
+		if i < 0 {
+			summary.Recommendations = append(summary.Recommendations, reportRecommendation{Detector: finding.Detector,
+				Severity: finding.Severity, Subjects: make([]string, 0)})
+			i = len(summary.Recommendations) - 1
+		}
+		recommendation := &summary.Recommendations[i]
+		recommendation.Findings++
+		subject := finding.Subject.String()
+		if distinct[finding.Detector] == nil {
+			distinct[finding.Detector] = make(map[string]bool)
+		}
+		distinct[finding.Detector][subject] = true
+		if len(recommendation.Subjects) < SUMMARY_SUBJECTS && !slices.Contains(recommendation.Subjects, subject) {
+			recommendation.Subjects = append(recommendation.Subjects, subject)
+		}
+	}
+	for i, recommendation := range summary.Recommendations {
+		subjects := strings.Join(recommendation.Subjects, ", ")
+		if more := len(distinct[recommendation.Detector]) - len(recommendation.Subjects); more > 0 {
+			subjects += fmt.Sprintf(" and %d more", more)
+		}
+		action, ok := summaryRecommendations[recommendation.Detector]
+		if !ok {
+			action = findingActions[recommendation.Detector] + ": %s"
+		}
+		summary.Recommendations[i].Action = fmt.Sprintf(action, subjects)
+	}
+
+	return summary
+}
+
+// findingSentence tells a finding the way an analyst would
+func findingSentence(finding Finding) string {
+	subject := finding.Subject
+	switch finding.Detector {
+	case "brute-force":
+		// follow the IP's run of failed logins until it pauses, or a login gets through
+		failed := 0
+		var first, last, succeeded time.Time
+		for _, i := range byIP[subject.IP] {
+			item := networkData[i]
+			if !isLoginPath(item.path) || item.timestamp.Before(subject.From) {
+				continue
+			}
+			if failed > 0 && item.timestamp.Sub(last) > BRUTE_FORCE_WINDOW {
+				break
+			}
+			if !isHttpError(item.statusCode) {
+				if failed > 0 {
+					succeeded = item.timestamp
+					break
+				}
+				continue
+			}
+			if failed == 0 {
+				first = item.timestamp
+			}
+			failed++
+			last = item.timestamp
+		}
+		sentence := fmt.Sprintf("%s failed %d logins in %s", subject.IP, failed, last.Sub(first).Round(time.Second))
+		if succeeded.IsZero() {
+			return sentence + " and never got in"
+		}
+		return sentence + " then succeeded at " + succeeded.Format("15:04:05") + " - treat that account as compromised"
+
+	case "scanning":
+		paths := make(map[string]bool)
+		var first, last time.Time
+		// likewise the run of errors, until it pauses
+		for _, i := range byIP[subject.IP] {
+			item := networkData[i]
+			if item.timestamp.Before(subject.From) || item.statusCode/100 != 4 {
+				continue
+			}
+			if len(paths) > 0 && item.timestamp.Sub(last) > SCAN_WINDOW {
+				break
+			}
+			if len(paths) == 0 {
+				first = item.timestamp
+			}
+			paths[item.path] = true
+			last = item.timestamp
+		}
+		return fmt.Sprintf("%s drew errors from %d different paths in %s, probing for something to exploit",
+			subject.IP, len(paths), last.Sub(first).Round(time.Second))
+
+	case "spike":
+		from, to := eventRange(subject.From, subject.To)
+		historyFrom, _ := eventRange(subject.From.Add(-time.Duration(SPIKE_HISTORY)*SPIKE_BUCKET), subject.From)
+		sentence := fmt.Sprintf("Traffic surged to %d requests between %s and %s on %s", to-from,
+			subject.From.Format("15:04"), subject.To.Format("15:04"), subject.From.Format("Mon 2 Jan"))
+		if average := float64(from-historyFrom) / float64(SPIKE_HISTORY); average > 0 {
+			return sentence + fmt.Sprintf(", %.0f times the usual %.1f", float64(to-from)/average, average)
+		}
+		return sentence + ", from next to nothing"
+
+	case "busy-period":
+		busiest := activitySpikes[0]
+		average := float64(totalRequests) / maxTime.Sub(minTime).Seconds()
+		return fmt.Sprintf("Traffic peaks every %s, at %.0f times the log's average rate", subject.Period, busiest.avgRqs/average)
+
+	case "failing-path":
+		if len(finding.Evidence) > 0 {
+			method := networkData[finding.Evidence[0]].method
+			results := trafficByIP[subject.IP].byPath[subject.Path][method]
+			return fmt.Sprintf("%s failed %s %s %d of %d times", subject.IP, method, subject.Path,
+				results.failed, results.failed+results.succeeded)
+		}
+	}
+	return subject.String() + ": " + finding.Detail
+}
+
+// trendSentences compare this analysis with the previous period's
+func trendSentences(previous reportData) []string {
+	trend := make([]string, 0)
+
+	trend = append(trend, fmt.Sprintf("Requests: %d, %s on %d", totalRequests, change(totalRequests, previous.Totals.Requests), previous.Totals.Requests))
+	trend = append(trend, fmt.Sprintf("Failed logins: %d, %s on %d", totalFailedLogins, change(totalFailedLogins, previous.Totals.FailedLogins), previous.Totals.FailedLogins))
+
+	high, previousHigh := 0, 0
+	for _, finding := range findings {
+		if finding.Severity == "high" {
+			high++
+		}
+	}
+	for _, finding := range previous.Findings {
+		if finding.Severity == "high" {
+			previousHigh++
+		}
+	}
+	trend = append(trend, fmt.Sprintf("Findings: %d (%d high), %s on %d (%d high)", len(findings), high,
+		change(len(findings), len(previous.Findings)), len(previous.Findings), previousHigh))
+
+	flagged, previouslyFlagged := make([]string, 0), make([]string, 0)
+	for _, finding := range findings {
+		if len(finding.Subject.IP) > 0 && !slices.Contains(flagged, finding.Subject.IP) {
+			flagged = append(flagged, finding.Subject.IP)
+		}
+	}
+	for _, finding := range previous.Findings {
+		if len(finding.IP) > 0 && !slices.Contains(previouslyFlagged, finding.IP) {
+			previouslyFlagged = append(previouslyFlagged, finding.IP)
+		}
+	}
+	newly := slices.DeleteFunc(slices.Clone(flagged), func(ip string) bool { return slices.Contains(previouslyFlagged, ip) })
+	gone := slices.DeleteFunc(slices.Clone(previouslyFlagged), func(ip string) bool { return slices.Contains(flagged, ip) })
+	if len(newly) > 0 {
+		trend = append(trend, "Newly flagged: "+strings.Join(newly, ", "))
+	}
+	if len(gone) > 0 {
+		trend = append(trend, "No longer flagged: "+strings.Join(gone, ", "))
+	}
+	if len(flagged) > len(newly) {
+		trend = append(trend, fmt.Sprintf("Still flagged: %d IPs from the previous period", len(flagged)-len(newly)))
+	}
+	return trend
+}
+
+// change describes now against before, e.g. up 25%
+func change(now int, before int) string {
+	switch {
+	case now == before:
+		return "unchanged"
+	case before == 0:
+		return "up"
+	case now > before:
+		return fmt.Sprintf("up %.0f%%", float64(now-before)*100/float64(before))
+	}
+	return fmt.Sprintf("down %.0f%%", float64(before-now)*100/float64(before))
+}
+
+func plural(count int, noun string) string {
+	if count == 1 {
+		return noun
+	}
+	return noun + "s"
+}
+
+// writeSummary is the executive summary as text, for the text report and the email digest
+func writeSummary(w io.Writer, out terminal, summary reportSummary) {
+	fmt.Fprintf(w, "Overall risk: %s - %s\n", out.severity(summary.Risk, strings.ToUpper(summary.Risk)), summary.RiskReason)
+
+	if len(summary.Headlines) > 0 {
+		fmt.Fprintln(w, "\nMost important:")
+		for i, headline := range summary.Headlines {
+			fmt.Fprintf(w, "  %d. %s\n", i+1, headline)
+		}
+	}
+
+	if len(summary.Trend) > 0 {
+		fmt.Fprintf(w, "\nCompared with %s:\n", summary.Compared)
+		for _, trend := range summary.Trend {
+			fmt.Fprintf(w, "  - %s\n", trend)
+		}
+	}
+
+	if len(summary.Recommendations) > 0 {
+		fmt.Fprintln(w, "\nRecommended actions:")
+		for _, recommendation := range summary.Recommendations {
+			fmt.Fprintf(w, "  - %s (%d): %s\n", recommendation.Detector, recommendation.Findings, recommendation.Action)
+		}
+	}
+}
+
+// reportExecutiveSummary heads the text report
+func reportExecutiveSummary(out terminal) {
+	fmt.Println()
+	fmt.Println("Executive Summary")
+	fmt.Println("=================")
+	writeSummary(os.Stdout, out, summarize())
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"math"
+	"sort"
+	"strings"
+)
+
+// self-contained SVG charts for the HTML report: no scripts, no external assets,
+// every label taken from the log is escaped
+
+const CHART_WIDTH = 900
+const CHART_TOP_IPS = 10
+
+const chartSuccessColor = "#4a90d9"
+const chartFailureColor = "#d0454c"
+
+// svgChart starts an SVG document of the given size; close it with "</svg>"
+func svgChart(b *strings.Builder, width int, height int, title string) {
+	fmt.Fprintf(b, `<svg xmlns="http://www.w3.org/2000/svg" class="chart" width="%d" height="%d" viewBox="0 0 %d %d" role="img" aria-label="%s" font-family="sans-serif" font-size="11">`,
+		width, height, width, height, html.EscapeString(title))
+	fmt.Fprintf(b, `<title>%s</title>`, html.EscapeString(title))
+}
+
+// timelineChart stacks failed over successful requests for each timeline bucket
+func timelineChart(data reportData) template.HTML {
+	const height, left, bottom, top = 220, 50, 30, 10
+
+	buckets := data.Timeline.Buckets
+	plotWidth := float64(CHART_WIDTH - left - 10)
+	plotHeight := float64(height - bottom - top)
+
+	peak := 1
+	for _, bucket := range buckets {
+		peak = max(peak, bucket.Requests)
+	}
+
+	var b strings.Builder
+	svgChart(&b, CHART_WIDTH, height, "Requests over time")
+
+	// axes, with the peak and the ends of the span labelled
+	fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#999"/>`, left, top, left, height-bottom)
+	fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#999"/>`, left, height-bottom, CHART_WIDTH-10, height-bottom)
+	fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="end">%d</text>`, left-4, top+8, peak)
+	fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="end">0</text>`, left-4, height-bottom)
+
+	if len(buckets) > 0 {
+		barWidth := plotWidth / float64(len(buckets))
+		for i, bucket := range buckets {
+			x := float64(left) + float64(i)*barWidth
+			okHeight := plotHeight * float64(bucket.Requests-bucket.Failed) / float64(peak)
+			failHeight := plotHeight * float64(bucket.Failed) / float64(peak)
+			baseline := float64(height - bottom)
+			label := fmt.Sprintf("%s: %d requests, %d failed", bucket.Start.Format("2006-01-02 15:04"), bucket.Requests, bucket.Failed)
+
+			fmt.Fprintf(&b, `<g><title>%s</title>`, html.EscapeString(label))
+			fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"/>`,
+				x, baseline-okHeight, math.Max(barWidth-1, 0.5), okHeight, chartSuccessColor)
+			fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"/>`,
+				x, baseline-okHeight-failHeight, math.Max(barWidth-1, 0.5), failHeight, chartFailureColor)
+			b.WriteString(`</g>`)
+		}
+
+		fmt.Fprintf(&b, `<text x="%d" y="%d">%s</text>`, left, height-bottom+16, buckets[0].Start.Format("2006-01-02 15:04"))
+		fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="end">%s (%s buckets)</text>`, CHART_WIDTH-10, height-bottom+16,
+			buckets[len(buckets)-1].Start.Format("2006-01-02 15:04"), secondsString(data.Timeline.BucketSeconds))
+	}
+
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// heatmapChart shades each weekday x hour of day cell by its share of the busiest cell
+func heatmapChart(data reportData) template.HTML {
+	const cell, left, top = 32, 80, 20
+	height := top + 7*cell + 10
+
+	peak := 1
+	volume := make(map[string]int)
+	for _, hourly := range data.HourlyVolume {
+		volume[fmt.Sprintf("%s %d", hourly.Weekday, hourly.Hour)] = hourly.Requests
+		peak = max(peak, hourly.Requests)
+	}
+
+	var b strings.Builder
+	svgChart(&b, left+24*cell+10, height, "Traffic by weekday and hour of day")
+
+	for hour := 0; hour < 24; hour += 2 {
+		fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="middle">%02d:00</text>`, left+hour*cell+cell/2, top-6, hour)
+	}
+
+	for row, weekday := range reportWeekdays {
+		y := top + row*cell
+		fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="end">%s</text>`, left-6, y+cell/2+4, weekday)
+		for hour := 0; hour < 24; hour++ {
+			requests := volume[fmt.Sprintf("%s %d", weekday, hour)]
+			fill := "#f0f0f0"
+			if requests > 0 {
+				// from pale to saturated blue
+				intensity := float64(requests) / float64(peak)
+				fill = fmt.Sprintf("rgb(%d,%d,%d)", int(230-200*intensity), int(240-150*intensity), 255)
+			}
+			fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s" stroke="#fff"><title>%s %02d:00-%02d:59: %d requests</title></rect>`,
+				left+hour*cell, y, cell, cell, fill, weekday, hour, hour, requests)
+		}
+	}
+
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// hbar is one horizontal bar: a label and its good/bad portions
+type hbar struct {
+	label string
+	good  int
+	bad   int
+}
+
+// horizontalBarChart draws labelled bars scaled to the largest total
+func horizontalBarChart(title string, bars []hbar) template.HTML {
+	const row, left = 22, 130
+	height := len(bars)*row + 10
+
+	peak := 1
+	for _, bar := range bars {
+		peak = max(peak, bar.good+bar.bad)
+	}
+	plotWidth := float64(CHART_WIDTH - left - 80)
+
+	var b strings.Builder
+	svgChart(&b, CHART_WIDTH, height, title)
+
+	for i, bar := range bars {
+		y := 5 + i*row
+		goodWidth := plotWidth * float64(bar.good) / float64(peak)
+		badWidth := plotWidth * float64(bar.bad) / float64(peak)
+		label := html.EscapeString(bar.label)
+
+		fmt.Fprintf(&b, `<g><title>%s: %d (%d failed)</title>`, label, bar.good+bar.bad, bar.bad)
+		fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="end">%s</text>`, left-6, y+row/2+3, label)
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%.1f" height="%d" fill="%s"/>`, left, y+2, goodWidth, row-6, chartSuccessColor)
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%d" width="%.1f" height="%d" fill="%s"/>`, float64(left)+goodWidth, y+2, badWidth, row-6, chartFailureColor)
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d">%d</text>`, float64(left)+goodWidth+badWidth+4, y+row/2+3, bar.good+bar.bad)
+		b.WriteString(`</g>`)
+	}
+
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// statusChart is the distribution of response statuses, errors in red
+func statusChart(data reportData) template.HTML {
+	bars := make([]hbar, 0, len(data.StatusCodes))
+	for _, status := range data.StatusCodes {
+		if isHttpError(status.Status) {
+			bars = append(bars, hbar{fmt.Sprint(status.Status), 0, status.Count})
+		} else {
+			bars = append(bars, hbar{fmt.Sprint(status.Status), status.Count, 0})
+		}
+	}
+	return horizontalBarChart("Response status distribution", bars)
+}
+
+// topIPsChart is the busiest IPs, their failed requests in red
+func topIPsChart(data reportData) template.HTML {
+	ips := make([]reportIP, len(data.IPs))
+	copy(ips, data.IPs)
+	sortByRequests(ips)
+
+	bars := make([]hbar, 0, CHART_TOP_IPS)
+	for i := 0; i < len(ips) && i < CHART_TOP_IPS; i++ {
+		bars = append(bars, hbar{ips[i].Address, int(ips[i].Succeeded), int(ips[i].Failed)})
+	}
+	return horizontalBarChart(fmt.Sprintf("Top %d IPs by requests", CHART_TOP_IPS), bars)
+}
+
+// sortByRequests orders IPs busiest first, by address for ties
+func sortByRequests(ips []reportIP) {
+	sort.Slice(ips, func(i, j int) bool {
+		if ips[i].Requests != ips[j].Requests {
+			return ips[i].Requests > ips[j].Requests
+		}
+		return ips[i].Address < ips[j].Address
+	})
+}
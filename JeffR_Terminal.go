@@ -0,0 +1,163 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// what the text report can assume about where it's written: its width (0 if unbounded)
+// and whether ANSI color is welcome
+
+type terminal struct {
+	width int
+	color bool
+}
+
+const DEFAULT_TERMINAL_WIDTH = 80
+
+const ansiBold = "1"
+const ansiRed = "31"
+const ansiYellow = "33"
+const ansiCyan = "36"
+
+// detectTerminal sizes the report to COLUMNS or the tty; color only goes to a tty,
+// and not when NO_COLOR is set (https://no-color.org) or the terminal is dumb
+func detectTerminal(f *os.File) terminal {
+	out := terminal{}
+
+	tty := isTerminal(f)
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		out.width = columns
+	} else if tty {
+		out.width = ttyWidth()
+	}
+
+	_, noColor := os.LookupEnv("NO_COLOR")
+	out.color = tty && !noColor && os.Getenv("TERM") != "dumb"
+
+	return out
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// ttyWidth is the controlling terminal's width, or a guess if it won't say
+func ttyWidth() int {
+	if _, columns := ttySize(); columns > 0 {
+		return columns
+	}
+	return DEFAULT_TERMINAL_WIDTH
+}
+
+// ttySize asks stty for the controlling terminal's size; 0, 0 if it can't tell
+func ttySize() (int, int) {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return 0, 0
+	}
+	defer tty.Close()
+
+	size, err := stty(tty, "size")
+	if err != nil {
+		return 0, 0
+	}
+
+	fields := strings.Fields(size)
+	if len(fields) == 2 {
+		rows, rowsErr := strconv.Atoi(fields[0])
+		columns, columnsErr := strconv.Atoi(fields[1])
+		if rowsErr == nil && columnsErr == nil {
+			return rows, columns
+		}
+	}
+	return 0, 0
+}
+
+// stty runs stty against the terminal (the stdlib has no termios); returns its output
+func stty(tty *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+	output, err := cmd.Output()
+	return strings.TrimSpace(string(output)), err
+}
+
+// paint wraps text in the ANSI attributes, or leaves it be when color is off
+func (t terminal) paint(text string, attributes ...string) string {
+	if !t.color || len(attributes) == 0 {
+		return text
+	}
+	return "\x1b[" + strings.Join(attributes, ";") + "m" + text + "\x1b[0m"
+}
+
+// failures highlights (already padded) text when its count is non-zero
+func (t terminal) failures(count int64, text string) string {
+	if count > 0 {
+		return t.paint(text, ansiRed)
+	}
+	return text
+}
+
+// ratio highlights a #succeeded/#requests cell: red when nothing succeeded, yellow when some failed
+func (t terminal) ratio(succeeded int64, requests int64, text string) string {
+	switch {
+	case requests > 0 && succeeded == 0:
+		return t.paint(text, ansiRed)
+	case succeeded < requests:
+		return t.paint(text, ansiYellow)
+	}
+	return text
+}
+
+func (t terminal) severity(severity string, text string) string {
+	switch severity {
+	case "high":
+		return t.paint(text, ansiBold, ansiRed)
+	case "medium":
+		return t.paint(text, ansiYellow)
+	}
+	return t.paint(text, ansiCyan)
+}
+
+// padLeft and padRight size columns by runes, paths aren't always ASCII
+func padLeft(text string, width int) string {
+	return strings.Repeat(" ", max(width-utf8.RuneCountInString(text), 0)) + text
+}
+
+func padRight(text string, width int) string {
+	return text + strings.Repeat(" ", max(width-utf8.RuneCountInString(text), 0))
+}
+
+// columnWidth is the widest of a column's texts, and at least minimum
+func columnWidth(minimum int, texts ...string) int {
+	width := minimum
+	for _, text := range texts {
+		width = max(width, utf8.RuneCountInString(text))
+	}
+	return width
+}
+
+// pageColumns splits columns into runs that fit beside a leading label column;
+// every page gets at least one column however narrow the terminal
+func pageColumns(width int, labelWidth int, columnWidths []int) [][]int {
+	pages := make([][]int, 0)
+	page := make([]int, 0)
+	used := labelWidth
+	for i, columnWidth := range columnWidths {
+		if width > 0 && len(page) > 0 && used+2+columnWidth > width {
+			pages = append(pages, page)
+			page = make([]int, 0)
+			used = labelWidth
+		}
+		page = append(page, i)
+		used += 2 + columnWidth
+	}
+	if len(page) > 0 {
+		pages = append(pages, page)
+	}
+	return pages
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// a user-supplied report layout, see JeffR_Templates.md for the data model and helpers
+
+var templateSpec = ""
+
+// userTemplate is the parsed -template; text/template and html/template both satisfy it
+var userTemplate interface {
+	Execute(w io.Writer, data any) error
+}
+
+// userTemplateFuncs are the helpers available to -template layouts
+var userTemplateFuncs = map[string]any{
+	"toClock": func(seconds any) (string, error) {
+		value, err := toFloat(seconds)
+		if err != nil {
+			return "", err
+		}
+		return toClock(time.Duration(value * float64(time.Second))), nil
+	},
+	"seconds": func(seconds any) (string, error) {
+		value, err := toFloat(seconds)
+		if err != nil {
+			return "", err
+		}
+		return secondsString(value), nil
+	},
+	"percent": func(part any, whole any) (string, error) {
+		partVal, err := toFloat(part)
+		if err != nil {
+			return "", err
+		}
+		wholeVal, err := toFloat(whole)
+		if err != nil {
+			return "", err
+		}
+		if wholeVal == 0 {
+			return "0.0%", nil
+		}
+		return fmt.Sprintf("%.1f%%", 100*partVal/wholeVal), nil
+	},
+	"stamp": func(t time.Time) string {
+		return t.Format("2006-01-02 15:04:05")
+	},
+	"inc":     func(i int) int { return i + 1 },
+	"bucket":  bucketLabel,
+	"minutes": bucketMinutes,
+	"slop":    bucketSlop,
+	"sortBy": func(field string, list any) (any, error) {
+		return sortByField(field, list, false)
+	},
+	"sortDesc": func(field string, list any) (any, error) {
+		return sortByField(field, list, true)
+	},
+	"limit": func(n int, list any) (any, error) {
+		value := reflect.ValueOf(list)
+		if value.Kind() != reflect.Slice {
+			return nil, fmt.Errorf("%T is not a list", list)
+		}
+		return value.Slice(0, min(max(n, 0), value.Len())).Interface(), nil
+	},
+	"join":     strings.Join,
+	"upper":    strings.ToUpper,
+	"lower":    strings.ToLower,
+	"severity": detectorSeverity,
+}
+
+// loadUserTemplate parses the -template file; .html/.htm layouts (including *.html.tmpl)
+// render with html/template, which escapes what's taken from the log, anything else with text/template
+func loadUserTemplate(fileSpec string) bool {
+	text, err := os.ReadFile(fileSpec)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+
+	name := filepath.Base(fileSpec)
+	if isHtmlTemplate(name) {
+		userTemplate, err = htmltemplate.New(name).Funcs(htmltemplate.FuncMap(userTemplateFuncs)).Parse(string(text))
+	} else {
+		userTemplate, err = texttemplate.New(name).Funcs(texttemplate.FuncMap(userTemplateFuncs)).Parse(string(text))
+	}
+	if err != nil {
+		log.Println("ERR: template", err)
+		return false
+	}
+	return true
+}
+
+func isHtmlTemplate(name string) bool {
+	name = strings.TrimSuffix(strings.ToLower(name), ".tmpl")
+	return strings.HasSuffix(name, ".html") || strings.HasSuffix(name, ".htm")
+}
+
+// toFloat accepts any of the model's numbers (and durations, as seconds)
+func toFloat(number any) (float64, error) {
+	if duration, ok := number.(time.Duration); ok {
+		return duration.Seconds(), nil
+	}
+	value := reflect.ValueOf(number)
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), nil
+	}
+	return 0, fmt.Errorf("%v (%T) is not a number", number, number)
+}
+
+// sortByField returns a copy of a list of model records ordered by the named field;
+// ties keep their model order
+func sortByField(field string, list any, descending bool) (any, error) {
+	value := reflect.ValueOf(list)
+	if value.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("%T is not a list", list)
+	}
+	elemType := value.Type().Elem()
+	if elemType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%s has no fields", elemType)
+	}
+	fieldType, ok := elemType.FieldByName(field)
+	if !ok {
+		return nil, fmt.Errorf("%s has no field %s", elemType, field)
+	}
+
+	sorted := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+	reflect.Copy(sorted, value)
+
+	var less func(a reflect.Value, b reflect.Value) bool
+	switch fieldType.Type.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		less = func(a reflect.Value, b reflect.Value) bool { return a.Int() < b.Int() }
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		less = func(a reflect.Value, b reflect.Value) bool { return a.Uint() < b.Uint() }
+	case reflect.Float32, reflect.Float64:
+		less = func(a reflect.Value, b reflect.Value) bool { return a.Float() < b.Float() }
+	case reflect.String:
+		less = func(a reflect.Value, b reflect.Value) bool { return a.String() < b.String() }
+	case reflect.Bool:
+		less = func(a reflect.Value, b reflect.Value) bool { return !a.Bool() && b.Bool() }
+	default:
+		if fieldType.Type != reflect.TypeOf(time.Time{}) {
+			return nil, fmt.Errorf("can't order by %s.%s (%s)", elemType, field, fieldType.Type)
+		}
+		less = func(a reflect.Value, b reflect.Value) bool {
+			return a.Interface().(time.Time).Before(b.Interface().(time.Time))
+		}
+	}
+
+	index := fieldType.Index
+	sort.SliceStable(sorted.Interface(), func(i, j int) bool {
+		a := sorted.Index(i).FieldByIndex(index)
+		b := sorted.Index(j).FieldByIndex(index)
+		if descending {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+
+	return sorted.Interface(), nil
+}
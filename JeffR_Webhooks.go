@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -webhook: findings POSTed to Slack, Teams or any JSON endpoint - as they trip when
+// following, otherwise at the end of the run
+
+// set via -webhook (repeatable) and -webhookseverity; nil when no webhooks are configured
+var webhooks *webhookNotifier
+
+// the generic JSON webhook's body is signed with this secret when it's set
+const WEBHOOK_SECRET_ENV = "DETECTIVE_WEBHOOK_SECRET"
+
+const WEBHOOK_TIMEOUT = 10 * time.Second
+const WEBHOOK_ATTEMPTS = 4
+const WEBHOOK_RATE_LIMIT = 20 // posts per hook...
+const WEBHOOK_RATE_WINDOW = time.Minute
+const WEBHOOK_QUEUE = 256 // findings waiting per hook
+
+// doubled for each retry; a var so the tests needn't sit through it
+var webhookBackoff = time.Second
+
+// a finding from the same detector about the same subject within this long of the last is the same
+// campaign (e.g. a brute-force that pauses, so its detector re-arms, then resumes)
+const WEBHOOK_DEDUPE_WINDOW = time.Hour
+
+var webhookKinds = []string{"slack", "teams", "json"}
+
+// webhook is one hook, delivered to by its own goroutine from its own queue,
+// so a slow or failing hook doesn't hold up the others
+type webhook struct {
+	kind   string
+	url    string
+	posted []time.Time // within the rate window, oldest first
+	queue  chan webhookFinding
+}
+
+type webhookFinding struct {
+	source  string
+	finding Finding
+}
+
+type webhookNotifier struct {
+	hooks       []*webhook
+	minSeverity string
+	secret      []byte
+	client      *http.Client
+
+	lock        sync.Mutex           // notify and drain may be called from different goroutines
+	lastAlerted map[string]time.Time // by detector|subject
+	delivering  bool                 // the hooks' queues are open and their goroutines running
+	done        sync.WaitGroup
+}
+
+// addWebhook is -webhook [slack=|teams=|json=]<url>, json being the default
+func addWebhook(spec string) error {
+	kind, url, found := strings.Cut(spec, "=")
+	if !found || strings.Contains(kind, "/") {
+		kind, url = "json", spec
+	}
+	kind = strings.ToLower(kind)
+	if !slices.Contains(webhookKinds, kind) {
+		return fmt.Errorf("webhook kind %s s/b slack, teams or json", kind)
+	}
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return fmt.Errorf("webhook %s s/b an http(s) URL", url)
+	}
+
+	if webhooks == nil {
+		webhooks = &webhookNotifier{
+			minSeverity: "medium",
+			secret:      []byte(os.Getenv(WEBHOOK_SECRET_ENV)),
+			client:      &http.Client{Timeout: WEBHOOK_TIMEOUT},
+			lastAlerted: make(map[string]time.Time),
+		}
+	}
+	webhooks.hooks = append(webhooks.hooks, &webhook{kind: kind, url: url})
+	return nil
+}
+
+// notify queues the finding for every hook, unless it's below the severity threshold
+// or repeats a campaign already alerted; it doesn't wait for delivery
+func (n *webhookNotifier) notify(source string, finding Finding) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if severityRanks[finding.Severity] < severityRanks[n.minSeverity] {
+		return
+	}
+
+	key := finding.Detector + "|" + finding.Subject.String()
+	last, seen := n.lastAlerted[key]
+	if finding.At.After(last) {
+		n.lastAlerted[key] = finding.At // so an ongoing campaign stays one alert
+	}
+	// findings come by detector, not in time order, so a repeat may predate the alert
+	if seen && finding.At.Sub(last).Abs() < WEBHOOK_DEDUPE_WINDOW {
+		return
+	}
+
+	// a drain closed the last queues; a later report (e.g. the next file at the prompt) gets new ones
+	if !n.delivering {
+		for _, hook := range n.hooks {
+			hook.queue = make(chan webhookFinding, WEBHOOK_QUEUE)
+			n.done.Add(1)
+			go n.deliver(hook, hook.queue)
+		}
+		n.delivering = true
+	}
+
+	for _, hook := range n.hooks {
+		select {
+		case hook.queue <- webhookFinding{source, finding}:
+		default:
+			log.Println("ERR: webhook", hook.url, "queue full, dropping", finding.Detector, "finding for", finding.Subject)
+		}
+	}
+}
+
+// drain waits for everything queued to be delivered (or given up on); notify waits for it
+func (n *webhookNotifier) drain() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if !n.delivering {
+		return
+	}
+	for _, hook := range n.hooks {
+		close(hook.queue)
+	}
+	n.delivering = false
+	n.done.Wait()
+}
+
+func (n *webhookNotifier) deliver(hook *webhook, queue chan webhookFinding) {
+	defer n.done.Done()
+	for queued := range queue {
+		if err := n.post(hook, queued); err != nil {
+			log.Println("ERR: webhook", hook.url, "-", err)
+		}
+	}
+}
+
+// post sends one finding to one hook, waiting out its rate limit and retrying
+// failures that might pass (network errors, 429s and 5xxs) with backoff
+func (n *webhookNotifier) post(hook *webhook, queued webhookFinding) error {
+	body, err := json.Marshal(webhookPayload(hook.kind, queued))
+	if err != nil {
+		return err
+	}
+
+	hook.waitForRate()
+
+	backoff := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		retryAfter, err := n.attempt(hook, body)
+		if err == nil || retryAfter < 0 || attempt == WEBHOOK_ATTEMPTS {
+			return err
+		}
+		time.Sleep(max(backoff, retryAfter))
+		backoff *= 2
+	}
+}
+
+// attempt makes one POST; a negative retryAfter means don't bother retrying
+func (n *webhookNotifier) attempt(hook *webhook, body []byte) (time.Duration, error) {
+	request, err := http.NewRequest(http.MethodPost, hook.url, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if hook.kind == "json" && len(n.secret) > 0 {
+		stamp := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, n.secret)
+		mac.Write([]byte(stamp + "."))
+		mac.Write(body)
+		request.Header.Set("X-Detective-Timestamp", stamp)
+		request.Header.Set("X-Detective-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	response, err := n.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	response.Body.Close()
+	hook.posted = append(hook.posted, time.Now())
+
+	switch {
+	case response.StatusCode < 300:
+		return 0, nil
+	case response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500:
+		seconds, _ := strconv.Atoi(response.Header.Get("Retry-After"))
+		return time.Duration(seconds) * time.Second, fmt.Errorf("%s", response.Status)
+	}
+	return -1, fmt.Errorf("%s", response.Status)
+}
+
+// waitForRate holds off until the hook is under WEBHOOK_RATE_LIMIT posts per WEBHOOK_RATE_WINDOW
+func (h *webhook) waitForRate() {
+	for {
+		cutoff := time.Now().Add(-WEBHOOK_RATE_WINDOW)
+		expired := 0
+		for expired < len(h.posted) && h.posted[expired].Before(cutoff) {
+			expired++
+		}
+		h.posted = h.posted[expired:]
+		if len(h.posted) < WEBHOOK_RATE_LIMIT {
+			return
+		}
+		time.Sleep(h.posted[0].Sub(cutoff))
+	}
+}
+
+// webhookPayload is the finding as the kind of hook expects it:
+// Slack blocks, a Teams adaptive card, or plain JSON
+func webhookPayload(kind string, queued webhookFinding) any {
+	finding := queued.finding
+	severity := finding.Severity
+	subject := finding.Subject.String()
+	title := fmt.Sprintf("%s %s: %s", severityEmoji[severity], finding.Detector, subject)
+	at := finding.At.Format(time.RFC3339)
+	confidence := confidencePercent(finding.Confidence)
+
+	switch kind {
+	case "slack":
+		field := func(name string, value string) map[string]string {
+			return map[string]string{"type": "mrkdwn", "text": "*" + name + "*\n" + slackEscape.Replace(value)}
+		}
+		return map[string]any{
+			"text": slackEscape.Replace(title + " - " + finding.Detail),
+			"blocks": []any{
+				map[string]any{"type": "header", "text": map[string]string{"type": "plain_text", "text": title}},
+				map[string]any{"type": "section", "fields": []any{
+					field("Severity", severity), field("Confidence", confidence), field("Detector", finding.Detector),
+					field("Subject", subject), field("At", at)}},
+				map[string]any{"type": "section", "text": map[string]string{"type": "plain_text", "text": finding.Detail}},
+				map[string]any{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": "*Recommended action*\n" + slackEscape.Replace(finding.Action)}},
+				map[string]any{"type": "context", "elements": []any{
+					map[string]string{"type": "plain_text", "text": "Network Detective - " + queued.source}}},
+			},
+		}
+	case "teams":
+		fact := func(name string, value string) map[string]string {
+			return map[string]string{"title": name, "value": teamsEscape.Replace(value)}
+		}
+		color := map[string]string{"high": "Attention", "medium": "Warning"}[severity]
+		if len(color) == 0 {
+			color = "Default"
+		}
+		return map[string]any{
+			"type": "message",
+			"attachments": []any{map[string]any{
+				"contentType": "application/vnd.microsoft.card.adaptive",
+				"content": map[string]any{
+					"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+					"type":    "AdaptiveCard",
+					"version": "1.4",
+					"body": []any{
+						map[string]any{"type": "TextBlock", "text": teamsEscape.Replace(title), "size": "Large", "weight": "Bolder", "color": color, "wrap": true},
+						map[string]any{"type": "FactSet", "facts": []any{
+							fact("Severity", severity), fact("Confidence", confidence), fact("Detector", finding.Detector),
+							fact("Subject", subject), fact("At", at), fact("Log", queued.source)}},
+						map[string]any{"type": "TextBlock", "text": teamsEscape.Replace(finding.Detail), "wrap": true},
+						map[string]any{"type": "TextBlock", "text": "Recommended action: " + teamsEscape.Replace(finding.Action), "wrap": true},
+					},
+				},
+			}},
+		}
+	}
+	return map[string]any{
+		"source":     queued.source,
+		"detector":   finding.Detector,
+		"subject":    subject,
+		"ip":         finding.Subject.IP,
+		"path":       finding.Subject.Path,
+		"severity":   severity,
+		"confidence": finding.Confidence,
+		"at":         at,
+		"detail":     finding.Detail,
+		"action":     finding.Action,
+	}
+}
+
+// the paths and methods in findings are the client's to choose, so they're escaped
+// rather than let through as Slack mrkdwn (links, mentions) or Teams markdown
+var (
+	slackEscape = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	teamsEscape = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "~", `\~`, "`", "\\`", "[", `\[`, "]", `\]`,
+		"(", `\(`, ")", `\)`, "#", `\#`, ">", `\>`, "<", `\<`)
+)
+
+var severityEmoji = map[string]string{"high": "🔴", "medium": "🟠", "low": "🟡"}
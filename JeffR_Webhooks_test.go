@@ -0,0 +1,378 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hookServer records what's POSTed to it, answering each with the next of statuses
+// (then 200s); a status can carry a Retry-After
+type hookServer struct {
+	*httptest.Server
+	lock     sync.Mutex
+	statuses []hookReply
+	posts    []hookPost
+	block    chan struct{} // when set, requests wait for it to close
+}
+
+type hookReply struct {
+	status     int
+	retryAfter string
+}
+
+type hookPost struct {
+	at     time.Time
+	header http.Header
+	body   []byte
+}
+
+func newHookServer(t *testing.T, statuses ...hookReply) *hookServer {
+	hook := &hookServer{statuses: statuses}
+	hook.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hook.block != nil {
+			<-hook.block
+		}
+		body, _ := io.ReadAll(r.Body)
+		hook.lock.Lock()
+		defer hook.lock.Unlock()
+		hook.posts = append(hook.posts, hookPost{time.Now(), r.Header.Clone(), body})
+		reply := hookReply{status: http.StatusOK}
+		if len(hook.statuses) > 0 {
+			reply, hook.statuses = hook.statuses[0], hook.statuses[1:]
+		}
+		if len(reply.retryAfter) > 0 {
+			w.Header().Set("Retry-After", reply.retryAfter)
+		}
+		w.WriteHeader(reply.status)
+	}))
+	t.Cleanup(hook.Close)
+	return hook
+}
+
+func (h *hookServer) received() []hookPost {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return append([]hookPost(nil), h.posts...)
+}
+
+// newTestNotifier configures the -webhook specs from scratch, without waiting out real backoffs
+func newTestNotifier(t *testing.T, specs ...string) *webhookNotifier {
+	t.Helper()
+	was := webhookBackoff
+	webhookBackoff = time.Millisecond
+	t.Cleanup(func() { webhooks, webhookBackoff = nil, was })
+
+	webhooks = nil
+	for _, spec := range specs {
+		if err := addWebhook(spec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return webhooks
+}
+
+func testFinding(at time.Time) Finding {
+	return Finding{Detector: "brute-force", Severity: "high", Confidence: 0.8, Subject: findingSubject{IP: "10.0.0.9"},
+		At: at, Detail: "6 failed logins in 25s", Action: "Block 10.0.0.9"}
+}
+
+var findingTime = time.Date(2024, time.March, 1, 10, 0, 30, 0, time.UTC)
+
+func decodePost(t *testing.T, post hookPost) map[string]any {
+	t.Helper()
+	if kind := post.header.Get("Content-Type"); kind != "application/json" {
+		t.Errorf("Content-Type %s", kind)
+	}
+	var payload map[string]any
+	if err := json.Unmarshal(post.body, &payload); err != nil {
+		t.Fatalf("%v: %s", err, post.body)
+	}
+	return payload
+}
+
+func TestWebhookPayloads(t *testing.T) {
+	slack, teams, generic := newHookServer(t), newHookServer(t), newHookServer(t)
+	notifier := newTestNotifier(t, "slack="+slack.URL, "teams="+teams.URL, generic.URL)
+	notifier.notify("app.log", testFinding(findingTime))
+	notifier.drain()
+
+	title := "🔴 brute-force: 10.0.0.9"
+	for name, hook := range map[string]*hookServer{"slack": slack, "teams": teams, "json": generic} {
+		if posts := hook.received(); len(posts) != 1 {
+			t.Fatalf("%s: %d posts, expected 1", name, len(posts))
+		}
+	}
+
+	payload := decodePost(t, slack.received()[0])
+	if payload["text"] != title+" - 6 failed logins in 25s" {
+		t.Errorf("slack text %v", payload["text"])
+	}
+	blocks := payload["blocks"].([]any)
+	if header := blocks[0].(map[string]any); header["type"] != "header" || header["text"].(map[string]any)["text"] != title {
+		t.Errorf("slack header block %v", header)
+	}
+
+	payload = decodePost(t, teams.received()[0])
+	attachment := payload["attachments"].([]any)[0].(map[string]any)
+	card := attachment["content"].(map[string]any)
+	if payload["type"] != "message" || attachment["contentType"] != "application/vnd.microsoft.card.adaptive" || card["type"] != "AdaptiveCard" {
+		t.Errorf("teams payload %v", payload)
+	}
+	if heading := card["body"].([]any)[0].(map[string]any); heading["text"] != title || heading["color"] != "Attention" {
+		t.Errorf("teams heading %v", heading)
+	}
+
+	payload = decodePost(t, generic.received()[0])
+	want := map[string]any{"source": "app.log", "detector": "brute-force", "subject": "10.0.0.9", "ip": "10.0.0.9", "path": "",
+		"severity": "high", "confidence": 0.8, "at": "2024-03-01T10:00:30Z", "detail": "6 failed logins in 25s", "action": "Block 10.0.0.9"}
+	for key, value := range want {
+		if payload[key] != value {
+			t.Errorf("json %s=%v s/b %v", key, payload[key], value)
+		}
+	}
+	if len(payload) != len(want) {
+		t.Errorf("json payload %v s/b %v", payload, want)
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	t.Setenv(WEBHOOK_SECRET_ENV, "s3cret")
+	slack, generic := newHookServer(t), newHookServer(t)
+	notifier := newTestNotifier(t, "slack="+slack.URL, "json="+generic.URL)
+	notifier.notify("app.log", testFinding(findingTime))
+	notifier.drain()
+
+	post := generic.received()[0]
+	stamp := post.header.Get("X-Detective-Timestamp")
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(stamp + "."))
+	mac.Write(post.body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); post.header.Get("X-Detective-Signature") != want {
+		t.Errorf("signature %s s/b %s", post.header.Get("X-Detective-Signature"), want)
+	}
+	if seconds, err := strconv.ParseInt(stamp, 10, 64); err != nil || time.Since(time.Unix(seconds, 0)).Abs() > time.Minute {
+		t.Errorf("timestamp %q s/b the Unix time it was signed at", stamp)
+	}
+
+	// only the generic hook is signed
+	if signature := slack.received()[0].header.Get("X-Detective-Signature"); len(signature) > 0 {
+		t.Errorf("slack post signed %s", signature)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	hook := newHookServer(t, hookReply{status: http.StatusServiceUnavailable},
+		hookReply{status: http.StatusTooManyRequests, retryAfter: "1"})
+	notifier := newTestNotifier(t, hook.URL)
+	notifier.notify("app.log", testFinding(findingTime))
+	notifier.drain()
+
+	posts := hook.received()
+	if len(posts) != 3 {
+		t.Fatalf("%d attempts, expected 3: 503, 429, then 200", len(posts))
+	}
+	if waited := posts[2].at.Sub(posts[1].at); waited < time.Second {
+		t.Errorf("retried %v after a 429 with Retry-After: 1", waited)
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	failing := make([]hookReply, 0)
+	for range WEBHOOK_ATTEMPTS + 1 {
+		failing = append(failing, hookReply{status: http.StatusInternalServerError})
+	}
+	hook := newHookServer(t, failing...)
+	notifier := newTestNotifier(t, hook.URL)
+	notifier.notify("app.log", testFinding(findingTime))
+	notifier.drain()
+
+	if posts := hook.received(); len(posts) != WEBHOOK_ATTEMPTS {
+		t.Errorf("%d attempts, expected %d", len(posts), WEBHOOK_ATTEMPTS)
+	}
+}
+
+func TestWebhookNoRetryOnClientError(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound} {
+		hook := newHookServer(t, hookReply{status: status})
+		notifier := newTestNotifier(t, hook.URL)
+		notifier.notify("app.log", testFinding(findingTime))
+		notifier.drain()
+
+		if posts := hook.received(); len(posts) != 1 {
+			t.Errorf("%d: %d attempts, expected 1", status, len(posts))
+		}
+	}
+}
+
+func TestWebhookDedupe(t *testing.T) {
+	hook := newHookServer(t)
+	notifier := newTestNotifier(t, hook.URL)
+
+	campaign := testFinding(findingTime)
+	notifier.notify("app.log", campaign)
+	campaign.At = findingTime.Add(30 * time.Minute) // re-armed and tripped again: the same campaign
+	notifier.notify("app.log", campaign)
+	campaign.At = findingTime.Add(80 * time.Minute) // within the hour of the last, so still the same
+	notifier.notify("app.log", campaign)
+	campaign.At = findingTime.Add(3 * time.Hour) // a new one
+	notifier.notify("app.log", campaign)
+
+	other := testFinding(findingTime)
+	other.Subject.IP = "10.0.0.10"
+	notifier.notify("app.log", other)
+	minor := testFinding(findingTime)
+	minor.Severity = "low" // under the default medium threshold
+	minor.Subject.IP = "10.0.0.11"
+	notifier.notify("app.log", minor)
+	notifier.drain()
+
+	subjects := make([]string, 0)
+	for _, post := range hook.received() {
+		subjects = append(subjects, decodePost(t, post)["subject"].(string)+" "+decodePost(t, post)["at"].(string))
+	}
+	want := []string{"10.0.0.9 2024-03-01T10:00:30Z", "10.0.0.9 2024-03-01T13:00:30Z", "10.0.0.10 2024-03-01T10:00:30Z"}
+	if len(subjects) != len(want) {
+		t.Fatalf("posted %v, expected %v", subjects, want)
+	}
+	for i := range want {
+		if subjects[i] != want[i] {
+			t.Errorf("posted %v, expected %v", subjects, want)
+			break
+		}
+	}
+}
+
+func TestWebhookSlowHookDoesntBlockOthers(t *testing.T) {
+	slow, fast := newHookServer(t), newHookServer(t)
+	slow.block = make(chan struct{})
+	notifier := newTestNotifier(t, slow.URL, fast.URL)
+	notifier.notify("app.log", testFinding(findingTime))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(fast.received()) == 0 {
+		if time.Now().After(deadline) {
+			close(slow.block)
+			t.Fatal("the fast hook waited on the slow one")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(slow.block)
+	notifier.drain()
+	if len(slow.received()) != 1 {
+		t.Error("the slow hook didn't get its post")
+	}
+}
+
+// each report drains the notifier; the next file at the prompt reports through it again
+func TestWebhookNotifyAfterDrain(t *testing.T) {
+	hook := newHookServer(t)
+	notifier := newTestNotifier(t, hook.URL)
+	notifier.drain() // nothing queued yet
+
+	notifier.notify("first.log", testFinding(findingTime))
+	notifier.drain()
+	notifier.drain()
+	later := testFinding(findingTime.Add(24 * time.Hour))
+	notifier.notify("second.log", later)
+	notifier.drain()
+
+	if posts := hook.received(); len(posts) != 2 {
+		t.Errorf("%d posts, expected 2", len(posts))
+	}
+}
+
+// findings come by detector, not in time order: one an hour before the alert is as much
+// the same campaign as one an hour after
+func TestWebhookDedupeOutOfOrder(t *testing.T) {
+	hook := newHookServer(t)
+	notifier := newTestNotifier(t, hook.URL)
+	for _, at := range []time.Duration{2 * time.Hour, 90 * time.Minute, 0, 150 * time.Minute} {
+		notifier.notify("app.log", testFinding(findingTime.Add(at)))
+	}
+	notifier.drain()
+
+	posted := make([]string, 0)
+	for _, post := range hook.received() {
+		posted = append(posted, decodePost(t, post)["at"].(string))
+	}
+	if want := []string{"2024-03-01T12:00:30Z", "2024-03-01T10:00:30Z"}; !slices.Equal(posted, want) {
+		t.Errorf("posted %v, expected %v", posted, want)
+	}
+}
+
+// a client picks its path, so one can't mention @channel or slip a link into the alert
+func TestWebhookPayloadsEscapeMarkup(t *testing.T) {
+	slack, teams := newHookServer(t), newHookServer(t)
+	notifier := newTestNotifier(t, "slack="+slack.URL, "teams="+teams.URL)
+	path := "/<!channel>&<http://evil|click>*[x](http://evil)_"
+	finding := testFinding(findingTime)
+	finding.Detector, finding.Subject.Path = "failing-path", path
+	finding.Detail, finding.Action = "3 failures at "+path, "Check "+path
+	notifier.notify("app.log", finding)
+	notifier.drain()
+
+	// Slack: every mrkdwn text has the path with &, < and > escaped
+	payload := decodePost(t, slack.received()[0])
+	texts := []string{payload["text"].(string)}
+	for _, block := range payload["blocks"].([]any) {
+		block := block.(map[string]any)
+		if text, ok := block["text"].(map[string]any); ok && text["type"] == "mrkdwn" {
+			texts = append(texts, text["text"].(string))
+		}
+		if fields, ok := block["fields"].([]any); ok {
+			for _, field := range fields {
+				texts = append(texts, field.(map[string]any)["text"].(string))
+			}
+		}
+	}
+	slackPath := "/&lt;!channel&gt;&amp;&lt;http://evil|click&gt;*[x](http://evil)_"
+	mentions := 0
+	for _, text := range texts {
+		if strings.ContainsAny(text, "<>") {
+			t.Errorf("slack mrkdwn %q isn't escaped", text)
+		}
+		mentions += strings.Count(text, slackPath)
+	}
+	if mentions != 4 { // text, subject field, detail and action
+		t.Errorf("slack path %s appears %d times, expected 4 in %q", slackPath, mentions, texts)
+	}
+
+	// Teams: the TextBlocks and facts have the markdown escaped
+	payload = decodePost(t, teams.received()[0])
+	card := payload["attachments"].([]any)[0].(map[string]any)["content"].(map[string]any)
+	teamsPath := `/\<!channel\>&\<http://evil|click\>\*\[x\]\(http://evil\)\_`
+	texts = texts[:0]
+	for _, item := range card["body"].([]any) {
+		item := item.(map[string]any)
+		if text, ok := item["text"].(string); ok {
+			texts = append(texts, text)
+		}
+		if facts, ok := item["facts"].([]any); ok {
+			for _, fact := range facts {
+				texts = append(texts, fact.(map[string]any)["value"].(string))
+			}
+		}
+	}
+	mentions = 0
+	for _, text := range texts {
+		if strings.Contains(strings.ReplaceAll(text, teamsPath, ""), "[x]") {
+			t.Errorf("teams text %q isn't escaped", text)
+		}
+		mentions += strings.Count(text, teamsPath)
+	}
+	if mentions != 4 { // title, subject fact, detail and action
+		t.Errorf("teams path %s appears %d times, expected 4 in %q", teamsPath, mentions, texts)
+	}
+}
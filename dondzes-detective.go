@@ -1,8 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"encoding/csv"
+	"flag"
 	"fmt"
+	"html/template"
 	"os"
 	"sort"
 //	"strconv"
@@ -28,6 +31,49 @@ type ThreatReport map[string]map[string]int
 // StatusCodesByIP represents status codes and their count by IP address
 type StatusCodesByIP map[string]map[string]int
 
+// Finding represents one thing the analysis turned up, ranked most severe, then most certain, first
+type Finding struct {
+	Detector   string
+	Severity   string  // high, medium or low
+	Confidence float64 // 0-1
+	IP         string
+	From       time.Time
+	To         time.Time
+	Detail     string
+	Evidence   []LogEvent // the latest events behind it
+	Action     string
+}
+
+// Evidence kept per finding, failed logins from one IP that make a brute-force, and
+// events behind a finding at which its evidence counts as half convincing
+const (
+	FindingEvidence    = 10
+	BruteForceLogins   = 5
+	ConfidenceEvidence = 3
+)
+
+var severityRanks = map[string]int{"low": 1, "medium": 2, "high": 3}
+
+// UnusualStatuses are the statuses counted as an IP's errors (set via -unusual)
+var UnusualStatuses = []string{"401", "403", "404", "500", "503"}
+
+// Exit codes, as the other detective's, so scripts and CI pipelines can act on the outcome
+const (
+	ExitOK          = 0 // analyzed, nothing at or over the gating thresholds
+	ExitGateFailed  = 1 // findings at or over --fail-on, or a --max-* threshold exceeded
+	ExitUsage       = 2 // bad arguments (also what the flag package exits with)
+	ExitInvalidLog  = 3 // the log couldn't be read, or isn't a traffic log
+	ExitNoData      = 4 // the log held no events
+	ExitExportError = 5 // the analysis ran but the HTML report couldn't be written
+)
+
+// Gates fail the run, for CI; negative thresholds are off
+type Gates struct {
+	FailOn          string // a severity
+	MaxFailedLogins int    // per IP
+	MaxFindings     int
+}
+
 // ParseLogFile parses the log file and extracts network events
 func ParseLogFile(filePath string) (LogData, error) {
 	logData := make(LogData)
@@ -38,6 +84,7 @@ func ParseLogFile(filePath string) (LogData, error) {
 	defer file.Close()
 
 	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 4
 	for {
 		row, err := reader.Read()
 		if err != nil {
@@ -66,22 +113,21 @@ func ParseLogFile(filePath string) (LogData, error) {
 	return logData, nil
 }
 
-// AnalyzeLog analyzes the log data and generates threat report
-func AnalyzeLog(logData LogData) (ThreatReport, StatusCodesByIP) {
+// AnalyzeLog analyzes the log data and generates threat report, with its findings ranked
+func AnalyzeLog(logData LogData) (ThreatReport, StatusCodesByIP, []Finding) {
 	threatReport := make(ThreatReport)
 	statusCodesByIP := make(StatusCodesByIP)
+	var findings []Finding
 
 	for ip, events := range logData {
 		totalRequests := len(events)
-		failedLogins := 0
-		unusualActivity := false
+		var failedLogins, errors []LogEvent
 
 		for _, event := range events {
 			if strings.HasPrefix(event.Action, "POST /login") && event.Status != "200" {
-				failedLogins++
-			}
-			if contains([]string{"401", "403", "404", "500", "503"}, event.Status) {
-				unusualActivity = true
+				failedLogins = append(failedLogins, event)
+			} else if contains(UnusualStatuses, event.Status) {
+				errors = append(errors, event)
 			}
 
 			if _, ok := statusCodesByIP[ip]; !ok {
@@ -91,13 +137,62 @@ func AnalyzeLog(logData LogData) (ThreatReport, StatusCodesByIP) {
 		}
 
 		threatReport[ip] = map[string]int{
-			"Total Requests":       totalRequests,
-			"Failed Login Attempts": failedLogins,
-			"Unusual Activity":     boolToInt(unusualActivity),
+			"Total Requests":        totalRequests,
+			"Failed Login Attempts": len(failedLogins),
+		}
+
+		switch {
+		case len(failedLogins) >= BruteForceLogins:
+			findings = append(findings, newFinding("brute-force", "high", findingConfidence(len(failedLogins), totalRequests), ip, failedLogins,
+				fmt.Sprintf("%d failed logins of %d requests", len(failedLogins), totalRequests),
+				"Block or rate limit the IP, and check the accounts it targeted for compromise"))
+		case len(failedLogins) > 0:
+			findings = append(findings, newFinding("failed-logins", "medium", findingConfidence(len(failedLogins), totalRequests), ip, failedLogins,
+				fmt.Sprintf("%d failed logins of %d requests", len(failedLogins), totalRequests),
+				"Watch the IP for more attempts, and confirm the account owner made them"))
+		}
+		if len(errors) > 0 {
+			findings = append(findings, newFinding("errors", "low", findingConfidence(len(errors), totalRequests), ip, errors,
+				fmt.Sprintf("%d error responses of %d requests", len(errors), totalRequests),
+				"Find out why the IP keeps drawing errors: a broken client, or probing"))
 		}
 	}
 
-	return threatReport, statusCodesByIP
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Severity != findings[j].Severity {
+			return severityRanks[findings[i].Severity] > severityRanks[findings[j].Severity]
+		}
+		if findings[i].Confidence != findings[j].Confidence {
+			return findings[i].Confidence > findings[j].Confidence
+		}
+		return findings[i].IP < findings[j].IP
+	})
+
+	return threatReport, statusCodesByIP, findings
+}
+
+// findingConfidence is how sure a finding is, from its evidence: the share of the IP's requests
+// behind it, discounted while there are few of them - a lone error reads as weak, not a coin toss
+func findingConfidence(evidence int, totalRequests int) float64 {
+	share := float64(evidence) / float64(totalRequests)
+	return share * float64(evidence) / float64(evidence+ConfidenceEvidence)
+}
+
+// newFinding builds a finding, its time range spanning its evidence
+func newFinding(detector, severity string, confidence float64, ip string, events []LogEvent, detail, action string) Finding {
+	sorted := append([]LogEvent(nil), events...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Timestamp.Before(sorted[j].Timestamp) })
+	return Finding{
+		Detector:   detector,
+		Severity:   severity,
+		Confidence: confidence,
+		IP:         ip,
+		From:       sorted[0].Timestamp,
+		To:         sorted[len(sorted)-1].Timestamp,
+		Detail:     detail,
+		Evidence:   sorted[max(0, len(sorted)-FindingEvidence):],
+		Action:     action,
+	}
 }
 
 func contains(arr []string, str string) bool {
@@ -109,15 +204,54 @@ func contains(arr []string, str string) bool {
 	return false
 }
 
-func boolToInt(b bool) int {
-	if b {
-		return 1
+// ipActivity is one row of the HTML report's activity table
+type ipActivity struct {
+	IP                  string
+	TotalRequests       int
+	FailedLoginAttempts int
+}
+
+// findingRow is one row of the HTML report's threat table
+type findingRow struct {
+	Finding
+	Confidence  string
+	From        string
+	To          string
+	StatusCodes string
+}
+
+var threatReportTemplate = template.Must(template.New("threat_report").Parse(`<html><head><title>Network Log Analysis Report</title></head><body>
+<h1>Network Activity</h1>
+<p>Peak Activity: {{.PeakActivity}}</p>
+<p>Low Activity: {{.LowActivity}}</p>
+<h2 style='text-decoration: underline;'><a href='#' onclick='toggleTable("all_activity")' style='cursor: pointer;'>All Activity</a></h2>
+<div id='all_activity' style='display: none;'>
+<table border='1'><tr><th>IP Address</th><th>Total Requests</th><th>Failed Login Attempts</th></tr>
+{{range .AllActivity}}<tr><td>{{.IP}}</td><td>{{.TotalRequests}}</td><td>{{.FailedLoginAttempts}}</td></tr>
+{{end}}</table>
+</div>
+<h2 style='text-decoration: underline;'><a href='#' onclick='toggleTable("threats")' style='cursor: pointer;'>Comprehensive Threat Report</a></h2>
+<div id='threats' style='display: none;'>
+<table border='1'><tr><th>Severity</th><th>Confidence</th><th>Detector</th><th>IP Address</th><th>From</th><th>To</th><th>Detail</th><th>Status Code Counts</th><th>Recommended Action</th><th>Evidence</th></tr>
+{{range .Findings}}<tr><td>{{.Severity}}</td><td>{{.Confidence}}</td><td>{{.Detector}}</td><td>{{.IP}}</td><td>{{.From}}</td><td>{{.To}}</td><td>{{.Detail}}</td><td>{{.StatusCodes}}</td><td>{{.Action}}</td>
+<td>{{range .Evidence}}{{.Timestamp.Format "2006-01-02 15:04:05"}} {{.Action}} {{.Status}}<br>{{end}}</td></tr>
+{{end}}</table>
+</div>
+<script>
+function toggleTable(id) {
+	var x = document.getElementById(id);
+	if (x.style.display === 'none') {
+		x.style.display = 'block';
+	} else {
+		x.style.display = 'none';
 	}
-	return 0
 }
+</script>
+</body></html>
+`))
 
 // GenerateThreatReport generates the HTML threat report
-func GenerateThreatReport(threatReport ThreatReport, peakActivity, lowActivity time.Time, statusCodesByIP StatusCodesByIP) {
+func GenerateThreatReport(threatReport ThreatReport, findings []Finding, peakActivity, lowActivity time.Time, statusCodesByIP StatusCodesByIP) error {
 	fmt.Println("Threat Report:")
 	for ip, data := range threatReport {
 		fmt.Printf("IP Address: %s\n", ip)
@@ -127,13 +261,12 @@ func GenerateThreatReport(threatReport ThreatReport, peakActivity, lowActivity t
 		fmt.Println()
 	}
 
-	// Generate HTML threat report
-	htmlReport := "<html><head><title>Network Log Analysis Report</title></head><body>"
-
-	// Network Activity Section
-	htmlReport += "<h1>Network Activity</h1>"
-	htmlReport += "<p>Peak Activity: " + peakActivity.Format("2006-01-02 15:04:05") + "</p>"
-	htmlReport += "<p>Low Activity: " + lowActivity.Format("2006-01-02 15:04:05") + "</p>"
+	fmt.Println("Comprehensive Threat Report:")
+	for _, finding := range findings {
+		fmt.Printf("%s %.0f%% %s %s: %s\n  -> %s\n", strings.ToUpper(finding.Severity), finding.Confidence*100,
+			finding.Detector, finding.IP, finding.Detail, finding.Action)
+	}
+	fmt.Println()
 
 	// Sort IP addresses
 	var sortedIPs []string
@@ -142,66 +275,98 @@ func GenerateThreatReport(threatReport ThreatReport, peakActivity, lowActivity t
 	}
 	sort.Strings(sortedIPs)
 
-	// Generate table for total requests and failed logins by IP address
-	htmlReport += "<h2 style='text-decoration: underline;'><a href='#' onclick='toggleTable(\"all_activity\")' style='cursor: pointer;'>All Activity</a></h2>"
-	htmlReport += "<div id='all_activity' style='display: none;'>"
-	htmlReport += "<table border='1'><tr><th>IP Address</th><th>Total Requests</th><th>Failed Login Attempts</th></tr>"
+	// Gather the rows for the tables, status codes in order
+	var allActivity []ipActivity
 	for _, ip := range sortedIPs {
 		data := threatReport[ip]
-		htmlReport += fmt.Sprintf("<tr><td>%s</td><td>%d</td><td>%d</td></tr>", ip, data["Total Requests"], data["Failed Login Attempts"])
+		allActivity = append(allActivity, ipActivity{ip, data["Total Requests"], data["Failed Login Attempts"]})
 	}
-	htmlReport += "</table>"
-	htmlReport += "</div>"
 
-	// Generate table for unusual activity
-	htmlReport += "<h2 style='text-decoration: underline;'><a href='#' onclick='toggleTable(\"unusual_activity\")' style='cursor: pointer;'>Unusual Activity</a></h2>"
-	htmlReport += "<div id='unusual_activity' style='display: none;'>"
-	htmlReport += "<table border='1'><tr><th>IP Address</th><th>Total Requests</th><th>Failed Login Attempts</th><th>Status Code Counts</th></tr>"
-	for _, ip := range sortedIPs {
-		data := threatReport[ip]
-		if data["Unusual Activity"] == 1 {
-			statusCodes := statusCodesByIP[ip]
-			statusCodesString := ""
-			for code, count := range statusCodes {
-				statusCodesString += fmt.Sprintf("%s: %d, ", code, count)
-			}
-			statusCodesString = strings.TrimSuffix(statusCodesString, ", ")
-			htmlReport += fmt.Sprintf("<tr><td>%s</td><td>%d</td><td>%d</td><td>%s</td></tr>", ip, data["Total Requests"], data["Failed Login Attempts"], statusCodesString)
+	var threats []findingRow
+	for _, finding := range findings {
+		statusCodes := statusCodesByIP[finding.IP]
+		var codes []string
+		for code := range statusCodes {
+			codes = append(codes, code)
 		}
+		sort.Strings(codes)
+		var counts []string
+		for _, code := range codes {
+			counts = append(counts, fmt.Sprintf("%s: %d", code, statusCodes[code]))
+		}
+		threats = append(threats, findingRow{finding, fmt.Sprintf("%.0f%%", finding.Confidence*100),
+			finding.From.Format("2006-01-02 15:04:05"), finding.To.Format("2006-01-02 15:04:05"), strings.Join(counts, ", ")})
+	}
+
+	// Generate HTML threat report; the template escapes everything taken from the log
+	var htmlReport bytes.Buffer
+	err := threatReportTemplate.Execute(&htmlReport, map[string]interface{}{
+		"PeakActivity": peakActivity.Format("2006-01-02 15:04:05"),
+		"LowActivity":  lowActivity.Format("2006-01-02 15:04:05"),
+		"AllActivity":  allActivity,
+		"Findings":     threats,
+	})
+	if err != nil {
+		fmt.Println("Error generating HTML report:", err)
+		return err
 	}
-	htmlReport += "</table>"
-	htmlReport += "</div>"
-
-	htmlReport += "</body></html>"
-
-	// JavaScript function to toggle table visibility
-	htmlReport += "<script>"
-	htmlReport += "function toggleTable(id) {"
-	htmlReport += "var x = document.getElementById(id);"
-	htmlReport += "if (x.style.display === 'none') {"
-	htmlReport += "x.style.display = 'block';"
-	htmlReport += "} else {"
-	htmlReport += "x.style.display = 'none';"
-	htmlReport += "}"
-	htmlReport += "}"
-	htmlReport += "</script>"
 
 	// Write HTML report to file
 	htmlFileName := "threat_report.html"
 	htmlFile, err := os.Create(htmlFileName)
 	if err != nil {
 		fmt.Println("Error creating HTML file:", err)
-		return
+		return err
 	}
 	defer htmlFile.Close()
 
-	_, err = htmlFile.WriteString(htmlReport)
+	_, err = htmlFile.Write(htmlReport.Bytes())
 	if err != nil {
 		fmt.Println("Error writing to HTML file:", err)
-		return
+		return err
 	}
 
 	fmt.Println("See HTML report generated:", htmlFileName)
+	return nil
+}
+
+// CheckGates reports each gating threshold the analysis breaches; false if any was
+func CheckGates(threatReport ThreatReport, findings []Finding, gates Gates) bool {
+	var breaches []string
+
+	if len(gates.FailOn) > 0 {
+		at := 0
+		for _, finding := range findings {
+			if severityRanks[finding.Severity] >= severityRanks[gates.FailOn] {
+				at++
+			}
+		}
+		if at > 0 {
+			breaches = append(breaches, fmt.Sprintf("%d findings at or above %s severity", at, gates.FailOn))
+		}
+	}
+
+	if gates.MaxFindings >= 0 && len(findings) > gates.MaxFindings {
+		breaches = append(breaches, fmt.Sprintf("%d findings, over the maximum of %d", len(findings), gates.MaxFindings))
+	}
+
+	if gates.MaxFailedLogins >= 0 {
+		var ips []string
+		for ip := range threatReport {
+			ips = append(ips, ip)
+		}
+		sort.Strings(ips)
+		for _, ip := range ips {
+			if failed := threatReport[ip]["Failed Login Attempts"]; failed > gates.MaxFailedLogins {
+				breaches = append(breaches, fmt.Sprintf("%s made %d failed logins, over the maximum of %d", ip, failed, gates.MaxFailedLogins))
+			}
+		}
+	}
+
+	for _, breach := range breaches {
+		fmt.Fprintln(os.Stderr, "GATE FAILED:", breach)
+	}
+	return len(breaches) == 0
 }
 
 // FindPeakAndLowActivityTimestamps finds the peak and low activity timestamps
@@ -239,18 +404,56 @@ func FindPeakAndLowActivityTimestamps(logData LogData) (time.Time, time.Time) {
 }
 
 func main() {
+	var gates Gates
+	flag.StringVar(&gates.FailOn, "fail-on", "", "exit 1 when a finding is at or above this severity: low, medium or high")
+	flag.IntVar(&gates.MaxFailedLogins, "max-failed-logins", -1, "exit 1 when an IP makes more failed logins than this")
+	flag.IntVar(&gates.MaxFindings, "max-findings", -1, "exit 1 when there are more findings than this")
+	unusual := flag.String("unusual", strings.Join(UnusualStatuses, ","), "comma-separated statuses counted as errors")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: dondzes-detective [flags] [log file, default network_log.txt]")
+		flag.PrintDefaults()
+		fmt.Fprintln(flag.CommandLine.Output(), "Exits 0 when all is well, 1 when a gate fails, 2 for bad arguments, 3 when the log can't be read or parsed,")
+		fmt.Fprintln(flag.CommandLine.Output(), "  4 when it holds no events, 5 when the HTML report can't be written")
+	}
+	flag.Parse()
+
+	if _, ok := severityRanks[gates.FailOn]; (len(gates.FailOn) > 0 && !ok) || flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(ExitUsage)
+	}
+	UnusualStatuses = nil
+	for _, status := range strings.Split(*unusual, ",") {
+		if status = strings.TrimSpace(status); len(status) > 0 {
+			UnusualStatuses = append(UnusualStatuses, status)
+		}
+	}
 	filePath := "network_log.txt"
+	if flag.NArg() == 1 {
+		filePath = flag.Arg(0)
+	}
+
 	logData, err := ParseLogFile(filePath)
 	if err != nil {
 		fmt.Println("Error:", err)
-		return
+		os.Exit(ExitInvalidLog)
+	}
+	if len(logData) == 0 {
+		fmt.Println("Error: no events in", filePath)
+		os.Exit(ExitNoData)
 	}
 
-	threatReport, statusCodesByIP := AnalyzeLog(logData)
+	threatReport, statusCodesByIP, findings := AnalyzeLog(logData)
 
 	// Find peak and low activity timestamps
 	peakActivity, lowActivity := FindPeakAndLowActivityTimestamps(logData)
 
 	// generate HTML threat report
-	GenerateThreatReport(threatReport, peakActivity, lowActivity, statusCodesByIP)
+	if err := GenerateThreatReport(threatReport, findings, peakActivity, lowActivity, statusCodesByIP); err != nil {
+		os.Exit(ExitExportError)
+	}
+
+	// a breach fails the run but the report still stands
+	if !CheckGates(threatReport, findings, gates) {
+		os.Exit(ExitGateFailed)
+	}
 }